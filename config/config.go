@@ -10,20 +10,25 @@ import (
 	"os"
 	"runtime"
 	"strconv"
+	"strings"
 )
 
 type App struct {
 	SegmentDuration      int    `toml:"segment_duration"`
+	SegmentSilenceWindow int    `toml:"segment_silence_window"`
 	TranslateParallelNum int    `toml:"translate_parallel_num"`
 	Proxy                string `toml:"proxy"`
 	ParsedProxy          *url.URL
 	TranscribeProvider   string `toml:"transcribe_provider"`
 	LlmProvider          string `toml:"llm_provider"`
+	MaxConcurrentTasks   int    `toml:"max_concurrent_tasks"`
+	CaptionPolicy        string `toml:"caption_policy"`
 }
 
 type Server struct {
-	Host string `toml:"host"`
-	Port int    `toml:"port"`
+	Host        string `toml:"host"`
+	Port        int    `toml:"port"`
+	MaxUploadMb int    `toml:"max_upload_mb"`
 }
 
 type LocalModel struct {
@@ -64,9 +69,35 @@ type Aliyun struct {
 	Bailian AliyunBailian `toml:"bailian"`
 }
 
+type Storage struct {
+	TaskStore                string `toml:"task_store"`
+	DataDir                  string `toml:"data_dir"`
+	EnableTranscriptionCache bool   `toml:"enable_transcription_cache"`
+	EnableTranslationMemory  bool   `toml:"enable_translation_memory"`
+}
+
+type Retention struct {
+	TaskTtlHours           int  `toml:"task_ttl_hours"`
+	UploadTtlHours         int  `toml:"upload_ttl_hours"`
+	MaxDiskMb              int  `toml:"max_disk_mb"`
+	KeepOnlyFinalOutputs   bool `toml:"keep_only_final_outputs"`
+	CleanupIntervalMinutes int  `toml:"cleanup_interval_minutes"`
+}
+
+type Source struct {
+	AllowDomains      []string          `toml:"allow_domains"`
+	DenyDomains       []string          `toml:"deny_domains"`
+	AllowPrivateHosts []string          `toml:"allow_private_hosts"`
+	Cookies           map[string]string `toml:"cookies"`
+	MaxDownloadMb     int               `toml:"max_download_mb"`
+}
+
 type Config struct {
 	App        App        `toml:"app"`
 	Server     Server     `toml:"server"`
+	Storage    Storage    `toml:"storage"`
+	Retention  Retention  `toml:"retention"`
+	Source     Source     `toml:"source"`
 	LocalModel LocalModel `toml:"local_model"`
 	Openai     Openai     `toml:"openai"`
 	Aliyun     Aliyun     `toml:"aliyun"`
@@ -75,13 +106,32 @@ type Config struct {
 var Conf = Config{
 	App: App{
 		SegmentDuration:      5,
+		SegmentSilenceWindow: 30,
 		TranslateParallelNum: 5,
 		TranscribeProvider:   "openai",
 		LlmProvider:          "openai",
+		MaxConcurrentTasks:   2,
+		CaptionPolicy:        "never",
 	},
 	Server: Server{
-		Host: "127.0.0.1",
-		Port: 8888,
+		Host:        "127.0.0.1",
+		Port:        8888,
+		MaxUploadMb: 4096,
+	},
+	Storage: Storage{
+		TaskStore:                "file",
+		DataDir:                  "./data",
+		EnableTranscriptionCache: true,
+		EnableTranslationMemory:  true,
+	},
+	Retention: Retention{
+		CleanupIntervalMinutes: 30,
+	},
+	Source: Source{
+		Cookies: map[string]string{
+			"youtube": "./cookies.txt",
+		},
+		MaxDownloadMb: 4096,
 	},
 	LocalModel: LocalModel{
 		Whisper: "large-v2",
@@ -96,6 +146,11 @@ func loadFromEnv() {
 			Conf.App.SegmentDuration = duration
 		}
 	}
+	if v := os.Getenv("KRILLIN_SEGMENT_SILENCE_WINDOW"); v != "" {
+		if window, err := strconv.Atoi(v); err == nil {
+			Conf.App.SegmentSilenceWindow = window
+		}
+	}
 	if v := os.Getenv("KRILLIN_TRANSLATE_PARALLEL_NUM"); v != "" {
 		if num, err := strconv.Atoi(v); err == nil {
 			Conf.App.TranslateParallelNum = num
@@ -110,6 +165,14 @@ func loadFromEnv() {
 	if v := os.Getenv("KRILLIN_LLM_PROVIDER"); v != "" {
 		Conf.App.LlmProvider = v
 	}
+	if v := os.Getenv("KRILLIN_MAX_CONCURRENT_TASKS"); v != "" {
+		if num, err := strconv.Atoi(v); err == nil {
+			Conf.App.MaxConcurrentTasks = num
+		}
+	}
+	if v := os.Getenv("KRILLIN_CAPTION_POLICY"); v != "" {
+		Conf.App.CaptionPolicy = v
+	}
 
 	// Server 配置
 	if v := os.Getenv("KRILLIN_SERVER_HOST"); v != "" {
@@ -120,6 +183,72 @@ func loadFromEnv() {
 			Conf.Server.Port = port
 		}
 	}
+	if v := os.Getenv("KRILLIN_MAX_UPLOAD_MB"); v != "" {
+		if mb, err := strconv.Atoi(v); err == nil {
+			Conf.Server.MaxUploadMb = mb
+		}
+	}
+
+	// Storage 配置
+	if v := os.Getenv("KRILLIN_TASK_STORE"); v != "" {
+		Conf.Storage.TaskStore = v
+	}
+	if v := os.Getenv("KRILLIN_DATA_DIR"); v != "" {
+		Conf.Storage.DataDir = v
+	}
+	if v := os.Getenv("KRILLIN_ENABLE_TRANSCRIPTION_CACHE"); v != "" {
+		if enable, err := strconv.ParseBool(v); err == nil {
+			Conf.Storage.EnableTranscriptionCache = enable
+		}
+	}
+	if v := os.Getenv("KRILLIN_ENABLE_TRANSLATION_MEMORY"); v != "" {
+		if enable, err := strconv.ParseBool(v); err == nil {
+			Conf.Storage.EnableTranslationMemory = enable
+		}
+	}
+
+	// Retention 配置
+	if v := os.Getenv("KRILLIN_TASK_TTL_HOURS"); v != "" {
+		if hours, err := strconv.Atoi(v); err == nil {
+			Conf.Retention.TaskTtlHours = hours
+		}
+	}
+	if v := os.Getenv("KRILLIN_UPLOAD_TTL_HOURS"); v != "" {
+		if hours, err := strconv.Atoi(v); err == nil {
+			Conf.Retention.UploadTtlHours = hours
+		}
+	}
+	if v := os.Getenv("KRILLIN_MAX_DISK_MB"); v != "" {
+		if mb, err := strconv.Atoi(v); err == nil {
+			Conf.Retention.MaxDiskMb = mb
+		}
+	}
+	if v := os.Getenv("KRILLIN_KEEP_ONLY_FINAL_OUTPUTS"); v != "" {
+		if keep, err := strconv.ParseBool(v); err == nil {
+			Conf.Retention.KeepOnlyFinalOutputs = keep
+		}
+	}
+	if v := os.Getenv("KRILLIN_CLEANUP_INTERVAL_MINUTES"); v != "" {
+		if minutes, err := strconv.Atoi(v); err == nil {
+			Conf.Retention.CleanupIntervalMinutes = minutes
+		}
+	}
+
+	// Source 配置
+	if v := os.Getenv("KRILLIN_ALLOW_DOMAINS"); v != "" {
+		Conf.Source.AllowDomains = strings.Split(v, ",")
+	}
+	if v := os.Getenv("KRILLIN_DENY_DOMAINS"); v != "" {
+		Conf.Source.DenyDomains = strings.Split(v, ",")
+	}
+	if v := os.Getenv("KRILLIN_ALLOW_PRIVATE_HOSTS"); v != "" {
+		Conf.Source.AllowPrivateHosts = strings.Split(v, ",")
+	}
+	if v := os.Getenv("KRILLIN_MAX_DOWNLOAD_MB"); v != "" {
+		if mb, err := strconv.Atoi(v); err == nil {
+			Conf.Source.MaxDownloadMb = mb
+		}
+	}
 
 	// LocalModel 配置
 	if v := os.Getenv("KRILLIN_LOCAL_WHISPER"); v != "" {
@@ -215,6 +344,52 @@ func validateConfig() error {
 		return errors.New("不支持的LLM提供商")
 	}
 
+	if Conf.App.SegmentDuration <= 0 {
+		return errors.New("音频切分间隔必须大于0")
+	}
+	if Conf.App.SegmentSilenceWindow < 0 {
+		return errors.New("静音查找范围不能为负数")
+	}
+	// 切分间隔较短的旧配置使用默认的静音查找范围时自动缩小，不影响启动
+	if maxWindow := Conf.App.SegmentDuration*30 - 1; Conf.App.SegmentSilenceWindow > maxWindow {
+		log.GetLogger().Warn("静音查找范围需要小于音频切分间隔的一半，已自动调整",
+			zap.Int("segment_silence_window", Conf.App.SegmentSilenceWindow), zap.Int("adjusted", maxWindow))
+		Conf.App.SegmentSilenceWindow = maxWindow
+	}
+	if Conf.App.MaxConcurrentTasks <= 0 {
+		return errors.New("同时运行的任务数量必须大于0")
+	}
+	if Conf.App.CaptionPolicy != "never" && Conf.App.CaptionPolicy != "manual" && Conf.App.CaptionPolicy != "auto" {
+		return errors.New("不支持的平台字幕策略")
+	}
+
+	if Conf.Server.MaxUploadMb < 0 {
+		return errors.New("上传文件大小上限不能为负数")
+	}
+
+	// 检查任务存储配置
+	if Conf.Storage.TaskStore != "file" && Conf.Storage.TaskStore != "memory" {
+		return errors.New("不支持的任务存储方式")
+	}
+
+	// 检查清理配置
+	if Conf.Retention.TaskTtlHours < 0 || Conf.Retention.UploadTtlHours < 0 || Conf.Retention.MaxDiskMb < 0 {
+		return errors.New("清理配置不能为负数")
+	}
+	if Conf.Retention.CleanupIntervalMinutes <= 0 {
+		return errors.New("清理间隔必须大于0")
+	}
+
+	// 检查视频来源配置
+	for _, domain := range append(append(append([]string{}, Conf.Source.AllowDomains...), Conf.Source.DenyDomains...), Conf.Source.AllowPrivateHosts...) {
+		if strings.TrimSpace(domain) == "" {
+			return errors.New("视频来源域名不能为空")
+		}
+	}
+	if Conf.Source.MaxDownloadMb < 0 {
+		return errors.New("下载大小上限不能为负数")
+	}
+
 	return nil
 }
 
@@ -0,0 +1,20 @@
+package dto
+
+type StorageUsageResData struct {
+	TaskCount            int   `json:"task_count"`
+	TaskBytes            int64 `json:"task_bytes"`
+	UploadCount          int   `json:"upload_count"`
+	UploadBytes          int64 `json:"upload_bytes"`
+	TotalBytes           int64 `json:"total_bytes"`
+	MaxDiskBytes         int64 `json:"max_disk_bytes"` // 0表示不限制
+	TaskTtlHours         int   `json:"task_ttl_hours"`
+	UploadTtlHours       int   `json:"upload_ttl_hours"`
+	KeepOnlyFinalOutputs bool  `json:"keep_only_final_outputs"`
+}
+
+type StorageCleanupResData struct {
+	RemovedTasks   []string `json:"removed_tasks"`
+	RemovedUploads []string `json:"removed_uploads"`
+	FreedBytes     int64    `json:"freed_bytes"`
+	TotalBytes     int64    `json:"total_bytes"` // 清理后的占用
+}
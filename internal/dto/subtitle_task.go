@@ -1,22 +1,36 @@
 package dto
 
 type StartVideoSubtitleTaskReq struct {
-	AppId                   uint32   `json:"app_id"`
-	Url                     string   `json:"url"`
-	OriginLanguage          string   `json:"origin_lang"`
-	TargetLang              string   `json:"target_lang"`
-	Bilingual               uint8    `json:"bilingual"`
-	TranslationSubtitlePos  uint8    `json:"translation_subtitle_pos"`
-	ModalFilter             uint8    `json:"modal_filter"`
-	Tts                     uint8    `json:"tts"`
-	TtsVoiceCode            uint8    `json:"tts_voice_code"`
-	TtsVoiceCloneSrcFileUrl string   `json:"tts_voice_clone_src_file_url"`
-	Replace                 []string `json:"replace"`
-	Language                string   `json:"language"`
-	EmbedSubtitleVideoType  string   `json:"embed_subtitle_video_type"`
-	VerticalMajorTitle      string   `json:"vertical_major_title"`
-	VerticalMinorTitle      string   `json:"vertical_minor_title"`
+	AppId                     uint32   `json:"app_id"`
+	Url                       string   `json:"url"`
+	OriginLanguage            string   `json:"origin_lang"`
+	TargetLang                string   `json:"target_lang"`
+	Bilingual                 uint8    `json:"bilingual"`
+	TranslationSubtitlePos    uint8    `json:"translation_subtitle_pos"`
+	ModalFilter               uint8    `json:"modal_filter"`
+	Tts                       uint8    `json:"tts"`
+	TtsVoiceCode              uint8    `json:"tts_voice_code"`
+	TtsVoiceCloneSrcFileUrl   string   `json:"tts_voice_clone_src_file_url"`
+	Replace                   []string `json:"replace"`
+	Language                  string   `json:"language"`
+	EmbedSubtitleVideoType    string   `json:"embed_subtitle_video_type"`
+	VerticalMajorTitle        string   `json:"vertical_major_title"`
+	VerticalMinorTitle        string   `json:"vertical_minor_title"`
 	OriginLanguageWordOneLine int      `json:"origin_language_word_one_line"`
+	Priority                  int      `json:"priority"`        // 排队优先级，数值越大越先执行，默认0
+	CallbackUrl               string   `json:"callback_url"`    // 任务成功或失败后回调的地址，可不填
+	CallbackSecret            string   `json:"callback_secret"` // 回调签名密钥，填写后会在请求头中附带HMAC-SHA256签名
+	ForceRerun                bool     `json:"force_rerun"`     // 忽略已有的相同任务结果，重新执行
+	Steps                     []string `json:"steps"`           // 按顺序执行的步骤名，不填使用默认流程，可选：linkToFile,getVideoInfo,translateVideoInfo,generateChapters,audioToSubtitle,importSubtitle,srtFileToSpeech,embedSubtitles,uploadSubtitles
+	SubtitleUrl               string   `json:"subtitle_url"`    // 已有的源语言字幕（上传后得到的local:路径，支持srt、vtt），填写后跳过转录直接翻译，只需要字幕和配音时url可不填
+	CaptionPolicy             string   `json:"caption_policy"`  // 是否使用平台字幕代替语音识别：never,manual,auto，不填使用配置
+	ParentTaskId              string   `json:"-"`               // 由批量任务创建时所属的批量任务
+}
+
+type StartVideoSubtitleBatchReq struct {
+	StartVideoSubtitleTaskReq          // url为播放列表、合集或频道链接，其余参数对每个子任务生效
+	Urls                      []string `json:"urls"`      // 多个视频链接或上传后得到的local:路径，填写时忽略url
+	MaxItems                  int      `json:"max_items"` // 最多展开的视频数量，0表示不限制（最多500个）
 }
 
 type StartVideoSubtitleTaskResData struct {
@@ -29,16 +43,68 @@ type StartVideoSubtitleTaskRes struct {
 	Data  *StartVideoSubtitleTaskResData `json:"data"`
 }
 
+type ResumeVideoSubtitleTaskReq struct {
+	TaskId string `json:"task_id"`
+}
+
+type CancelVideoSubtitleTaskReq struct {
+	TaskId string `json:"task_id"`
+}
+
 type GetVideoSubtitleTaskReq struct {
 	TaskId string `form:"taskId"`
 }
 
+type ListVideoSubtitleTaskReq struct {
+	Status    uint8  `form:"status"`
+	Language  string `form:"language"`   // 源语言或目标语言
+	StartTime int64  `form:"start_time"` // 创建时间范围，unix秒
+	EndTime   int64  `form:"end_time"`
+	OrderBy   string `form:"order_by"` // create_time、update_time
+	Order     string `form:"order"`    // asc、desc，默认desc
+	Page      int    `form:"page"`
+	PageSize  int    `form:"page_size"`
+}
+
+type SubtitleTaskSummary struct {
+	TaskId         string `json:"task_id"`
+	VideoSrc       string `json:"video_src"`
+	OriginLanguage string `json:"origin_language"`
+	TargetLanguage string `json:"target_language"`
+	Status         uint8  `json:"status"`
+	ProcessPercent uint8  `json:"process_percent"`
+	FailReason     string `json:"fail_reason"`
+	TaskType       string `json:"task_type"`      // 空为单个视频任务，batch为批量任务
+	ParentTaskId   string `json:"parent_task_id"` // 所属的批量任务
+	CreateTime     int64  `json:"create_time"`
+	UpdateTime     int64  `json:"update_time"`
+}
+
+type ListVideoSubtitleTaskResData struct {
+	Total    int                    `json:"total"`
+	Page     int                    `json:"page"`
+	PageSize int                    `json:"page_size"`
+	List     []*SubtitleTaskSummary `json:"list"`
+}
+
 type VideoInfo struct {
-	Title                 string `json:"title"`
-	Description           string `json:"description"`
-	TranslatedTitle       string `json:"translated_title"`
-	TranslatedDescription string `json:"translated_description"`
-	Language              string `json:"language"`
+	Title                 string          `json:"title"`
+	Description           string          `json:"description"`
+	TranslatedTitle       string          `json:"translated_title"`
+	TranslatedDescription string          `json:"translated_description"`
+	Language              string          `json:"language"`
+	Duration              uint32          `json:"duration"`    // 秒
+	Cover                 string          `json:"cover"`       // 封面下载地址
+	Uploader              string          `json:"uploader"`    // 视频作者
+	UploadDate            string          `json:"upload_date"` // 发布日期，格式2006-01-02
+	Chapters              []*VideoChapter `json:"chapters"`
+}
+
+type VideoChapter struct {
+	StartTime       float64 `json:"start_time"` // 秒
+	EndTime         float64 `json:"end_time"`
+	Title           string  `json:"title"`
+	TranslatedTitle string  `json:"translated_title"`
 }
 
 type SubtitleInfo struct {
@@ -47,12 +113,95 @@ type SubtitleInfo struct {
 }
 
 type GetVideoSubtitleTaskResData struct {
-	TaskId            string          `json:"task_id"`
-	ProcessPercent    uint8           `json:"process_percent"`
-	VideoInfo         *VideoInfo      `json:"video_info"`
-	SubtitleInfo      []*SubtitleInfo `json:"subtitle_info"`
-	TargetLanguage    string          `json:"target_language"`
-	SpeechDownloadUrl string          `json:"speech_download_url"`
+	TaskId            string           `json:"task_id"`
+	Status            uint8            `json:"status"`
+	QueuePosition     int              `json:"queue_position"` // 排队中的任务所处位置，从1开始
+	ProcessPercent    uint8            `json:"process_percent"`
+	VideoInfo         *VideoInfo       `json:"video_info"`
+	SubtitleInfo      []*SubtitleInfo  `json:"subtitle_info"`
+	TargetLanguage    string           `json:"target_language"`
+	SpeechDownloadUrl string           `json:"speech_download_url"`
+	Timeline          []*TimelineEntry `json:"timeline"`
+	CacheHitTaskId    string           `json:"cache_hit_task_id"` // 结果复用自该任务，未命中缓存时为空
+	Files             []*TaskFile      `json:"files"`             // 任务成功后可下载的所有文件
+	TranscriptSource  string           `json:"transcript_source"` // 原文字幕来源：asr,manual_caption,auto_caption,imported
+	SrtNum            int              `json:"srt_num"`           // 原文字幕条数
+}
+
+type TaskFile struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	Type        string `json:"type"` // subtitle,speech,video,image,archive,text
+	DownloadUrl string `json:"download_url"`
+}
+
+type GetSubtitleTaskFileReq struct {
+	TaskId string `form:"task_id" binding:"required"`
+	FileId string `form:"file_id" binding:"required"`
+}
+
+type GetVideoSubtitleBatchResData struct {
+	TaskId             string                         `json:"task_id"`
+	VideoSrc           string                         `json:"video_src"`
+	Status             uint8                          `json:"status"`
+	ProcessPercent     uint8                          `json:"process_percent"` // 子任务进度的平均值，已结束的子任务按100计算
+	FailReason         string                         `json:"fail_reason"`
+	Expanding          bool                           `json:"expanding"` // 是否仍在展开播放列表或创建子任务
+	Total              int                            `json:"total"`
+	StatusCount        map[uint8]int                  `json:"status_count"`         // 各状态的子任务数量
+	Archiving          bool                           `json:"archiving"`            // 是否正在打包产物
+	ArchiveDownloadUrl string                         `json:"archive_download_url"` // 所有子任务结束后生成的压缩包，包含manifest.json
+	Children           []*GetVideoSubtitleTaskResData `json:"children"`
+}
+
+type TimelineEntry struct {
+	Kind       string `json:"kind"` // step、transcribe、translate、tts
+	Name       string `json:"name"`
+	Segment    int    `json:"segment"`
+	StartTime  int64  `json:"start_time"`
+	EndTime    int64  `json:"end_time"`
+	DurationMs int64  `json:"duration_ms"`
+	Attempts   int    `json:"attempts"`
+	Error      string `json:"error"`
+}
+
+type SubtitleTaskEvent struct {
+	Type           string                       `json:"type"`
+	TaskId         string                       `json:"task_id"`
+	Status         uint8                        `json:"status"`
+	ProcessPercent uint8                        `json:"process_percent"`
+	Stage          string                       `json:"stage,omitempty"`   // 当前所处步骤
+	Current        int                          `json:"current,omitempty"` // 当前进度，含义随事件类型变化，如第几段音频、第几条字幕、已编码秒数
+	Total          int                          `json:"total,omitempty"`
+	Message        string                       `json:"message,omitempty"`
+	Result         *GetVideoSubtitleTaskResData `json:"result,omitempty"` // 任务成功时的结果
+	Time           int64                        `json:"time"`
+}
+
+type SubtitleTaskWebhookPayload struct {
+	Event      string                       `json:"event"` // succeeded、failed
+	TaskId     string                       `json:"task_id"`
+	Status     uint8                        `json:"status"`
+	FailReason string                       `json:"fail_reason"`
+	Data       *GetVideoSubtitleTaskResData `json:"data"`
+	Time       int64                        `json:"time"`
+}
+
+type WebhookDelivery struct {
+	DeliveryId string `json:"delivery_id"`
+	Event      string `json:"event"`
+	Attempt    int    `json:"attempt"`
+	StatusCode int    `json:"status_code"`
+	Error      string `json:"error"`
+	Success    bool   `json:"success"`
+	DurationMs int64  `json:"duration_ms"`
+	Time       int64  `json:"time"`
+}
+
+type GetSubtitleTaskWebhooksResData struct {
+	TaskId      string             `json:"task_id"`
+	CallbackUrl string             `json:"callback_url"`
+	Deliveries  []*WebhookDelivery `json:"deliveries"`
 }
 
 type GetVideoSubtitleTaskRes struct {
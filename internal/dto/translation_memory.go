@@ -0,0 +1,10 @@
+package dto
+
+type ExportTranslationMemoryReq struct {
+	TargetLang string `form:"target_lang"` // 为空时导出全部目标语言
+}
+
+type ImportTranslationMemoryResData struct {
+	Total int `json:"total"` // 文件中的记录数
+	Added int `json:"added"` // 新增或更新的记录数
+}
@@ -0,0 +1,34 @@
+package dto
+
+type InitChunkedUploadReq struct {
+	FileName string `json:"file_name" binding:"required"`
+	FileSize int64  `json:"file_size" binding:"required"`
+	Sha256   string `json:"sha256"` // 文件的sha256，也可以在完成上传时再提供
+}
+
+type ChunkedUploadReq struct {
+	UploadId string `form:"upload_id" binding:"required"`
+}
+
+type UploadChunkReq struct {
+	UploadId string `form:"upload_id" binding:"required"`
+	Offset   *int64 `form:"offset" binding:"required"` // 本分片在文件中的起始位置，必须等于已上传的大小
+}
+
+type CompleteChunkedUploadReq struct {
+	UploadId string `json:"upload_id" binding:"required"`
+	Sha256   string `json:"sha256"`
+}
+
+type AbortChunkedUploadReq struct {
+	UploadId string `json:"upload_id" binding:"required"`
+}
+
+type ChunkedUploadResData struct {
+	UploadId  string `json:"upload_id"`
+	FileName  string `json:"file_name"`
+	FileSize  int64  `json:"file_size"`
+	Offset    int64  `json:"offset"`     // 已上传的大小，续传时从这里开始
+	ChunkSize int64  `json:"chunk_size"` // 建议的分片大小
+	FilePath  string `json:"file_path"`  // 完成后可作为任务url使用的local:路径
+}
@@ -0,0 +1,11 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"krillin-ai/internal/metrics"
+)
+
+func (h Handler) Metrics(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.WriteText(c.Writer)
+}
@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"krillin-ai/internal/response"
+)
+
+func (h Handler) GetStorageUsage(c *gin.Context) {
+	svc := h.Service
+	data, err := svc.GetStorageUsage()
+	if err != nil {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   err.Error(),
+			Data:  nil,
+		})
+		return
+	}
+	response.R(c, response.Response{
+		Error: 0,
+		Msg:   "成功",
+		Data:  data,
+	})
+}
+
+func (h Handler) CleanupStorage(c *gin.Context) {
+	svc := h.Service
+	data, err := svc.CleanupStorage()
+	if err != nil {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   err.Error(),
+			Data:  nil,
+		})
+		return
+	}
+	response.R(c, response.Response{
+		Error: 0,
+		Msg:   "成功",
+		Data:  data,
+	})
+}
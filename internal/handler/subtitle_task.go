@@ -1,13 +1,20 @@
 package handler
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
+	"io"
 	"krillin-ai/internal/dto"
 	"krillin-ai/internal/response"
-	"os"
+	"krillin-ai/internal/service"
+	"net/http"
 	"path/filepath"
+	"time"
 )
 
+// 普通上传的请求体在文件大小上限之外允许的multipart头部大小
+const uploadMultipartOverheadBytes = 1 << 20
+
 func (h Handler) StartSubtitleTask(c *gin.Context) {
 	var req dto.StartVideoSubtitleTaskReq
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -37,6 +44,122 @@ func (h Handler) StartSubtitleTask(c *gin.Context) {
 	})
 }
 
+func (h Handler) StartSubtitleBatchTask(c *gin.Context) {
+	var req dto.StartVideoSubtitleBatchReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   "参数错误",
+			Data:  nil,
+		})
+		return
+	}
+
+	svc := h.Service
+
+	data, err := svc.StartSubtitleBatchTask(req)
+	if err != nil {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   err.Error(),
+			Data:  nil,
+		})
+		return
+	}
+	response.R(c, response.Response{
+		Error: 0,
+		Msg:   "成功",
+		Data:  data,
+	})
+}
+
+func (h Handler) GetSubtitleBatchTask(c *gin.Context) {
+	var req dto.GetVideoSubtitleTaskReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   "参数错误",
+			Data:  nil,
+		})
+		return
+	}
+
+	svc := h.Service
+
+	data, err := svc.GetSubtitleBatchTask(req)
+	if err != nil {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   err.Error(),
+			Data:  nil,
+		})
+		return
+	}
+	response.R(c, response.Response{
+		Error: 0,
+		Msg:   "成功",
+		Data:  data,
+	})
+}
+
+func (h Handler) ResumeSubtitleTask(c *gin.Context) {
+	var req dto.ResumeVideoSubtitleTaskReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.TaskId == "" {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   "参数错误",
+			Data:  nil,
+		})
+		return
+	}
+
+	svc := h.Service
+
+	data, err := svc.ResumeSubtitleTask(req)
+	if err != nil {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   err.Error(),
+			Data:  nil,
+		})
+		return
+	}
+	response.R(c, response.Response{
+		Error: 0,
+		Msg:   "成功",
+		Data:  data,
+	})
+}
+
+func (h Handler) CancelSubtitleTask(c *gin.Context) {
+	var req dto.CancelVideoSubtitleTaskReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.TaskId == "" {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   "参数错误",
+			Data:  nil,
+		})
+		return
+	}
+
+	svc := h.Service
+
+	err := svc.CancelSubtitleTask(req)
+	if err != nil {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   err.Error(),
+			Data:  nil,
+		})
+		return
+	}
+	response.R(c, response.Response{
+		Error: 0,
+		Msg:   "成功",
+		Data:  nil,
+	})
+}
+
 func (h Handler) GetSubtitleTask(c *gin.Context) {
 	var req dto.GetVideoSubtitleTaskReq
 	if err := c.ShouldBindQuery(&req); err != nil {
@@ -64,31 +187,151 @@ func (h Handler) GetSubtitleTask(c *gin.Context) {
 	})
 }
 
-func (h Handler) UploadFile(c *gin.Context) {
-	file, err := c.FormFile("file")
+// SubtitleTaskEvents 通过SSE推送任务进度，连接建立后先推送一次最新快照，任务结束后关闭连接
+func (h Handler) SubtitleTaskEvents(c *gin.Context) {
+	var req dto.GetVideoSubtitleTaskReq
+	if err := c.ShouldBindQuery(&req); err != nil || req.TaskId == "" {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   "参数错误",
+			Data:  nil,
+		})
+		return
+	}
+	svc := h.Service
+	events, snapshot, unsubscribe, err := svc.SubscribeSubtitleTaskEvents(req.TaskId)
 	if err != nil {
 		response.R(c, response.Response{
 			Error: -1,
-			Msg:   "未能获取文件",
+			Msg:   err.Error(),
+			Data:  nil,
+		})
+		return
+	}
+	defer unsubscribe()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.SSEvent(snapshot.Type, snapshot)
+	c.Writer.Flush()
+	if service.IsSubtitleTaskEventFinal(*snapshot) {
+		return
+	}
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event := <-events:
+			c.SSEvent(event.Type, event)
+			return !service.IsSubtitleTaskEventFinal(event)
+		case <-heartbeat.C:
+			c.SSEvent("ping", time.Now().Unix())
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+func (h Handler) GetSubtitleTaskWebhooks(c *gin.Context) {
+	var req dto.GetVideoSubtitleTaskReq
+	if err := c.ShouldBindQuery(&req); err != nil || req.TaskId == "" {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   "参数错误",
+			Data:  nil,
+		})
+		return
+	}
+	svc := h.Service
+	data, err := svc.GetSubtitleTaskWebhooks(req)
+	if err != nil {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   err.Error(),
+			Data:  nil,
+		})
+		return
+	}
+	response.R(c, response.Response{
+		Error: 0,
+		Msg:   "成功",
+		Data:  data,
+	})
+}
+
+func (h Handler) ListSubtitleTasks(c *gin.Context) {
+	var req dto.ListVideoSubtitleTaskReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   "参数错误",
 			Data:  nil,
 		})
 		return
 	}
+	svc := h.Service
+	data, err := svc.ListSubtitleTasks(req)
+	if err != nil {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   err.Error(),
+			Data:  nil,
+		})
+		return
+	}
+	response.R(c, response.Response{
+		Error: 0,
+		Msg:   "成功",
+		Data:  data,
+	})
+}
 
-	savePath := "./uploads/" + file.Filename
-	if err = c.SaveUploadedFile(file, savePath); err != nil {
+// UploadFile 支持一次上传多个文件，file_path为第一个文件，批量任务可使用file_paths，一次请求的总大小受上传上限限制
+func (h Handler) UploadFile(c *gin.Context) {
+	if maxBytes := service.MaxUploadBytes(); maxBytes > 0 {
+		// 在解析前限制请求体，超出上限的文件不会写入内存或临时文件，另外留出multipart头部的空间
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes+uploadMultipartOverheadBytes)
+	}
+	form, err := c.MultipartForm()
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
 		response.R(c, response.Response{
 			Error: -1,
-			Msg:   "文件保存失败",
+			Msg:   service.ErrUploadTooLarge.Error(),
+			Data:  nil,
+		})
+		return
+	}
+	if err != nil || len(form.File["file"]) == 0 {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   "未能获取文件",
 			Data:  nil,
 		})
 		return
 	}
 
+	svc := h.Service
+	filePaths := make([]string, 0, len(form.File["file"]))
+	for _, file := range form.File["file"] {
+		filePath, err := svc.SaveUploadFile(file)
+		if err != nil {
+			response.R(c, response.Response{
+				Error: -1,
+				Msg:   err.Error(),
+				Data:  nil,
+			})
+			return
+		}
+		filePaths = append(filePaths, filePath)
+	}
+
 	response.R(c, response.Response{
 		Error: 0,
 		Msg:   "文件上传成功",
-		Data:  gin.H{"file_path": "local:" + savePath},
+		Data:  gin.H{"file_path": filePaths[0], "file_paths": filePaths},
 	})
 }
 
@@ -103,11 +346,36 @@ func (h Handler) DownloadFile(c *gin.Context) {
 		return
 	}
 
-	localFilePath := filepath.Join(".", requestedFile)
-	if _, err := os.Stat(localFilePath); os.IsNotExist(err) {
+	svc := h.Service
+	localFilePath, err := svc.ResolveDownloadPath(requestedFile)
+	if err != nil {
 		response.R(c, response.Response{
 			Error: -1,
-			Msg:   "文件不存在",
+			Msg:   err.Error(),
+			Data:  nil,
+		})
+		return
+	}
+	c.FileAttachment(localFilePath, filepath.Base(localFilePath))
+}
+
+func (h Handler) GetSubtitleTaskFile(c *gin.Context) {
+	var req dto.GetSubtitleTaskFileReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   "参数错误",
+			Data:  nil,
+		})
+		return
+	}
+
+	svc := h.Service
+	localFilePath, err := svc.GetSubtitleTaskFile(req)
+	if err != nil {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   err.Error(),
 			Data:  nil,
 		})
 		return
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"krillin-ai/internal/dto"
+	"krillin-ai/internal/response"
+	"net/http"
+)
+
+func (h Handler) ExportTranslationMemory(c *gin.Context) {
+	var req dto.ExportTranslationMemoryReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   "参数错误",
+			Data:  nil,
+		})
+		return
+	}
+
+	svc := h.Service
+	data, err := svc.ExportTranslationMemory(req)
+	if err != nil {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   err.Error(),
+			Data:  nil,
+		})
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename=translation_memory.tmx")
+	c.Data(http.StatusOK, "application/x-tmx+xml", data)
+}
+
+func (h Handler) ImportTranslationMemory(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   "未能获取文件",
+			Data:  nil,
+		})
+		return
+	}
+	src, err := file.Open()
+	if err != nil {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   "文件读取失败",
+			Data:  nil,
+		})
+		return
+	}
+	defer src.Close()
+
+	svc := h.Service
+	data, err := svc.ImportTranslationMemory(src)
+	if err != nil {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   err.Error(),
+			Data:  nil,
+		})
+		return
+	}
+	response.R(c, response.Response{
+		Error: 0,
+		Msg:   "成功",
+		Data:  data,
+	})
+}
@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"krillin-ai/internal/dto"
+	"krillin-ai/internal/response"
+	"krillin-ai/internal/service"
+	"net/http"
+)
+
+func (h Handler) InitChunkedUpload(c *gin.Context) {
+	var req dto.InitChunkedUploadReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   "参数错误",
+			Data:  nil,
+		})
+		return
+	}
+
+	svc := h.Service
+	data, err := svc.InitChunkedUpload(req)
+	if err != nil {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   err.Error(),
+			Data:  nil,
+		})
+		return
+	}
+	response.R(c, response.Response{
+		Error: 0,
+		Msg:   "成功",
+		Data:  data,
+	})
+}
+
+// UploadChunk 请求体为分片的原始内容
+func (h Handler) UploadChunk(c *gin.Context) {
+	var req dto.UploadChunkReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   "参数错误",
+			Data:  nil,
+		})
+		return
+	}
+
+	svc := h.Service
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, service.MaxUploadChunkBytes)
+	data, err := svc.UploadChunk(req, body)
+	if err != nil {
+		// 返回当前已上传的大小，便于客户端续传
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   err.Error(),
+			Data:  data,
+		})
+		return
+	}
+	response.R(c, response.Response{
+		Error: 0,
+		Msg:   "成功",
+		Data:  data,
+	})
+}
+
+func (h Handler) GetChunkedUpload(c *gin.Context) {
+	var req dto.ChunkedUploadReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   "参数错误",
+			Data:  nil,
+		})
+		return
+	}
+
+	svc := h.Service
+	data, err := svc.GetChunkedUpload(req)
+	if err != nil {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   err.Error(),
+			Data:  nil,
+		})
+		return
+	}
+	response.R(c, response.Response{
+		Error: 0,
+		Msg:   "成功",
+		Data:  data,
+	})
+}
+
+func (h Handler) CompleteChunkedUpload(c *gin.Context) {
+	var req dto.CompleteChunkedUploadReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   "参数错误",
+			Data:  nil,
+		})
+		return
+	}
+
+	svc := h.Service
+	data, err := svc.CompleteChunkedUpload(req)
+	if err != nil {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   err.Error(),
+			Data:  data,
+		})
+		return
+	}
+	response.R(c, response.Response{
+		Error: 0,
+		Msg:   "成功",
+		Data:  data,
+	})
+}
+
+func (h Handler) AbortChunkedUpload(c *gin.Context) {
+	var req dto.AbortChunkedUploadReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   "参数错误",
+			Data:  nil,
+		})
+		return
+	}
+
+	svc := h.Service
+	if err := svc.AbortChunkedUpload(req); err != nil {
+		response.R(c, response.Response{
+			Error: -1,
+			Msg:   err.Error(),
+			Data:  nil,
+		})
+		return
+	}
+	response.R(c, response.Response{
+		Error: 0,
+		Msg:   "成功",
+		Data:  nil,
+	})
+}
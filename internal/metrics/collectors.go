@@ -0,0 +1,28 @@
+package metrics
+
+// 耗时分桶，单位：秒
+var (
+	stepDurationBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200, 1800, 3600}
+	callDurationBuckets = []float64{0.5, 1, 2, 5, 10, 20, 30, 60, 120, 300}
+)
+
+var (
+	StepDuration = NewHistogramVec("krillin_step_duration_seconds", "Duration of subtitle task pipeline steps.", stepDurationBuckets, "step", "result")
+
+	TranscriptionRequests = NewCounterVec("krillin_transcription_requests_total", "Transcription calls by provider.", "provider")
+	TranscriptionErrors   = NewCounterVec("krillin_transcription_errors_total", "Failed transcription calls by provider.", "provider")
+	TranscriptionRetries  = NewCounterVec("krillin_transcription_retries_total", "Transcription retries by provider.", "provider")
+	TranscriptionDuration = NewHistogramVec("krillin_transcription_duration_seconds", "Duration of transcription calls by provider.", callDurationBuckets, "provider")
+
+	LlmRequests = NewCounterVec("krillin_llm_requests_total", "LLM chat completion calls by provider.", "provider")
+	LlmErrors   = NewCounterVec("krillin_llm_errors_total", "Failed LLM chat completion calls by provider.", "provider")
+	LlmRetries  = NewCounterVec("krillin_llm_retries_total", "LLM chat completion retries by provider.", "provider")
+	LlmDuration = NewHistogramVec("krillin_llm_duration_seconds", "Duration of LLM chat completion calls by provider.", callDurationBuckets, "provider")
+
+	TtsRequests = NewCounterVec("krillin_tts_requests_total", "Text to speech calls.")
+	TtsErrors   = NewCounterVec("krillin_tts_errors_total", "Failed text to speech calls.")
+
+	ProcessFailures = NewCounterVec("krillin_process_failures_total", "External process failures such as ffmpeg and yt-dlp.", "process")
+
+	DownloadedBytes = NewCounterVec("krillin_downloaded_bytes_total", "Bytes of source media downloaded.")
+)
@@ -0,0 +1,221 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 这里只实现了Prometheus文本格式中用到的counter、gauge、histogram，避免引入额外依赖
+
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []metric
+)
+
+func register(m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, m)
+}
+
+// WriteText 按Prometheus文本格式输出所有指标
+func WriteText(w io.Writer) {
+	registryMu.Lock()
+	metrics := append([]metric(nil), registry...)
+	registryMu.Unlock()
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+type labelValues struct {
+	values []string
+}
+
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// 文本格式中标签值需要转义反斜杠、双引号和换行，HELP只转义反斜杠和换行
+var (
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func formatLabels(names, values []string, extra ...string) string {
+	pairs := make([]string, 0, len(names)+len(extra)/2)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+labelValueEscaper.Replace(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+labelValueEscaper.Replace(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func writeHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, helpEscaper.Replace(help), name, typ)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// CounterVec 只增不减的计数器
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labelValues
+	value float64
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, values: make(map[string]*counterValue)}
+	if len(labels) == 0 {
+		// 无标签的计数器从0开始输出
+		c.values[""] = &counterValue{}
+	}
+	register(c)
+	return c
+}
+
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := labelKey(labelValues)
+	v, ok := c.values[key]
+	if !ok {
+		v = &counterValue{}
+		v.values = labelValues
+		c.values[key] = v
+	}
+	v.value += delta
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeHeader(w, c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.values) {
+		v := c.values[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, v.values), formatFloat(v.value))
+	}
+}
+
+// Sample 由GaugeFunc在采集时返回的一个值
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+// GaugeFunc 采集时才计算的指标，适合任务数量、磁盘占用这类由其他模块持有的状态
+type GaugeFunc struct {
+	name    string
+	help    string
+	labels  []string
+	collect func() []Sample
+}
+
+func NewGaugeFunc(name, help string, labels []string, collect func() []Sample) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, labels: labels, collect: collect}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	for _, sample := range g.collect() {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels(g.labels, sample.LabelValues), formatFloat(sample.Value))
+	}
+}
+
+// HistogramVec 按固定分桶统计耗时等分布
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labelValues
+	counts []uint64 // 与buckets一一对应，不含+Inf
+	count  uint64
+	sum    float64
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, values: make(map[string]*histogramValue)}
+	register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := labelKey(labelValues)
+	hv, ok := h.values[key]
+	if !ok {
+		hv = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		hv.values = labelValues
+		h.values[key] = hv
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			hv.counts[i]++
+		}
+	}
+	hv.count++
+	hv.sum += v
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeHeader(w, h.name, h.help, "histogram")
+	for _, key := range sortedKeys(h.values) {
+		hv := h.values[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, hv.values, "le", formatFloat(bound)), hv.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, hv.values, "le", "+Inf"), hv.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, hv.values), formatFloat(hv.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, hv.values), hv.count)
+	}
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestCounterVecWrite(t *testing.T) {
+	c := NewCounterVec("test_requests_total", "Requests.\nSecond line with \\ backslash.", "path")
+	c.Inc("/b")
+	c.Add(2.5, "/a")
+	c.Inc(`quote"back\slash` + "\nnewline é")
+
+	var b strings.Builder
+	c.write(&b)
+	want := `# HELP test_requests_total Requests.\nSecond line with \\ backslash.
+# TYPE test_requests_total counter
+test_requests_total{path="/a"} 2.5
+test_requests_total{path="/b"} 1
+test_requests_total{path="quote\"back\\slash\nnewline é"} 1
+`
+	if got := b.String(); got != want {
+		t.Errorf("write() =\n%s\nwant\n%s", got, want)
+	}
+
+	// 无标签的计数器从0开始输出
+	b.Reset()
+	NewCounterVec("test_plain_total", "Plain.").write(&b)
+	if want := "# HELP test_plain_total Plain.\n# TYPE test_plain_total counter\ntest_plain_total 0\n"; b.String() != want {
+		t.Errorf("write() =\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestGaugeFuncWrite(t *testing.T) {
+	g := NewGaugeFunc("test_tasks", "Tasks by status.", []string{"status"}, func() []Sample {
+		return []Sample{
+			{LabelValues: []string{"queued"}, Value: 3},
+			{LabelValues: []string{"running"}, Value: math.Inf(1)},
+			{LabelValues: []string{"failed"}, Value: math.Inf(-1)},
+		}
+	})
+
+	var b strings.Builder
+	g.write(&b)
+	want := `# HELP test_tasks Tasks by status.
+# TYPE test_tasks gauge
+test_tasks{status="queued"} 3
+test_tasks{status="running"} +Inf
+test_tasks{status="failed"} -Inf
+`
+	if got := b.String(); got != want {
+		t.Errorf("write() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogramVecWrite(t *testing.T) {
+	h := NewHistogramVec("test_duration_seconds", "Duration.", []float64{0.5, 1, 10}, "step", "result")
+	h.Observe(0.2, "split", "success")
+	h.Observe(1, "split", "success")
+	h.Observe(30, "split", "success")
+	h.Observe(5, "tts", "failure")
+
+	var b strings.Builder
+	h.write(&b)
+	want := `# HELP test_duration_seconds Duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{step="split",result="success",le="0.5"} 1
+test_duration_seconds_bucket{step="split",result="success",le="1"} 2
+test_duration_seconds_bucket{step="split",result="success",le="10"} 2
+test_duration_seconds_bucket{step="split",result="success",le="+Inf"} 3
+test_duration_seconds_sum{step="split",result="success"} 31.2
+test_duration_seconds_count{step="split",result="success"} 3
+test_duration_seconds_bucket{step="tts",result="failure",le="0.5"} 0
+test_duration_seconds_bucket{step="tts",result="failure",le="1"} 0
+test_duration_seconds_bucket{step="tts",result="failure",le="10"} 1
+test_duration_seconds_bucket{step="tts",result="failure",le="+Inf"} 1
+test_duration_seconds_sum{step="tts",result="failure"} 5
+test_duration_seconds_count{step="tts",result="failure"} 1
+`
+	if got := b.String(); got != want {
+		t.Errorf("write() =\n%s\nwant\n%s", got, want)
+	}
+}
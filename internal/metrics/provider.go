@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"context"
+	"krillin-ai/internal/types"
+	"time"
+)
+
+// 包装转录和LLM接口，所有provider无需改动即可统计调用次数、错误和耗时
+
+type instrumentedTranscriber struct {
+	provider string
+	next     types.Transcriber
+}
+
+func NewInstrumentedTranscriber(provider string, next types.Transcriber) types.Transcriber {
+	return &instrumentedTranscriber{provider: provider, next: next}
+}
+
+func (t *instrumentedTranscriber) Transcription(ctx context.Context, audioFile, language, wordDir string) (*types.TranscriptionData, error) {
+	start := time.Now()
+	TranscriptionRequests.Inc(t.provider)
+	data, err := t.next.Transcription(ctx, audioFile, language, wordDir)
+	TranscriptionDuration.Observe(time.Since(start).Seconds(), t.provider)
+	if err != nil {
+		TranscriptionErrors.Inc(t.provider)
+	}
+	return data, err
+}
+
+type instrumentedChatCompleter struct {
+	provider string
+	next     types.ChatCompleter
+}
+
+func NewInstrumentedChatCompleter(provider string, next types.ChatCompleter) types.ChatCompleter {
+	return &instrumentedChatCompleter{provider: provider, next: next}
+}
+
+func (c *instrumentedChatCompleter) ChatCompletion(ctx context.Context, query string) (string, error) {
+	start := time.Now()
+	LlmRequests.Inc(c.provider)
+	result, err := c.next.ChatCompletion(ctx, query)
+	LlmDuration.Observe(time.Since(start).Seconds(), c.provider)
+	if err != nil {
+		LlmErrors.Inc(c.provider)
+	}
+	return result, err
+}
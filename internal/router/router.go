@@ -15,10 +15,32 @@ func SetupRouter(r *gin.Engine) {
 	{
 		api.POST("/capability/subtitleTask", hdl.StartSubtitleTask)
 		api.GET("/capability/subtitleTask", hdl.GetSubtitleTask)
+		api.GET("/capability/subtitleTask/list", hdl.ListSubtitleTasks)
+		api.POST("/capability/subtitleTask/batch", hdl.StartSubtitleBatchTask)
+		api.GET("/capability/subtitleTask/batch", hdl.GetSubtitleBatchTask)
+		api.GET("/capability/subtitleTask/events", hdl.SubtitleTaskEvents)
+		api.GET("/capability/subtitleTask/webhooks", hdl.GetSubtitleTaskWebhooks)
+		api.POST("/capability/subtitleTask/resume", hdl.ResumeSubtitleTask)
+		api.POST("/capability/subtitleTask/cancel", hdl.CancelSubtitleTask)
+		api.GET("/capability/subtitleTask/file", hdl.GetSubtitleTaskFile)
 		api.POST("/file", hdl.UploadFile)
 		api.GET("/file/*filepath", hdl.DownloadFile)
+
+		api.POST("/upload/init", hdl.InitChunkedUpload)
+		api.PUT("/upload/chunk", hdl.UploadChunk)
+		api.GET("/upload/status", hdl.GetChunkedUpload)
+		api.POST("/upload/complete", hdl.CompleteChunkedUpload)
+		api.POST("/upload/abort", hdl.AbortChunkedUpload)
+
+		api.GET("/admin/storage", hdl.GetStorageUsage)
+		api.POST("/admin/storage/cleanup", hdl.CleanupStorage)
+
+		api.GET("/translationMemory/tmx", hdl.ExportTranslationMemory)
+		api.POST("/translationMemory/tmx", hdl.ImportTranslationMemory)
 	}
 
+	r.GET("/metrics", hdl.Metrics)
+
 	r.GET("/", func(c *gin.Context) {
 		c.Redirect(http.StatusMovedPermanently, "/static")
 	})
@@ -6,6 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"krillin-ai/config"
+	"krillin-ai/internal/dto"
+	"krillin-ai/internal/metrics"
 	"krillin-ai/internal/storage"
 	"krillin-ai/internal/types"
 	"krillin-ai/log"
@@ -24,6 +26,12 @@ import (
 )
 
 func (s Service) audioToSubtitle(ctx context.Context, stepParam *types.SubtitleTaskStepParam) error {
+	// linkToFile获取到了平台字幕时直接使用，不再语音识别
+	if stepParam.SubtitleSourcePath != "" {
+		return s.importSubtitle(ctx, stepParam)
+	}
+	stepParam.TranscriptSource = types.TranscriptSourceAsr
+	recordTranscriptSource(stepParam)
 	var err error
 	err = s.splitAudio(ctx, stepParam)
 	if err != nil {
@@ -38,76 +46,152 @@ func (s Service) audioToSubtitle(ctx context.Context, stepParam *types.SubtitleT
 		return fmt.Errorf("audioToSubtitle splitSrt error: %w", err)
 	}
 	// 更新字幕任务信息
-	storage.SubtitleTasks[stepParam.TaskId].ProcessPct = 95
+	updateTaskProcessPct(stepParam.TaskId, 95)
 	return nil
 }
 
 func (s Service) splitAudio(ctx context.Context, stepParam *types.SubtitleTaskStepParam) error {
 	log.GetLogger().Info("audioToSubtitle.splitAudio start", zap.String("task id", stepParam.TaskId))
-	var err error
+	duration, err := util.GetAudioDuration(stepParam.AudioFilePath)
+	if err != nil {
+		log.GetLogger().Error("audioToSubtitle splitAudio GetAudioDuration err", zap.Any("stepParam", stepParam), zap.Error(err))
+		return fmt.Errorf("audioToSubtitle splitAudio GetAudioDuration err: %w", err)
+	}
+	segmentDuration := float64(config.Conf.App.SegmentDuration * 60)
+	silenceWindow := float64(config.Conf.App.SegmentSilenceWindow)
+
+	// 在目标切分位置附近的静音处切分，避免把一句话切成两段
+	var silences []silenceInterval
+	if silenceWindow > 0 && duration > segmentDuration {
+		silences, err = detectSilences(ctx, stepParam.AudioFilePath)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.GetLogger().Warn("audioToSubtitle splitAudio detectSilences err, 按固定间隔切分", zap.String("task id", stepParam.TaskId), zap.Error(err))
+		}
+	}
+	splitPoints := chooseSplitPoints(duration, segmentDuration, silenceWindow, silences)
+	log.GetLogger().Info("audioToSubtitle splitAudio split points", zap.String("task id", stepParam.TaskId),
+		zap.Int("silence num", len(silences)), zap.Float64s("split points", splitPoints))
+
 	// 使用ffmpeg分割音频
 	outputPattern := filepath.Join(stepParam.TaskBasePath, types.SubtitleTaskSplitAudioFileNamePattern) // 输出文件格式
-	segmentDuration := config.Conf.App.SegmentDuration * 60
-
-	cmd := exec.Command(
-		storage.FfmpegPath,
+	segmentListPath := filepath.Join(stepParam.TaskBasePath, types.SubtitleTaskSplitAudioListFileName)
+	cmdArgs := []string{
 		"-i", stepParam.AudioFilePath, // 输入
 		"-f", "segment", // 输出文件格式为分段
-		"-segment_time", fmt.Sprintf("%d", segmentDuration), // 每段时长（以秒为单位）
+	}
+	if len(splitPoints) > 0 {
+		cmdArgs = append(cmdArgs, "-segment_times", formatSplitPoints(splitPoints)) // 各切分点（以秒为单位）
+	} else {
+		cmdArgs = append(cmdArgs, "-segment_time", fmt.Sprintf("%d", config.Conf.App.SegmentDuration*60)) // 不足一段，整体输出
+	}
+	cmdArgs = append(cmdArgs,
+		"-segment_list", segmentListPath, // 记录每段的实际起止时间
+		"-segment_list_type", "csv",
 		"-reset_timestamps", "1", // 重置每段时间戳
 		"-y", // 覆盖输出文件
 		outputPattern,
 	)
+	cmd := exec.CommandContext(ctx, storage.FfmpegPath, cmdArgs...)
 	err = cmd.Run()
 	if err != nil {
+		recordProcessFailure(ctx, cmd)
 		log.GetLogger().Error("audioToSubtitle splitAudio ffmpeg err", zap.Any("stepParam", stepParam), zap.Error(err))
 		return fmt.Errorf("audioToSubtitle splitAudio ffmpeg err: %w", err)
 	}
 
 	// 获取分割后的文件列表
-	audioFiles, err := filepath.Glob(filepath.Join(stepParam.TaskBasePath, fmt.Sprintf("%s_*.mp3", types.SubtitleTaskSplitAudioFileNamePrefix)))
+	segments, err := readSegmentList(segmentListPath)
 	if err != nil {
-		log.GetLogger().Error("audioToSubtitle splitAudio filepath.Glob err", zap.Any("stepParam", stepParam), zap.Error(err))
-		return fmt.Errorf("audioToSubtitle splitAudio filepath.Glob err: %w", err)
+		log.GetLogger().Error("audioToSubtitle splitAudio readSegmentList err", zap.Any("stepParam", stepParam), zap.Error(err))
+		return fmt.Errorf("audioToSubtitle splitAudio readSegmentList err: %w", err)
 	}
-	if len(audioFiles) == 0 {
+	if len(segments) == 0 {
 		log.GetLogger().Error("audioToSubtitle splitAudio no audio files found", zap.Any("stepParam", stepParam))
 		return errors.New("audioToSubtitle splitAudio no audio files found")
 	}
 
-	num := 1
-	for _, audioFile := range audioFiles {
+	for i, segment := range segments {
 		stepParam.SmallAudios = append(stepParam.SmallAudios, &types.SmallAudio{
-			AudioFile: audioFile,
-			Num:       num,
+			AudioFile:   filepath.Join(stepParam.TaskBasePath, filepath.Base(segment.FileName)),
+			Num:         i + 1,
+			StartOffset: segment.Start,
 		})
-		num++
 	}
 
 	// 更新字幕任务信息
-	storage.SubtitleTasks[stepParam.TaskId].ProcessPct = 20
+	updateTaskProcessPct(stepParam.TaskId, 20)
 
 	log.GetLogger().Info("audioToSubtitle.splitAudio end", zap.String("task id", stepParam.TaskId))
 	return nil
 }
 
+// transcribeAudioFile 转录一段音频，开启转录缓存时优先读取缓存，返回调用转录服务的次数
+func (s Service) transcribeAudioFile(ctx context.Context, taskId, audioFile, language, wordDir string) (*types.TranscriptionData, int, error) {
+	cacheKey := ""
+	if storage.TranscriptionCacheStore != nil {
+		key, err := storage.TranscriptionCacheKey(audioFile, config.Conf.App.TranscribeProvider, transcriptionModelName(), language)
+		if err != nil {
+			log.GetLogger().Error("transcribeAudioFile TranscriptionCacheKey err", zap.String("audio file", audioFile), zap.Error(err))
+		} else if transcriptionData, cached := storage.TranscriptionCacheStore.Get(key); cached {
+			log.GetLogger().Info("transcribeAudioFile 命中转录缓存", zap.String("taskId", taskId), zap.String("audio file", audioFile))
+			return transcriptionData, 0, nil
+		} else {
+			cacheKey = key
+		}
+	}
+
+	var (
+		transcriptionData *types.TranscriptionData
+		attempts          int
+		err               error
+	)
+	for i := 0; i < 3; i++ {
+		attempts++
+		transcriptionData, err = s.Transcriber.Transcription(ctx, audioFile, language, wordDir)
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+	metrics.TranscriptionRetries.Add(float64(attempts-1), config.Conf.App.TranscribeProvider)
+	// 空结果可能是服务异常，不缓存
+	if err == nil && cacheKey != "" && transcriptionData.Text != "" {
+		if putErr := storage.TranscriptionCacheStore.Put(cacheKey, transcriptionData); putErr != nil {
+			log.GetLogger().Error("transcribeAudioFile TranscriptionCache Put err", zap.String("audio file", audioFile), zap.Error(putErr))
+		}
+	}
+	return transcriptionData, attempts, err
+}
+
 func (s Service) audioToSrt(ctx context.Context, stepParam *types.SubtitleTaskStepParam) error {
 	log.GetLogger().Info("audioToSubtitle.audioToSrt start", zap.Any("taskId", stepParam.TaskId))
 	var (
 		cancel              context.CancelFunc
 		stepNum             = 0
+		transcribedNum      = 0 // 已完成转录的音频段数
+		translatedNum       = 0 // 已完成翻译的音频段数
 		parallelControlChan = make(chan struct{}, config.Conf.App.TranslateParallelNum)
 		eg                  *errgroup.Group
 		stepNumMu           sync.Mutex
 		err                 error
 	)
+	taskCtx := ctx // 任务本身的ctx，errgroup的ctx在Wait返回后总会被取消，不能用于判断任务是否被取消
 	ctx, cancel = context.WithCancel(ctx)
 	defer cancel()
 	eg, ctx = errgroup.WithContext(ctx)
 	for _, audioFileItem := range stepParam.SmallAudios {
-		parallelControlChan <- struct{}{}
+		select {
+		case parallelControlChan <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
 		audioFile := audioFileItem
 		eg.Go(func() error {
+			var err error
 			defer func() {
 				<-parallelControlChan
 				if r := recover(); r != nil {
@@ -120,17 +204,13 @@ func (s Service) audioToSrt(ctx context.Context, stepParam *types.SubtitleTaskSt
 			default:
 			}
 			// 语音转文字
-			var transcriptionData *types.TranscriptionData
-			for i := 0; i < 3; i++ {
-				language := string(stepParam.OriginLanguage)
-				if language == "zh_cn" {
-					language = "zh" // 切换一下
-				}
-				transcriptionData, err = s.Transcriber.Transcription(audioFile.AudioFile, language, stepParam.TaskBasePath)
-				if err == nil {
-					break
-				}
+			span := startTimelineSpan(stepParam.TaskId, types.TimelineKindTranscribe, "audioToSrt", audioFile.Num)
+			language := string(stepParam.OriginLanguage)
+			if language == "zh_cn" {
+				language = "zh" // 切换一下
 			}
+			transcriptionData, attempts, err := s.transcribeAudioFile(ctx, stepParam.TaskId, audioFile.AudioFile, language, stepParam.TaskBasePath)
+			span.finish(attempts, err)
 			if err != nil {
 				cancel()
 				log.GetLogger().Error("audioToSubtitle audioToSrt Transcription err", zap.Any("stepParam", stepParam), zap.String("audio file", audioFile.AudioFile), zap.Error(err))
@@ -146,12 +226,20 @@ func (s Service) audioToSrt(ctx context.Context, stepParam *types.SubtitleTaskSt
 			// 更新字幕任务信息
 			stepNumMu.Lock()
 			stepNum++
+			transcribedNum++
 			processPct := uint8(20 + 70*stepNum/(len(stepParam.SmallAudios)*2))
+			current := transcribedNum
 			stepNumMu.Unlock()
-			storage.SubtitleTasks[stepParam.TaskId].ProcessPct = processPct
+			updateTaskProcessPct(stepParam.TaskId, processPct)
+			publishTaskEvent(dto.SubtitleTaskEvent{
+				Type:    SubtitleTaskEventSegmentTranscribed,
+				TaskId:  stepParam.TaskId,
+				Current: current,
+				Total:   len(stepParam.SmallAudios),
+			})
 
 			// 拆分字幕并翻译
-			err = s.splitTextAndTranslate(stepParam.TaskId, stepParam.TaskBasePath, stepParam.TargetLanguage, stepParam.EnableModalFilter, audioFile)
+			err = s.splitTextAndTranslate(ctx, stepParam.TaskId, stepParam.TaskBasePath, stepParam.TargetLanguage, stepParam.EnableModalFilter, audioFile)
 			if err != nil {
 				cancel()
 				log.GetLogger().Error("audioToSubtitle audioToSrt splitTextAndTranslate err", zap.Any("stepParam", stepParam), zap.String("audio file", audioFile.AudioFile), zap.Error(err))
@@ -160,10 +248,18 @@ func (s Service) audioToSrt(ctx context.Context, stepParam *types.SubtitleTaskSt
 
 			stepNumMu.Lock()
 			stepNum++
+			translatedNum++
 			processPct = uint8(20 + 70*stepNum/(len(stepParam.SmallAudios)*2))
+			current = translatedNum
 			stepNumMu.Unlock()
 
-			storage.SubtitleTasks[stepParam.TaskId].ProcessPct = processPct
+			updateTaskProcessPct(stepParam.TaskId, processPct)
+			publishTaskEvent(dto.SubtitleTaskEvent{
+				Type:    SubtitleTaskEventSegmentTranslated,
+				TaskId:  stepParam.TaskId,
+				Current: current,
+				Total:   len(stepParam.SmallAudios),
+			})
 
 			// 生成时间戳
 			err = s.generateTimestamps(stepParam.TaskId, stepParam.TaskBasePath, stepParam.OriginLanguage, stepParam.SubtitleResultType, audioFile, stepParam.MaxWordOneLine)
@@ -180,6 +276,9 @@ func (s Service) audioToSrt(ctx context.Context, stepParam *types.SubtitleTaskSt
 		log.GetLogger().Error("audioToSubtitle audioToSrt eg.Wait err", zap.Any("taskId", stepParam.TaskId), zap.Error(err))
 		return fmt.Errorf("audioToSubtitle audioToSrt eg.Wait err: %w", err)
 	}
+	if taskCtx.Err() != nil {
+		return fmt.Errorf("audioToSubtitle audioToSrt canceled: %w", taskCtx.Err())
+	}
 
 	// 合并文件
 	originNoTsFiles := make([]string, 0)
@@ -238,7 +337,7 @@ func (s Service) audioToSrt(ctx context.Context, stepParam *types.SubtitleTaskSt
 	stepParam.BilingualSrtFilePath = bilingualFile
 
 	// 更新字幕任务信息
-	storage.SubtitleTasks[stepParam.TaskId].ProcessPct = 90
+	updateTaskProcessPct(stepParam.TaskId, 90)
 
 	log.GetLogger().Info("audioToSubtitle.audioToSrt end", zap.Any("taskId", stepParam.TaskId))
 
@@ -292,7 +391,10 @@ func (s Service) splitSrt(ctx context.Context, stepParam *types.SubtitleTaskStep
 	isTargetOnTop := stepParam.SubtitleResultType == types.SubtitleResultTypeBilingualTranslationOnTop
 
 	scanner := bufio.NewScanner(file)
-	var block []string
+	var (
+		block    []string
+		blockNum int
+	)
 
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -300,6 +402,7 @@ func (s Service) splitSrt(ctx context.Context, stepParam *types.SubtitleTaskStep
 		if line == "" {
 			if len(block) > 0 {
 				util.ProcessBlock(block, targetLanguageSrtFile, targetLanguageTextFile, originLanguageSrtFile, originLanguageTextFile, isTargetOnTop)
+				blockNum++
 				block = nil
 			}
 		} else {
@@ -309,12 +412,16 @@ func (s Service) splitSrt(ctx context.Context, stepParam *types.SubtitleTaskStep
 	// 处理文件末尾的字幕块
 	if len(block) > 0 {
 		util.ProcessBlock(block, targetLanguageSrtFile, targetLanguageTextFile, originLanguageSrtFile, originLanguageTextFile, isTargetOnTop)
+		blockNum++
 	}
 
 	if err = scanner.Err(); err != nil {
 		log.GetLogger().Error("audioToSubtitle splitSrt scan bilingual srt file error", zap.Any("stepParam", stepParam), zap.Error(err))
 		return fmt.Errorf("audioToSubtitle splitSrt scan bilingual srt file error: %w", err)
 	}
+	updateTask(stepParam.TaskId, func(task *types.SubtitleTask) {
+		task.SrtNum = blockNum
+	})
 	// 添加原语言单语字幕
 	subtitleInfo := types.SubtitleFileInfo{
 		Path:               originLanguageSrtFilePath,
@@ -662,7 +769,7 @@ func (s Service) generateTimestamps(taskId, basePath string, originLanguage type
 			continue
 		}
 
-		tsOffset := float64(config.Conf.App.SegmentDuration) * 60 * float64(audioFile.Num-1)
+		tsOffset := smallAudioStartOffset(audioFile)
 		srtBlock.Timestamp = fmt.Sprintf("%s --> %s", util.FormatTime(float32(sentenceTs.Start+tsOffset)), util.FormatTime(float32(sentenceTs.End+tsOffset)))
 
 		// 生成短句子的英文字幕
@@ -817,7 +924,7 @@ func (s Service) generateTimestamps(taskId, basePath string, originLanguage type
 	return nil
 }
 
-func (s Service) splitTextAndTranslate(taskId, baseTaskPath string, targetLanguage types.StandardLanguageName, enableModalFilter bool, audioFile *types.SmallAudio) error {
+func (s Service) splitTextAndTranslate(ctx context.Context, taskId, baseTaskPath string, targetLanguage types.StandardLanguageName, enableModalFilter bool, audioFile *types.SmallAudio) error {
 	var (
 		splitContent string
 		splitPrompt  string
@@ -831,9 +938,18 @@ func (s Service) splitTextAndTranslate(taskId, baseTaskPath string, targetLangua
 	if audioFile.TranscriptionData.Text == "" {
 		splitContent = ""
 	} else {
+		// 翻译记忆中相同或相近的句子作为参考，保持同一系列内容的译法一致
+		splitPrompt = translationMemoryReferences(targetLanguage, audioFile.TranscriptionData.Text) + splitPrompt
+		span := startTimelineSpan(taskId, types.TimelineKindTranslate, "splitTextAndTranslate", audioFile.Num)
+		attempts := 0
 		// 最多尝试4次获取有效的翻译结果
 		for i := 0; i < 4; i++ {
-			splitContent, err = s.ChatCompleter.ChatCompletion(splitPrompt + audioFile.TranscriptionData.Text)
+			attempts++
+			splitContent, err = s.ChatCompleter.ChatCompletion(ctx, splitPrompt+audioFile.TranscriptionData.Text)
+			if ctx.Err() != nil {
+				span.finish(attempts, ctx.Err())
+				return ctx.Err()
+			}
 			if err != nil {
 				log.GetLogger().Warn("audioToSubtitle splitTextAndTranslate ChatCompletion error, retrying...",
 					zap.Any("taskId", taskId), zap.Int("attempt", i+1), zap.Error(err))
@@ -849,11 +965,19 @@ func (s Service) splitTextAndTranslate(taskId, baseTaskPath string, targetLangua
 				zap.Any("taskId", taskId), zap.Int("attempt", i+1))
 			err = fmt.Errorf("invalid split content format or content mismatch")
 		}
+		span.finish(attempts, err)
+		metrics.LlmRetries.Add(float64(attempts-1), config.Conf.App.LlmProvider)
 
 		if err != nil {
 			log.GetLogger().Error("audioToSubtitle splitTextAndTranslate failed after retries", zap.Any("taskId", taskId), zap.Error(err))
 			return fmt.Errorf("audioToSubtitle splitTextAndTranslate error: %w", err)
 		}
+
+		var reused int
+		splitContent, reused = applyTranslationMemory(targetLanguage, splitContent)
+		if reused > 0 {
+			log.GetLogger().Info("audioToSubtitle splitTextAndTranslate 复用翻译记忆", zap.Any("taskId", taskId), zap.Int("num", audioFile.Num), zap.Int("reused", reused))
+		}
 	}
 
 	// 保存不带时间戳的原始字幕
@@ -1,7 +1,15 @@
 package service
 
 import (
+	"context"
+	"go.uber.org/zap"
+	"krillin-ai/config"
+	"krillin-ai/internal/storage"
+	"krillin-ai/internal/types"
+	"krillin-ai/log"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -27,3 +35,48 @@ func Test_isValidSplitContent(t *testing.T) {
 		t.Errorf("isValidSplitContent() = %v, want true", got)
 	}
 }
+
+// countingTranscriber 记录转录服务被调用的次数
+type countingTranscriber struct {
+	calls int
+}
+
+func (t *countingTranscriber) Transcription(ctx context.Context, audioFile, language, wordDir string) (*types.TranscriptionData, error) {
+	t.calls++
+	return &types.TranscriptionData{Language: language, Text: "hello world", Words: []types.Word{{Num: 0, Text: "hello", End: 0.5}, {Num: 1, Text: "world", Start: 0.5, End: 1}}}, nil
+}
+
+func Test_transcribeAudioFileCache(t *testing.T) {
+	log.Logger = zap.NewNop()
+	dir := t.TempDir()
+	originStorage, originCache := config.Conf.Storage, storage.TranscriptionCacheStore
+	config.Conf.Storage.DataDir = dir
+	config.Conf.Storage.EnableTranscriptionCache = true
+	t.Cleanup(func() { config.Conf.Storage, storage.TranscriptionCacheStore = originStorage, originCache })
+	if err := storage.InitTranscriptionCache(); err != nil {
+		t.Fatal(err)
+	}
+	audioFile := filepath.Join(dir, "split_audio_001.mp3")
+	if err := os.WriteFile(audioFile, []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	transcriber := &countingTranscriber{}
+	s := Service{Transcriber: transcriber}
+	first, attempts, err := s.transcribeAudioFile(context.Background(), "task1", audioFile, "en", dir)
+	if err != nil || attempts != 1 || transcriber.calls != 1 {
+		t.Fatalf("transcribeAudioFile() = attempts %d, calls %d, err %v, want 1, 1, nil", attempts, transcriber.calls, err)
+	}
+	// 相同音频和参数命中缓存，不再调用转录服务
+	second, attempts, err := s.transcribeAudioFile(context.Background(), "task2", audioFile, "en", dir)
+	if err != nil || attempts != 0 || transcriber.calls != 1 {
+		t.Fatalf("transcribeAudioFile() cached = attempts %d, calls %d, err %v, want 0, 1, nil", attempts, transcriber.calls, err)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("cached transcription = %+v, want %+v", second, first)
+	}
+	// 语言不同时缓存键不同
+	if _, _, err = s.transcribeAudioFile(context.Background(), "task3", audioFile, "ja", dir); err != nil || transcriber.calls != 2 {
+		t.Errorf("transcribeAudioFile() other language = calls %d, err %v, want 2, nil", transcriber.calls, err)
+	}
+}
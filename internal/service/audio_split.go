@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"krillin-ai/config"
+	"krillin-ai/internal/storage"
+	"krillin-ai/internal/types"
+	"math"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	silenceDetectNoise       = "-30dB" // 低于该音量视为静音
+	silenceDetectMinDuration = 0.3     // 静音的最短时长，单位：秒
+)
+
+var (
+	silenceStartRegex = regexp.MustCompile(`silence_start:\s*(-?[\d.]+)`)
+	silenceEndRegex   = regexp.MustCompile(`silence_end:\s*(-?[\d.]+)`)
+)
+
+// silenceInterval 一段静音的起止时间，单位：秒
+type silenceInterval struct {
+	Start float64
+	End   float64
+}
+
+// detectSilences 用ffmpeg的silencedetect找出音频中的静音段
+func detectSilences(ctx context.Context, audioPath string) ([]silenceInterval, error) {
+	cmd := exec.CommandContext(ctx, storage.FfmpegPath, "-hide_banner", "-nostats", "-i", audioPath,
+		"-af", fmt.Sprintf("silencedetect=noise=%s:d=%g", silenceDetectNoise, silenceDetectMinDuration), "-f", "null", "-")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		recordProcessFailure(ctx, cmd)
+		return nil, fmt.Errorf("detectSilences ffmpeg err: %w", err)
+	}
+	return parseSilenceDetectOutput(string(output)), nil
+}
+
+// parseSilenceDetectOutput 解析silencedetect输出的silence_start和silence_end，持续到音频结尾的静音没有silence_end，忽略
+func parseSilenceDetectOutput(output string) []silenceInterval {
+	var (
+		silences []silenceInterval
+		start    float64
+		started  bool
+	)
+	for _, line := range strings.Split(output, "\n") {
+		if matches := silenceStartRegex.FindStringSubmatch(line); matches != nil {
+			if value, err := strconv.ParseFloat(matches[1], 64); err == nil {
+				start, started = math.Max(value, 0), true
+			}
+			continue
+		}
+		if matches := silenceEndRegex.FindStringSubmatch(line); matches != nil && started {
+			if value, err := strconv.ParseFloat(matches[1], 64); err == nil && value > start {
+				silences = append(silences, silenceInterval{Start: start, End: value})
+			}
+			started = false
+		}
+	}
+	return silences
+}
+
+// chooseSplitPoints 从上一个切分点起每隔segmentDuration秒切分一次，目标位置前后window秒内有静音时改在离目标最近的静音中点切分
+func chooseSplitPoints(duration, segmentDuration, window float64, silences []silenceInterval) []float64 {
+	var points []float64
+	if segmentDuration <= 0 {
+		return points
+	}
+	last := 0.0
+	for target := segmentDuration; target < duration; target = last + segmentDuration {
+		point := target
+		bestDistance := math.Inf(1)
+		for _, silence := range silences {
+			mid := (silence.Start + silence.End) / 2
+			distance := math.Abs(mid - target)
+			if distance <= window && distance < bestDistance && mid > last && mid < duration {
+				point, bestDistance = mid, distance
+			}
+		}
+		points = append(points, point)
+		last = point
+	}
+	return points
+}
+
+// smallAudioStartOffset 音频段在原音频中的开始时间，旧版本保存的断点没有记录，按固定间隔切分计算
+func smallAudioStartOffset(audioFile *types.SmallAudio) float64 {
+	if audioFile.StartOffset == 0 && audioFile.Num > 1 {
+		return float64(config.Conf.App.SegmentDuration) * 60 * float64(audioFile.Num-1)
+	}
+	return audioFile.StartOffset
+}
+
+// formatSplitPoints 转为ffmpeg segment_times参数
+func formatSplitPoints(points []float64) string {
+	values := make([]string, 0, len(points))
+	for _, point := range points {
+		values = append(values, strconv.FormatFloat(point, 'f', 3, 64))
+	}
+	return strings.Join(values, ",")
+}
+
+// splitAudioSegment 切分后的一段音频，Start为在原音频中的实际开始时间
+type splitAudioSegment struct {
+	FileName string
+	Start    float64
+}
+
+// readSegmentList 读取ffmpeg segment_list输出的csv，每行为 文件名,开始时间,结束时间
+func readSegmentList(listPath string) ([]splitAudioSegment, error) {
+	file, err := os.Open(listPath)
+	if err != nil {
+		return nil, fmt.Errorf("readSegmentList open err: %w", err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("readSegmentList read csv err: %w", err)
+	}
+	segments := make([]splitAudioSegment, 0, len(records))
+	for _, record := range records {
+		if len(record) < 2 {
+			return nil, fmt.Errorf("readSegmentList invalid line: %v", record)
+		}
+		start, err := strconv.ParseFloat(record[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("readSegmentList parse start err: %w", err)
+		}
+		segments = append(segments, splitAudioSegment{FileName: record[0], Start: start})
+	}
+	return segments, nil
+}
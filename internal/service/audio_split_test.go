@@ -0,0 +1,83 @@
+package service
+
+import (
+	"krillin-ai/config"
+	"krillin-ai/internal/types"
+	"reflect"
+	"testing"
+)
+
+func Test_parseSilenceDetectOutput(t *testing.T) {
+	output := `[silencedetect @ 0x7f8] silence_start: -0.01
+[silencedetect @ 0x7f8] silence_end: 1.5 | silence_duration: 1.51
+size=N/A time=00:05:00.00 bitrate=N/A speed= 300x
+[silencedetect @ 0x7f8] silence_start: 298.2
+[silencedetect @ 0x7f8] silence_end: 299 | silence_duration: 0.8
+[silencedetect @ 0x7f8] silence_start: 610.4
+`
+	want := []silenceInterval{{Start: 0, End: 1.5}, {Start: 298.2, End: 299}}
+	if got := parseSilenceDetectOutput(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseSilenceDetectOutput() = %v, want %v", got, want)
+	}
+}
+
+func Test_chooseSplitPoints(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration float64
+		silences []silenceInterval
+		want     []float64
+	}{
+		{
+			name:     "shorter than one segment",
+			duration: 250,
+			want:     nil,
+		},
+		{
+			name:     "no silence falls back to fixed cuts",
+			duration: 700,
+			want:     []float64{300, 600},
+		},
+		{
+			name:     "nearest silence inside window",
+			duration: 700,
+			silences: []silenceInterval{{Start: 270, End: 272}, {Start: 310, End: 312}, {Start: 340, End: 342}},
+			want:     []float64{311, 611},
+		},
+		{
+			name:     "silence outside window is ignored",
+			duration: 700,
+			silences: []silenceInterval{{Start: 250, End: 252}, {Start: 595, End: 596}},
+			want:     []float64{300, 595.5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := chooseSplitPoints(tt.duration, 300, 30, tt.silences)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("chooseSplitPoints() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_smallAudioStartOffset(t *testing.T) {
+	originSegmentDuration := config.Conf.App.SegmentDuration
+	config.Conf.App.SegmentDuration = 5
+	defer func() { config.Conf.App.SegmentDuration = originSegmentDuration }()
+
+	tests := []struct {
+		audio types.SmallAudio
+		want  float64
+	}{
+		{types.SmallAudio{Num: 1}, 0},
+		{types.SmallAudio{Num: 2, StartOffset: 311.5}, 311.5},
+		// 旧版本的断点没有StartOffset
+		{types.SmallAudio{Num: 3}, 600},
+	}
+	for _, tt := range tests {
+		if got := smallAudioStartOffset(&tt.audio); got != tt.want {
+			t.Errorf("smallAudioStartOffset(%+v) = %v, want %v", tt.audio, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"krillin-ai/internal/dto"
+	"krillin-ai/log"
+	"krillin-ai/pkg/util"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 建议客户端使用的分片大小
+	uploadChunkSize = 8 << 20
+	// 单个分片请求的大小上限
+	MaxUploadChunkBytes = 64 << 20
+	// 上传中的文件放在上传目录下的子目录中，长时间未完成的上传由后台清理删除
+	uploadingDirSuffix = ".uploading"
+	// 超过该时长没有新分片的上传目录由后台清理删除，不受upload_ttl_hours影响
+	chunkedUploadTtl = 24 * time.Hour
+)
+
+var (
+	uploadIdRegex = regexp.MustCompile(`^[A-Za-z0-9]{16}$`)
+	sha256Regex   = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	// 同一个上传的分片和完成请求串行处理
+	uploadLocks sync.Map
+)
+
+var errUploadOffsetMismatch = errors.New("分片位置与已上传大小不一致，请查询上传状态后续传")
+
+// chunkedUpload 分片上传的元数据，保存在上传目录的meta.json中
+type chunkedUpload struct {
+	UploadId   string `json:"upload_id"`
+	FileName   string `json:"file_name"`
+	FileSize   int64  `json:"file_size"`
+	Sha256     string `json:"sha256"`
+	FilePath   string `json:"file_path"` // 完成后的local:路径，重复完成时直接返回
+	CreateTime int64  `json:"create_time"`
+}
+
+func uploadingDir(uploadId string) string {
+	return filepath.Join(uploadRoot, uploadId+uploadingDirSuffix)
+}
+
+func lockUpload(uploadId string) func() {
+	mu, _ := uploadLocks.LoadOrStore(uploadId, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// releaseUploadLock 上传完成或删除后不再需要锁，之后的请求只读元数据或返回上传不存在
+func releaseUploadLock(uploadId string) {
+	uploadLocks.Delete(uploadId)
+}
+
+func loadChunkedUpload(uploadId string) (*chunkedUpload, error) {
+	if !uploadIdRegex.MatchString(uploadId) {
+		return nil, errors.New("上传不存在")
+	}
+	data, err := os.ReadFile(filepath.Join(uploadingDir(uploadId), "meta.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.New("上传不存在")
+		}
+		return nil, fmt.Errorf("loadChunkedUpload read meta err: %w", err)
+	}
+	var upload chunkedUpload
+	if err = json.Unmarshal(data, &upload); err != nil {
+		return nil, fmt.Errorf("loadChunkedUpload unmarshal meta err: %w", err)
+	}
+	return &upload, nil
+}
+
+func saveChunkedUpload(upload *chunkedUpload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return fmt.Errorf("saveChunkedUpload marshal meta err: %w", err)
+	}
+	if err = os.WriteFile(filepath.Join(uploadingDir(upload.UploadId), "meta.json"), data, 0644); err != nil {
+		return fmt.Errorf("saveChunkedUpload write meta err: %w", err)
+	}
+	return nil
+}
+
+// uploadedSize 已写入的大小即续传的起始位置
+func uploadedSize(upload *chunkedUpload) int64 {
+	if upload.FilePath != "" {
+		return upload.FileSize
+	}
+	info, err := os.Stat(filepath.Join(uploadingDir(upload.UploadId), "data"))
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func buildChunkedUploadResData(upload *chunkedUpload) *dto.ChunkedUploadResData {
+	return &dto.ChunkedUploadResData{
+		UploadId:  upload.UploadId,
+		FileName:  upload.FileName,
+		FileSize:  upload.FileSize,
+		Offset:    uploadedSize(upload),
+		ChunkSize: uploadChunkSize,
+		FilePath:  upload.FilePath,
+	}
+}
+
+// InitChunkedUpload 创建分片上传，之后按顺序上传分片，中断后可查询已上传的大小继续上传
+func (s Service) InitChunkedUpload(req dto.InitChunkedUploadReq) (*dto.ChunkedUploadResData, error) {
+	if req.FileSize <= 0 {
+		return nil, errors.New("文件大小不合法")
+	}
+	if maxBytes := MaxUploadBytes(); maxBytes > 0 && req.FileSize > maxBytes {
+		return nil, ErrUploadTooLarge
+	}
+	fileName, err := sanitizeUploadFileName(req.FileName)
+	if err != nil {
+		return nil, err
+	}
+	checksum := strings.ToLower(req.Sha256)
+	if checksum != "" && !sha256Regex.MatchString(checksum) {
+		return nil, errors.New("sha256格式不正确")
+	}
+
+	upload := &chunkedUpload{
+		UploadId:   util.GenerateRandStringWithUpperLowerNum(16),
+		FileName:   fileName,
+		FileSize:   req.FileSize,
+		Sha256:     checksum,
+		CreateTime: time.Now().Unix(),
+	}
+	if err = os.MkdirAll(uploadingDir(upload.UploadId), os.ModePerm); err != nil {
+		log.GetLogger().Error("InitChunkedUpload MkdirAll err", zap.Any("req", req), zap.Error(err))
+		return nil, errors.New("创建上传失败")
+	}
+	if err = saveChunkedUpload(upload); err != nil {
+		log.GetLogger().Error("InitChunkedUpload saveChunkedUpload err", zap.Any("req", req), zap.Error(err))
+		return nil, errors.New("创建上传失败")
+	}
+	return buildChunkedUploadResData(upload), nil
+}
+
+// UploadChunk 在offset处追加分片，offset必须等于已上传的大小。连接中断时已写入的部分保留，查询状态后从新的位置继续
+func (s Service) UploadChunk(req dto.UploadChunkReq, chunk io.Reader) (*dto.ChunkedUploadResData, error) {
+	unlock := lockUpload(req.UploadId)
+	defer unlock()
+
+	upload, err := loadChunkedUpload(req.UploadId)
+	if err != nil {
+		return nil, err
+	}
+	if upload.FilePath != "" {
+		return buildChunkedUploadResData(upload), errors.New("上传已完成")
+	}
+	offset := uploadedSize(upload)
+	if *req.Offset != offset {
+		return buildChunkedUploadResData(upload), errUploadOffsetMismatch
+	}
+
+	dataPath := filepath.Join(uploadingDir(upload.UploadId), "data")
+	f, err := os.OpenFile(dataPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.GetLogger().Error("UploadChunk open file err", zap.String("uploadId", upload.UploadId), zap.Error(err))
+		return nil, errors.New("分片保存失败")
+	}
+	// 多读一个字节用于判断分片是否超出文件大小
+	written, err := io.Copy(f, io.LimitReader(chunk, upload.FileSize-offset+1))
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if offset+written > upload.FileSize {
+		_ = os.Truncate(dataPath, offset)
+		return buildChunkedUploadResData(upload), errors.New("分片超出文件大小")
+	}
+	// 更新目录时间，上传中的文件不会被当作过期文件清理
+	now := time.Now()
+	_ = os.Chtimes(uploadingDir(upload.UploadId), now, now)
+	if err != nil {
+		log.GetLogger().Warn("UploadChunk copy err", zap.String("uploadId", upload.UploadId), zap.Int64("written", written), zap.Error(err))
+		return buildChunkedUploadResData(upload), errors.New("分片上传中断，请查询上传状态后续传")
+	}
+	return buildChunkedUploadResData(upload), nil
+}
+
+// GetChunkedUpload 查询已上传的大小，用于续传
+func (s Service) GetChunkedUpload(req dto.ChunkedUploadReq) (*dto.ChunkedUploadResData, error) {
+	upload, err := loadChunkedUpload(req.UploadId)
+	if err != nil {
+		return nil, err
+	}
+	return buildChunkedUploadResData(upload), nil
+}
+
+// CompleteChunkedUpload 校验大小和sha256后把文件移动到上传目录，返回可作为任务url的local:路径
+func (s Service) CompleteChunkedUpload(req dto.CompleteChunkedUploadReq) (*dto.ChunkedUploadResData, error) {
+	unlock := lockUpload(req.UploadId)
+	defer unlock()
+
+	upload, err := loadChunkedUpload(req.UploadId)
+	if err != nil {
+		return nil, err
+	}
+	if upload.FilePath != "" {
+		return buildChunkedUploadResData(upload), nil
+	}
+	checksum := strings.ToLower(req.Sha256)
+	if checksum == "" {
+		checksum = upload.Sha256
+	}
+	if checksum == "" {
+		return nil, errors.New("缺少文件的sha256")
+	}
+	if !sha256Regex.MatchString(checksum) || (upload.Sha256 != "" && checksum != upload.Sha256) {
+		return nil, errors.New("sha256格式不正确或与创建上传时不一致")
+	}
+	if size := uploadedSize(upload); size != upload.FileSize {
+		return buildChunkedUploadResData(upload), fmt.Errorf("文件未上传完成，已上传%d字节，共%d字节", size, upload.FileSize)
+	}
+
+	dataPath := filepath.Join(uploadingDir(upload.UploadId), "data")
+	actual, err := fileSha256(dataPath)
+	if err != nil {
+		log.GetLogger().Error("CompleteChunkedUpload fileSha256 err", zap.String("uploadId", upload.UploadId), zap.Error(err))
+		return nil, errors.New("文件校验失败")
+	}
+	if actual != checksum {
+		// 内容有误时只能重新上传
+		log.GetLogger().Warn("CompleteChunkedUpload checksum mismatch", zap.String("uploadId", upload.UploadId), zap.String("expected", checksum), zap.String("actual", actual))
+		if err = os.Remove(dataPath); err != nil {
+			log.GetLogger().Error("CompleteChunkedUpload remove data err", zap.String("uploadId", upload.UploadId), zap.Error(err))
+		}
+		return buildChunkedUploadResData(upload), errors.New("文件sha256校验不一致，请重新上传")
+	}
+
+	if err = checkUploadFileContent(dataPath, filepath.Ext(upload.FileName)); err != nil {
+		return buildChunkedUploadResData(upload), err
+	}
+
+	// 文件名创建上传时已加随机前缀，不会覆盖已有文件
+	savePath := uploadRoot + "/" + upload.FileName
+	if err = os.Rename(dataPath, savePath); err != nil {
+		log.GetLogger().Error("CompleteChunkedUpload rename err", zap.String("uploadId", upload.UploadId), zap.Error(err))
+		return nil, errors.New("文件保存失败")
+	}
+	rememberFileSha256(savePath, checksum)
+	upload.Sha256 = checksum
+	upload.FilePath = "local:" + savePath
+	if err = saveChunkedUpload(upload); err != nil {
+		log.GetLogger().Error("CompleteChunkedUpload saveChunkedUpload err", zap.String("uploadId", upload.UploadId), zap.Error(err))
+	}
+	releaseUploadLock(upload.UploadId)
+	log.GetLogger().Info("分片上传完成", zap.String("uploadId", upload.UploadId), zap.String("path", savePath), zap.Int64("size", upload.FileSize))
+	return buildChunkedUploadResData(upload), nil
+}
+
+// AbortChunkedUpload 放弃未完成的上传，删除已上传的分片
+func (s Service) AbortChunkedUpload(req dto.AbortChunkedUploadReq) error {
+	unlock := lockUpload(req.UploadId)
+	defer unlock()
+
+	upload, err := loadChunkedUpload(req.UploadId)
+	if err != nil {
+		releaseUploadLock(req.UploadId)
+		return err
+	}
+	if upload.FilePath != "" {
+		return errors.New("上传已完成")
+	}
+	if err = os.RemoveAll(uploadingDir(upload.UploadId)); err != nil {
+		log.GetLogger().Error("AbortChunkedUpload remove dir err", zap.String("uploadId", upload.UploadId), zap.Error(err))
+		return errors.New("删除上传失败")
+	}
+	releaseUploadLock(upload.UploadId)
+	return nil
+}
+
+func checkUploadFileContent(localPath, ext string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("checkUploadFileContent open err: %w", err)
+	}
+	defer f.Close()
+	return checkUploadContent(f, ext)
+}
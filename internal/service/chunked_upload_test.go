@@ -0,0 +1,127 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"go.uber.org/zap"
+	"krillin-ai/config"
+	"krillin-ai/internal/dto"
+	"krillin-ai/log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func uploadTestChunk(t *testing.T, uploadId string, offset int64, data string) (*dto.ChunkedUploadResData, error) {
+	t.Helper()
+	return Service{}.UploadChunk(dto.UploadChunkReq{UploadId: uploadId, Offset: &offset}, strings.NewReader(data))
+}
+
+func Test_chunkedUpload(t *testing.T) {
+	log.Logger = zap.NewNop()
+	chdirTemp(t)
+	content := "1\n00:00:00,000 --> 00:00:01,000\nhello\n\n"
+	sum := sha256.Sum256([]byte(content))
+	checksum := hex.EncodeToString(sum[:])
+
+	upload, err := Service{}.InitChunkedUpload(dto.InitChunkedUploadReq{FileName: "a.srt", FileSize: int64(len(content))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploadId := upload.UploadId
+
+	if _, err = uploadTestChunk(t, uploadId, 0, content[:10]); err != nil {
+		t.Fatalf("UploadChunk() err = %v", err)
+	}
+	// 位置不对时返回已上传的大小用于续传
+	if res, err := uploadTestChunk(t, uploadId, 0, content[:10]); err != errUploadOffsetMismatch || res.Offset != 10 {
+		t.Errorf("UploadChunk(wrong offset) = %+v, %v, want offset 10 and errUploadOffsetMismatch", res, err)
+	}
+	// 超出文件大小的分片不保留
+	if res, err := uploadTestChunk(t, uploadId, 10, content[10:]+"extra"); err == nil || res.Offset != 10 {
+		t.Errorf("UploadChunk(oversize) = %+v, %v, want offset 10 and err", res, err)
+	}
+	if _, err = (Service{}).CompleteChunkedUpload(dto.CompleteChunkedUploadReq{UploadId: uploadId, Sha256: checksum}); err == nil {
+		t.Error("CompleteChunkedUpload(incomplete) want err")
+	}
+	if _, err = uploadTestChunk(t, uploadId, 10, content[10:]); err != nil {
+		t.Fatalf("UploadChunk() err = %v", err)
+	}
+
+	// sha256不一致时删除已上传的内容
+	wrong := strings.Repeat("0", 64)
+	if res, err := (Service{}).CompleteChunkedUpload(dto.CompleteChunkedUploadReq{UploadId: uploadId, Sha256: wrong}); err == nil || res.Offset != 0 {
+		t.Errorf("CompleteChunkedUpload(checksum mismatch) = %+v, %v, want offset 0 and err", res, err)
+	}
+	if _, err = uploadTestChunk(t, uploadId, 0, content); err != nil {
+		t.Fatalf("UploadChunk() err = %v", err)
+	}
+	res, err := Service{}.CompleteChunkedUpload(dto.CompleteChunkedUploadReq{UploadId: uploadId, Sha256: checksum})
+	if err != nil {
+		t.Fatalf("CompleteChunkedUpload() err = %v", err)
+	}
+	data, err := os.ReadFile(strings.TrimPrefix(res.FilePath, "local:"))
+	if err != nil || !bytes.Equal(data, []byte(content)) {
+		t.Errorf("uploaded file = %q, %v, want %q", data, err, content)
+	}
+	if _, ok := uploadLocks.Load(uploadId); ok {
+		t.Error("uploadLocks still contains completed upload")
+	}
+	// 重复完成直接返回
+	if again, err := (Service{}).CompleteChunkedUpload(dto.CompleteChunkedUploadReq{UploadId: uploadId}); err != nil || again.FilePath != res.FilePath {
+		t.Errorf("CompleteChunkedUpload(again) = %+v, %v, want %s", again, err, res.FilePath)
+	}
+}
+
+func Test_cleanupStorageStaleChunkedUpload(t *testing.T) {
+	log.Logger = zap.NewNop()
+	chdirTemp(t)
+	useMemoryTaskRepo(t)
+	originRetention := config.Conf.Retention
+	config.Conf.Retention.UploadTtlHours = 0
+	config.Conf.Retention.MaxDiskMb = 0
+	defer func() { config.Conf.Retention = originRetention }()
+
+	var uploadIds []string
+	for range 2 {
+		upload, err := Service{}.InitChunkedUpload(dto.InitChunkedUploadReq{FileName: "a.mp4", FileSize: 100})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = uploadTestChunk(t, upload.UploadId, 0, "data"); err != nil {
+			t.Fatal(err)
+		}
+		uploadIds = append(uploadIds, upload.UploadId)
+	}
+	stale := time.Now().Add(-chunkedUploadTtl - time.Hour)
+	if err := os.Chtimes(uploadingDir(uploadIds[0]), stale, stale); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := cleanupStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.RemovedUploads) != 1 || res.RemovedUploads[0] != uploadIds[0]+uploadingDirSuffix {
+		t.Errorf("cleanupStorage() removed %v, want %s", res.RemovedUploads, uploadIds[0]+uploadingDirSuffix)
+	}
+	if _, ok := uploadLocks.Load(uploadIds[0]); ok {
+		t.Error("uploadLocks still contains removed upload")
+	}
+	if _, err = os.Stat(filepath.Join(uploadingDir(uploadIds[1]), "data")); err != nil {
+		t.Errorf("active upload removed: %v", err)
+	}
+
+	if err = (Service{}).AbortChunkedUpload(dto.AbortChunkedUploadReq{UploadId: uploadIds[1]}); err != nil {
+		t.Fatalf("AbortChunkedUpload() err = %v", err)
+	}
+	if _, err = os.Stat(uploadingDir(uploadIds[1])); !os.IsNotExist(err) {
+		t.Errorf("aborted upload dir still exists: %v", err)
+	}
+	if _, ok := uploadLocks.Load(uploadIds[1]); ok {
+		t.Error("uploadLocks still contains aborted upload")
+	}
+}
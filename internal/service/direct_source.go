@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"krillin-ai/config"
+	"krillin-ai/internal/dto"
+	"krillin-ai/internal/types"
+	"krillin-ai/log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var directMediaExts = map[string]bool{
+	".mp4": true, ".mov": true, ".mkv": true, ".webm": true, ".m4v": true, ".flv": true, ".ts": true,
+	".mp3": true, ".m4a": true, ".wav": true, ".flac": true, ".aac": true, ".ogg": true, ".opus": true,
+}
+
+var directAudioExts = map[string]bool{
+	".mp3": true, ".m4a": true, ".wav": true, ".flac": true, ".aac": true, ".ogg": true, ".opus": true,
+}
+
+var streamManifestExts = map[string]bool{
+	".m3u8": true, // HLS
+	".mpd":  true, // DASH
+}
+
+var errDownloadTooLarge = errors.New("文件大小超过下载上限")
+
+func maxDownloadBytes() int64 {
+	return int64(config.Conf.Source.MaxDownloadMb) << 20
+}
+
+// downloadDirectMedia 直接下载音视频文件，提取音频后按需保留原视频
+func downloadDirectMedia(ctx context.Context, stepParam *types.SubtitleTaskStepParam, src *resolvedSource, audioPath, videoPath string) error {
+	u, err := url.Parse(src.Url)
+	if err != nil {
+		return fmt.Errorf("downloadDirectMedia parse url err: %w", err)
+	}
+	ext := strings.ToLower(path.Ext(u.Path))
+	mediaPath := videoPath
+	if ext != filepath.Ext(videoPath) {
+		mediaPath = strings.TrimSuffix(videoPath, filepath.Ext(videoPath)) + ext
+	}
+	if err = downloadHttpFile(ctx, stepParam.TaskId, src.Url, mediaPath); err != nil {
+		log.GetLogger().Error("downloadDirectMedia downloadHttpFile err", zap.String("taskId", stepParam.TaskId), zap.String("url", src.Url), zap.Error(err))
+		return fmt.Errorf("downloadDirectMedia downloadHttpFile err: %w", err)
+	}
+	recordDownloadedBytes(mediaPath)
+	if err = extractAudio(ctx, mediaPath, audioPath); err != nil {
+		log.GetLogger().Error("downloadDirectMedia extractAudio err", zap.String("taskId", stepParam.TaskId), zap.Error(err))
+		return fmt.Errorf("downloadDirectMedia extractAudio err: %w", err)
+	}
+	updateTaskProcessPct(stepParam.TaskId, 6)
+
+	if needInputVideo(stepParam) && !directAudioExts[ext] {
+		stepParam.InputVideoPath = mediaPath
+		return nil
+	}
+	// 不需要合成视频时不保留原文件，节省磁盘
+	if err = os.Remove(mediaPath); err != nil {
+		log.GetLogger().Warn("downloadDirectMedia remove media file err", zap.String("taskId", stepParam.TaskId), zap.Error(err))
+	}
+	return nil
+}
+
+// downloadStreamMedia 通过ffmpeg读取HLS/DASH播放列表，需要原视频时转封装为mp4，否则只提取音频
+func downloadStreamMedia(ctx context.Context, stepParam *types.SubtitleTaskStepParam, src *resolvedSource, audioPath, videoPath string) error {
+	inputArgs := make([]string, 0)
+	if config.Conf.App.Proxy != "" {
+		inputArgs = append(inputArgs, "-http_proxy", config.Conf.App.Proxy)
+	}
+	outputArgs := []string{"-y"}
+	if maxBytes := maxDownloadBytes(); maxBytes > 0 {
+		// 达到上限时ffmpeg会停止写入，下面根据输出文件大小判断是否超限
+		outputArgs = append(outputArgs, "-fs", strconv.FormatInt(maxBytes, 10))
+	}
+
+	target := audioPath
+	args := append(append([]string{}, inputArgs...), "-i", src.Url)
+	if needInputVideo(stepParam) {
+		target = videoPath
+		args = append(args, "-c", "copy")
+	} else {
+		args = append(args, "-vn", "-ar", "44100", "-ac", "2", "-ab", "192k", "-f", "mp3")
+	}
+	args = append(append(args, outputArgs...), target)
+	output, err := runFfmpegWithProgress(ctx, stepParam.TaskId, "download", src.Url, args...)
+	if err != nil {
+		log.GetLogger().Error("downloadStreamMedia ffmpeg err", zap.String("taskId", stepParam.TaskId), zap.String("url", src.Url), zap.String("output", string(output)), zap.Error(err))
+		return fmt.Errorf("downloadStreamMedia ffmpeg err: %w", err)
+	}
+	if info, err := os.Stat(target); err == nil && maxDownloadBytes() > 0 && info.Size() >= maxDownloadBytes() {
+		_ = os.Remove(target)
+		return fmt.Errorf("downloadStreamMedia err: %w", errDownloadTooLarge)
+	}
+	recordDownloadedBytes(target)
+	if target == audioPath {
+		updateTaskProcessPct(stepParam.TaskId, 6)
+		return nil
+	}
+
+	if err = extractAudio(ctx, videoPath, audioPath); err != nil {
+		log.GetLogger().Error("downloadStreamMedia extractAudio err", zap.String("taskId", stepParam.TaskId), zap.Error(err))
+		return fmt.Errorf("downloadStreamMedia extractAudio err: %w", err)
+	}
+	updateTaskProcessPct(stepParam.TaskId, 6)
+	stepParam.InputVideoPath = videoPath
+	return nil
+}
+
+// downloadProgressWriter 统计已下载字节数，每下载约1%（总大小未知时每10MB）推送一次进度
+type downloadProgressWriter struct {
+	taskId     string
+	total      int64
+	downloaded int64
+	step       int64
+	next       int64
+}
+
+func newDownloadProgressWriter(taskId string, total int64) *downloadProgressWriter {
+	step := int64(10 << 20)
+	if total > 0 {
+		step = max(total/100, 1<<20)
+	}
+	return &downloadProgressWriter{taskId: taskId, total: total, step: step, next: step}
+}
+
+func (pw *downloadProgressWriter) Write(p []byte) (int, error) {
+	pw.downloaded += int64(len(p))
+	if pw.downloaded >= pw.next {
+		pw.next = pw.downloaded + pw.step
+		pw.publish()
+	}
+	return len(p), nil
+}
+
+func (pw *downloadProgressWriter) publish() {
+	publishTaskEvent(dto.SubtitleTaskEvent{
+		Type:    SubtitleTaskEventDownloadProgress,
+		TaskId:  pw.taskId,
+		Current: int(pw.downloaded),
+		Total:   int(pw.total),
+		Message: "download",
+	})
+}
+
+// downloadHttpFile 使用配置的代理下载文件，超过下载上限时中止并删除已下载的部分
+func downloadHttpFile(ctx context.Context, taskId, link, dst string) error {
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyURL(config.Conf.App.ParsedProxy),
+			ResponseHeaderTimeout: 30 * time.Second,
+		},
+		CheckRedirect: checkRedirectSource,
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return fmt.Errorf("downloadHttpFile new request err: %w", err)
+	}
+	if err = checkSourceAddress(ctx, strings.ToLower(req.URL.Hostname())); err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("downloadHttpFile request err: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("downloadHttpFile unexpected status: %s", resp.Status)
+	}
+	maxBytes := maxDownloadBytes()
+	if maxBytes > 0 && resp.ContentLength > maxBytes {
+		return errDownloadTooLarge
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("downloadHttpFile create file err: %w", err)
+	}
+	var body io.Reader = resp.Body
+	if maxBytes > 0 {
+		// 多读一个字节用于判断是否超限，Content-Length缺失或不准确时同样生效
+		body = io.LimitReader(resp.Body, maxBytes+1)
+	}
+	progress := newDownloadProgressWriter(taskId, max(resp.ContentLength, 0))
+	written, err := io.Copy(out, io.TeeReader(body, progress))
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil && maxBytes > 0 && written > maxBytes {
+		err = errDownloadTooLarge
+	}
+	if err != nil {
+		_ = os.Remove(dst)
+		return fmt.Errorf("downloadHttpFile copy err: %w", err)
+	}
+	progress.publish()
+	return nil
+}
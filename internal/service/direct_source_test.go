@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"krillin-ai/config"
+	"krillin-ai/log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func Test_downloadHttpFileMaxSize(t *testing.T) {
+	log.Logger = zap.NewNop()
+	originSource := config.Conf.Source
+	config.Conf.Source.MaxDownloadMb = 1
+	config.Conf.Source.AllowPrivateHosts = []string{"127.0.0.1"}
+	defer func() { config.Conf.Source = originSource }()
+
+	body := strings.Repeat("a", 2<<20)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/chunked.mp4":
+			// 不带Content-Length，只能在下载过程中发现超限
+			w.WriteHeader(http.StatusOK)
+			for i := 0; i < len(body); i += 64 << 10 {
+				_, _ = w.Write([]byte(body[i : i+64<<10]))
+				w.(http.Flusher).Flush()
+			}
+		case "/small.mp4":
+			_, _ = w.Write([]byte(body[:1024]))
+		default:
+			// Content-Length超限时不开始下载
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			_, _ = w.Write([]byte(body))
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	for _, name := range []string{"chunked.mp4", "sized.mp4"} {
+		dst := filepath.Join(dir, name)
+		err := downloadHttpFile(context.Background(), "task", server.URL+"/"+name, dst)
+		if !errors.Is(err, errDownloadTooLarge) {
+			t.Errorf("downloadHttpFile(%s) err = %v, want errDownloadTooLarge", name, err)
+		}
+		if _, err = os.Stat(dst); !os.IsNotExist(err) {
+			t.Errorf("downloadHttpFile(%s) left a partial file", name)
+		}
+	}
+
+	dst := filepath.Join(dir, "small.mp4")
+	if err := downloadHttpFile(context.Background(), "task", server.URL+"/small.mp4", dst); err != nil {
+		t.Fatalf("downloadHttpFile(small.mp4) err = %v", err)
+	}
+	if info, err := os.Stat(dst); err != nil || info.Size() != 1024 {
+		t.Errorf("downloadHttpFile(small.mp4) size = %v, %v, want 1024", info, err)
+	}
+}
+
+func Test_checkSourceAddress(t *testing.T) {
+	originSource := config.Conf.Source
+	config.Conf.Source.AllowPrivateHosts = []string{"nas.local", "192.168.1.10"}
+	defer func() { config.Conf.Source = originSource }()
+
+	for _, host := range []string{"127.0.0.1", "::1", "10.0.0.8", "172.16.3.4", "192.168.1.11", "169.254.169.254", "fe80::1", "0.0.0.0", "localhost"} {
+		if err := checkSourceAddress(context.Background(), host); err == nil {
+			t.Errorf("checkSourceAddress(%s) want err", host)
+		}
+	}
+	for _, host := range []string{"8.8.8.8", "2001:4860:4860::8888", "192.168.1.10", "nas.local", "media.nas.local"} {
+		if err := checkSourceAddress(context.Background(), host); err != nil {
+			t.Errorf("checkSourceAddress(%s) err = %v", host, err)
+		}
+	}
+}
+
+func Test_downloadHttpFileRedirect(t *testing.T) {
+	log.Logger = zap.NewNop()
+	originSource := config.Conf.Source
+	config.Conf.Source.AllowPrivateHosts = []string{"127.0.0.1"}
+	config.Conf.Source.DenyDomains = []string{"denied.example.com"}
+	defer func() { config.Conf.Source = originSource }()
+
+	server := httptest.NewServer(nil)
+	defer server.Close()
+	port := server.Listener.Addr().(*net.TCPAddr).Port
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok.mp4":
+			http.Redirect(w, r, "/file.mp4", http.StatusFound)
+		case "/loopback.mp4":
+			// localhost未加入allow_private_hosts
+			http.Redirect(w, r, fmt.Sprintf("http://localhost:%d/file.mp4", port), http.StatusFound)
+		case "/denied.mp4":
+			http.Redirect(w, r, "http://denied.example.com/file.mp4", http.StatusFound)
+		default:
+			_, _ = w.Write([]byte("data"))
+		}
+	})
+
+	dir := t.TempDir()
+	if err := downloadHttpFile(context.Background(), "task", server.URL+"/ok.mp4", filepath.Join(dir, "ok.mp4")); err != nil {
+		t.Errorf("downloadHttpFile(ok.mp4) err = %v", err)
+	}
+	for _, name := range []string{"loopback.mp4", "denied.mp4"} {
+		if err := downloadHttpFile(context.Background(), "task", server.URL+"/"+name, filepath.Join(dir, name)); err == nil {
+			t.Errorf("downloadHttpFile(%s) want err", name)
+		}
+	}
+	// 原始链接本身指向未允许的内网地址
+	if err := downloadHttpFile(context.Background(), "task", fmt.Sprintf("http://localhost:%d/file.mp4", port), filepath.Join(dir, "direct.mp4")); err == nil {
+		t.Error("downloadHttpFile(localhost) want err")
+	}
+}
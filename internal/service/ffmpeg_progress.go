@@ -0,0 +1,72 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"krillin-ai/internal/dto"
+	"krillin-ai/internal/storage"
+	"krillin-ai/log"
+	"krillin-ai/pkg/util"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// runFfmpegWithProgress 执行ffmpeg并通过-progress输出推送编码进度，返回ffmpeg的日志输出
+func runFfmpegWithProgress(ctx context.Context, taskId, operation, inputFile string, args ...string) ([]byte, error) {
+	// 获取不到时长时只推送已编码秒数。远程输入（如HLS播放列表）获取时长需要单独请求一次，
+	// 既不经过代理也无法随任务取消，因此跳过
+	var totalSeconds float64
+	if !strings.Contains(inputFile, "://") {
+		var err error
+		if totalSeconds, err = util.GetAudioDuration(inputFile); err != nil {
+			log.GetLogger().Info("runFfmpegWithProgress get duration failed", zap.String("input", inputFile), zap.Error(err))
+		}
+	}
+
+	cmdArgs := append([]string{"-progress", "pipe:1", "-nostats"}, args...)
+	cmd := exec.CommandContext(ctx, storage.FfmpegPath, cmdArgs...)
+	var output bytes.Buffer
+	cmd.Stderr = &output
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("runFfmpegWithProgress stdout pipe err: %w", err)
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, fmt.Errorf("runFfmpegWithProgress start err: %w", err)
+	}
+
+	lastSecond := -1
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		// out_time_ms实际单位是微秒，与out_time_us一致
+		if !ok || (key != "out_time_ms" && key != "out_time_us") {
+			continue
+		}
+		us, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		second := int(us / 1000000)
+		if second == lastSecond {
+			continue
+		}
+		lastSecond = second
+		publishTaskEvent(dto.SubtitleTaskEvent{
+			Type:    SubtitleTaskEventFfmpegProgress,
+			TaskId:  taskId,
+			Current: second,
+			Total:   int(totalSeconds),
+			Message: operation,
+		})
+	}
+
+	if err = cmd.Wait(); err != nil {
+		recordProcessFailure(ctx, cmd)
+	}
+	return output.Bytes(), err
+}
@@ -2,75 +2,240 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"go.uber.org/zap"
+	"io"
 	"krillin-ai/config"
 	"krillin-ai/internal/storage"
 	"krillin-ai/internal/types"
 	"krillin-ai/log"
+	"krillin-ai/pkg/util"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
+// 封面图片的大小上限
+const maxThumbnailBytes = 20 << 20
+
+var thumbnailExts = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".webp": true,
+}
+
+// ytdlpVideoMetadata yt-dlp -J 输出中用到的字段
+type ytdlpVideoMetadata struct {
+	Title       string  `json:"title"`
+	Description string  `json:"description"`
+	Duration    float64 `json:"duration"`
+	Thumbnail   string  `json:"thumbnail"`
+	Uploader    string  `json:"uploader"`
+	UploadDate  string  `json:"upload_date"` // 20060102
+	Chapters    []struct {
+		StartTime float64 `json:"start_time"`
+		EndTime   float64 `json:"end_time"`
+		Title     string  `json:"title"`
+	} `json:"chapters"`
+}
+
+// getVideoInfo 获取视频的标题、描述、时长、封面、作者、章节和发布日期，获取失败不影响后续步骤
 func (s Service) getVideoInfo(ctx context.Context, stepParam *types.SubtitleTaskStepParam) error {
 	link := stepParam.Link
-	if strings.Contains(link, "youtube.com") || strings.Contains(link, "bilibili.com") {
-		var (
-			err                error
-			title, description string
-		)
-		// 获取标题
-		titleCmdArgs := []string{"--skip-download", "--encoding", "utf-8", "--get-title", stepParam.Link}
-		descriptionCmdArgs := []string{"--skip-download", "--encoding", "utf-8", "--get-description", stepParam.Link}
-		titleCmdArgs = append(titleCmdArgs, "--cookies", "./cookies.txt")
-		descriptionCmdArgs = append(descriptionCmdArgs, "--cookies", "./cookies.txt")
-		if config.Conf.App.Proxy != "" {
-			titleCmdArgs = append(titleCmdArgs, "--proxy", config.Conf.App.Proxy)
-			descriptionCmdArgs = append(descriptionCmdArgs, "--proxy", config.Conf.App.Proxy)
-		}
-		if storage.FfmpegPath != "ffmpeg" {
-			titleCmdArgs = append(titleCmdArgs, "--ffmpeg-location", storage.FfmpegPath)
-			descriptionCmdArgs = append(descriptionCmdArgs, "--ffmpeg-location", storage.FfmpegPath)
-		}
-		cmd := exec.Command(storage.YtdlpPath, titleCmdArgs...)
-		var output []byte
-		output, err = cmd.CombinedOutput()
+	if strings.HasPrefix(link, "local:") {
+		// 本地文件只能获取时长和内嵌的章节，时长获取失败时仍然读取章节
+		localPath := strings.TrimPrefix(link, "local:")
+		duration, err := util.GetAudioDuration(localPath)
 		if err != nil {
-			log.GetLogger().Error("getVideoInfo yt-dlp error", zap.Any("stepParam", stepParam), zap.String("output", string(output)), zap.Error(err))
-			output = []byte{}
-			// 不需要整个流程退出
+			log.GetLogger().Error("getVideoInfo GetAudioDuration error", zap.Any("stepParam", stepParam), zap.Error(err))
 		}
-		title = string(output)
-		cmd = exec.Command(storage.YtdlpPath, descriptionCmdArgs...)
-		output, err = cmd.CombinedOutput()
+		chapters, err := probeVideoChapters(ctx, localPath)
 		if err != nil {
-			log.GetLogger().Error("getVideoInfo yt-dlp error", zap.Any("stepParam", stepParam), zap.String("output", string(output)), zap.Error(err))
-			output = []byte{}
+			log.GetLogger().Warn("getVideoInfo probeVideoChapters error", zap.String("taskId", stepParam.TaskId), zap.Error(err))
 		}
-		description = string(output)
-		log.GetLogger().Debug("getVideoInfo title and description", zap.String("title", title), zap.String("description", description))
-		// 翻译
-		var result string
-		result, err = s.ChatCompleter.ChatCompletion(fmt.Sprintf(types.TranslateVideoTitleAndDescriptionPrompt, types.GetStandardLanguageName(stepParam.TargetLanguage), title+"####"+description))
-		if err != nil {
-			log.GetLogger().Error("getVideoInfo openai chat completion error", zap.Any("stepParam", stepParam), zap.Error(err))
-		}
-		log.GetLogger().Debug("getVideoInfo translate video info result", zap.String("result", result))
+		updateTask(stepParam.TaskId, func(task *types.SubtitleTask) {
+			applyVideoInfoProgress(task, stepParam)
+			if duration > 0 {
+				task.Duration = uint32(math.Round(duration))
+			}
+			task.Chapters = chapters
+		})
+		return nil
+	}
+
+	source, err := resolveSource(link)
+	if err != nil {
+		log.GetLogger().Error("getVideoInfo resolveSource error", zap.Any("stepParam", stepParam), zap.Error(err))
+		// 不需要整个流程退出
+		return nil
+	}
+	metadata, err := fetchVideoMetadata(ctx, source)
+	if err != nil {
+		log.GetLogger().Error("getVideoInfo fetchVideoMetadata error", zap.Any("stepParam", stepParam), zap.Error(err))
+	} else {
+		log.GetLogger().Debug("getVideoInfo metadata", zap.String("title", metadata.Title), zap.Float64("duration", metadata.Duration))
+	}
 
-		storage.SubtitleTasks[stepParam.TaskId].Title = title
-		storage.SubtitleTasks[stepParam.TaskId].Description = description
-		storage.SubtitleTasks[stepParam.TaskId].OriginLanguage = string(stepParam.OriginLanguage)
-		storage.SubtitleTasks[stepParam.TaskId].TargetLanguage = string(stepParam.TargetLanguage)
-		storage.SubtitleTasks[stepParam.TaskId].ProcessPct = 10
-		splitResult := strings.Split(result, "####")
-		if len(splitResult) == 1 {
-			storage.SubtitleTasks[stepParam.TaskId].TranslatedTitle = splitResult[0]
-		} else if len(splitResult) == 2 {
-			storage.SubtitleTasks[stepParam.TaskId].TranslatedTitle = splitResult[0]
-			storage.SubtitleTasks[stepParam.TaskId].TranslatedDescription = splitResult[1]
+	var cover string
+	if metadata != nil && metadata.Thumbnail != "" {
+		coverPath, err := downloadThumbnail(ctx, metadata.Thumbnail, filepath.Join(stepParam.TaskBasePath, "output"))
+		if err != nil {
+			log.GetLogger().Warn("getVideoInfo downloadThumbnail error", zap.String("taskId", stepParam.TaskId), zap.String("thumbnail", metadata.Thumbnail), zap.Error(err))
 		} else {
-			log.GetLogger().Error("getVideoInfo translate video info error split result length != 1 and 2", zap.Any("stepParam", stepParam), zap.Any("translate result", result), zap.Error(err))
+			cover = "/api/file/" + filepath.ToSlash(coverPath)
+		}
+	}
+	updateTask(stepParam.TaskId, func(task *types.SubtitleTask) {
+		applyVideoInfoProgress(task, stepParam)
+		if metadata != nil {
+			applyVideoMetadata(task, metadata, cover)
 		}
+	})
+	return nil
+}
+
+// applyVideoInfoProgress 获取视频信息后记录任务的语言，进度推进到10
+func applyVideoInfoProgress(task *types.SubtitleTask, stepParam *types.SubtitleTaskStepParam) {
+	task.OriginLanguage = string(stepParam.OriginLanguage)
+	task.TargetLanguage = string(stepParam.TargetLanguage)
+	task.ProcessPct = max(task.ProcessPct, 10)
+}
+
+// applyVideoMetadata 把yt-dlp的元数据写入任务，发布日期转为2006-01-02格式，时长四舍五入到秒
+func applyVideoMetadata(task *types.SubtitleTask, metadata *ytdlpVideoMetadata, cover string) {
+	var uploadDate string
+	if t, err := time.Parse("20060102", metadata.UploadDate); err == nil {
+		uploadDate = t.Format("2006-01-02")
+	}
+	chapters := make([]types.VideoChapter, 0, len(metadata.Chapters))
+	for _, chapter := range metadata.Chapters {
+		chapters = append(chapters, types.VideoChapter{
+			StartTime: chapter.StartTime,
+			EndTime:   chapter.EndTime,
+			Title:     strings.TrimSpace(chapter.Title),
+		})
+	}
+	task.Title = metadata.Title
+	task.Description = metadata.Description
+	task.Duration = uint32(math.Round(metadata.Duration))
+	task.Cover = cover
+	task.Uploader = metadata.Uploader
+	task.UploadDate = uploadDate
+	task.Chapters = chapters
+}
+
+// fetchVideoMetadata 通过一次yt-dlp -J获取视频的全部元数据
+func fetchVideoMetadata(ctx context.Context, source *resolvedSource) (*ytdlpVideoMetadata, error) {
+	cmdArgs := append([]string{"-J", "--skip-download", "--no-playlist", "--encoding", "utf-8", source.Url}, ytdlpSourceArgs(source)...)
+	cmd := exec.CommandContext(ctx, storage.YtdlpPath, cmdArgs...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		recordProcessFailure(ctx, cmd)
+		return nil, fmt.Errorf("fetchVideoMetadata yt-dlp err: %w, output: %s", err, stderr.String())
 	}
+	var metadata ytdlpVideoMetadata
+	if err = json.Unmarshal(output, &metadata); err != nil {
+		return nil, fmt.Errorf("fetchVideoMetadata unmarshal err: %w", err)
+	}
+	return &metadata, nil
+}
+
+// downloadThumbnail 下载封面到dir下，返回本地路径
+func downloadThumbnail(ctx context.Context, link, dir string) (string, error) {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", errors.New("封面链接不合法")
+	}
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			Proxy: http.ProxyURL(config.Conf.App.ParsedProxy),
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return "", fmt.Errorf("downloadThumbnail new request err: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("downloadThumbnail request err: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("downloadThumbnail unexpected status: %s", resp.Status)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "image/") {
+		return "", fmt.Errorf("downloadThumbnail unexpected content type: %s", resp.Header.Get("Content-Type"))
+	}
+	ext := strings.ToLower(path.Ext(u.Path))
+	if !thumbnailExts[ext] {
+		ext = ".jpg"
+	}
+
+	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("downloadThumbnail mkdir err: %w", err)
+	}
+	coverPath := filepath.Join(dir, types.SubtitleTaskCoverFileNamePrefix+ext)
+	out, err := os.Create(coverPath)
+	if err != nil {
+		return "", fmt.Errorf("downloadThumbnail create file err: %w", err)
+	}
+	written, err := io.Copy(out, io.LimitReader(resp.Body, maxThumbnailBytes+1))
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil && written > maxThumbnailBytes {
+		err = errors.New("封面图片过大")
+	}
+	if err != nil {
+		_ = os.Remove(coverPath)
+		return "", fmt.Errorf("downloadThumbnail copy err: %w", err)
+	}
+	return coverPath, nil
+}
+
+// translateVideoInfo 把标题和描述翻译为目标语言，翻译失败不影响后续步骤
+func (s Service) translateVideoInfo(ctx context.Context, stepParam *types.SubtitleTaskStepParam) error {
+	if stepParam.TargetLanguage == "" || stepParam.TargetLanguage == "none" {
+		return nil
+	}
+	task, err := storage.SubtitleTaskRepo.Get(stepParam.TaskId)
+	if err != nil {
+		log.GetLogger().Error("translateVideoInfo get task error", zap.String("taskId", stepParam.TaskId), zap.Error(err))
+		return nil
+	}
+	if task.Title == "" && task.Description == "" {
+		return nil
+	}
+	result, err := s.ChatCompleter.ChatCompletion(ctx, fmt.Sprintf(types.TranslateVideoTitleAndDescriptionPrompt, types.GetStandardLanguageName(stepParam.TargetLanguage), task.Title+"####"+task.Description))
+	if err != nil {
+		log.GetLogger().Error("translateVideoInfo chat completion error", zap.Any("stepParam", stepParam), zap.Error(err))
+		return nil
+	}
+	log.GetLogger().Debug("translateVideoInfo translate video info result", zap.String("result", result))
+
+	splitResult := strings.Split(result, "####")
+	if len(splitResult) > 2 {
+		log.GetLogger().Error("translateVideoInfo translate video info error split result length != 1 and 2", zap.Any("stepParam", stepParam), zap.Any("translate result", result))
+		return nil
+	}
+	updateTask(stepParam.TaskId, func(task *types.SubtitleTask) {
+		task.TranslatedTitle = strings.TrimSpace(splitResult[0])
+		if len(splitResult) == 2 {
+			task.TranslatedDescription = strings.TrimSpace(splitResult[1])
+		}
+	})
 	return nil
 }
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"go.uber.org/zap"
+	"krillin-ai/internal/storage"
+	"krillin-ai/internal/types"
+	"krillin-ai/log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_applyVideoMetadata(t *testing.T) {
+	var metadata ytdlpVideoMetadata
+	err := json.Unmarshal([]byte(`{
+		"title": "Demo",
+		"description": "desc",
+		"duration": 125.6,
+		"uploader": "someone",
+		"upload_date": "20240305",
+		"chapters": [
+			{"start_time": 0, "end_time": 60.5, "title": "  Intro \n"},
+			{"start_time": 60.5, "end_time": 125.6, "title": "Main"}
+		]
+	}`), &metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var task types.SubtitleTask
+	applyVideoMetadata(&task, &metadata, "/api/file/tasks/abc/output/cover.jpg")
+	if task.Title != "Demo" || task.Description != "desc" || task.Uploader != "someone" || task.Cover != "/api/file/tasks/abc/output/cover.jpg" {
+		t.Errorf("applyVideoMetadata() task = %+v", task)
+	}
+	if task.Duration != 126 {
+		t.Errorf("Duration = %d, want 126", task.Duration)
+	}
+	if task.UploadDate != "2024-03-05" {
+		t.Errorf("UploadDate = %q, want 2024-03-05", task.UploadDate)
+	}
+	wantChapters := []types.VideoChapter{
+		{StartTime: 0, EndTime: 60.5, Title: "Intro"},
+		{StartTime: 60.5, EndTime: 125.6, Title: "Main"},
+	}
+	if !reflect.DeepEqual(task.Chapters, wantChapters) {
+		t.Errorf("Chapters = %+v, want %+v", task.Chapters, wantChapters)
+	}
+
+	// 发布日期格式不对时留空
+	applyVideoMetadata(&task, &ytdlpVideoMetadata{UploadDate: "2024-03-05", Duration: 0.4}, "")
+	if task.UploadDate != "" || task.Duration != 0 || len(task.Chapters) != 0 {
+		t.Errorf("applyVideoMetadata(invalid date) task = %+v", task)
+	}
+}
+
+func Test_downloadThumbnail(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cover.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(png)
+		case "/cover":
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write(png)
+		case "/page.png":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html></html>"))
+		case "/large.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write(make([]byte, maxThumbnailBytes+1))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	coverPath, err := downloadThumbnail(context.Background(), server.URL+"/cover.png", dir)
+	if err != nil {
+		t.Fatalf("downloadThumbnail() err = %v", err)
+	}
+	if data, _ := os.ReadFile(coverPath); filepath.Ext(coverPath) != ".png" || !bytes.Equal(data, png) {
+		t.Errorf("downloadThumbnail() = %s, content %q", coverPath, data)
+	}
+	// 链接没有图片扩展名时按jpg保存
+	if coverPath, err = downloadThumbnail(context.Background(), server.URL+"/cover", dir); err != nil || filepath.Ext(coverPath) != ".jpg" {
+		t.Errorf("downloadThumbnail(no ext) = %s, %v, want .jpg", coverPath, err)
+	}
+
+	for _, link := range []string{"ftp://example.com/cover.png", server.URL + "/page.png", server.URL + "/missing.png", server.URL + "/large.jpg"} {
+		if got, err := downloadThumbnail(context.Background(), link, dir); err == nil {
+			t.Errorf("downloadThumbnail(%s) = %s, want err", link, got)
+		}
+	}
+	// 超出大小的封面不保留部分内容
+	if _, err = os.Stat(filepath.Join(dir, types.SubtitleTaskCoverFileNamePrefix+".jpg")); !os.IsNotExist(err) {
+		t.Errorf("oversize cover not removed: %v", err)
+	}
+}
+
+func Test_getVideoInfoLocalWithoutDuration(t *testing.T) {
+	log.Logger = zap.NewNop()
+	useMemoryTaskRepo(t)
+	originFfprobePath := storage.FfprobePath
+	storage.FfprobePath = filepath.Join(t.TempDir(), "missing-ffprobe")
+	defer func() { storage.FfprobePath = originFfprobePath }()
+	if err := storage.SubtitleTaskRepo.Create(&types.SubtitleTask{TaskId: "task1", Status: types.SubtitleTaskStatusProcessing}); err != nil {
+		t.Fatal(err)
+	}
+
+	// ffprobe不可用时时长和章节都获取失败，仍然记录语言和进度
+	err := Service{}.getVideoInfo(context.Background(), &types.SubtitleTaskStepParam{
+		TaskId:         "task1",
+		Link:           "local:./uploads/a.mp4",
+		OriginLanguage: types.LanguageNameEnglish,
+		TargetLanguage: types.LanguageNameSimplifiedChinese,
+	})
+	if err != nil {
+		t.Fatalf("getVideoInfo() err = %v", err)
+	}
+	task, _ := storage.SubtitleTaskRepo.Get("task1")
+	if task.OriginLanguage != string(types.LanguageNameEnglish) || task.TargetLanguage != string(types.LanguageNameSimplifiedChinese) || task.ProcessPct != 10 {
+		t.Errorf("task = origin %q, target %q, pct %d", task.OriginLanguage, task.TargetLanguage, task.ProcessPct)
+	}
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+	"krillin-ai/config"
+	"krillin-ai/internal/metrics"
+	"krillin-ai/internal/storage"
+	"krillin-ai/internal/types"
+	"krillin-ai/log"
+	"krillin-ai/pkg/util"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 每次请求大模型翻译的字幕条数
+const importSubtitleTranslateBatchSize = 40
+
+var translatedSubtitleLineRegex = regexp.MustCompile(`^\[(\d+)\]\s*(.*)$`)
+
+// checkSubtitleSource 检查导入的字幕文件，返回本地路径
+func checkSubtitleSource(subtitleUrl string) (string, error) {
+	if !subtitleUploadExts[strings.ToLower(filepath.Ext(subtitleUrl))] {
+		return "", errors.New("只支持导入srt、vtt格式的字幕")
+	}
+	return resolveUploadedFile(subtitleUrl)
+}
+
+// importSubtitle 用导入的或平台提供的源语言字幕代替转录，逐条翻译后生成与audioToSubtitle相同的字幕文件
+func (s Service) importSubtitle(ctx context.Context, stepParam *types.SubtitleTaskStepParam) error {
+	log.GetLogger().Info("importSubtitle start", zap.String("task id", stepParam.TaskId))
+	recordTranscriptSource(stepParam)
+	blocks, err := util.ParseSubtitleFile(stepParam.SubtitleSourcePath)
+	if err != nil {
+		log.GetLogger().Error("importSubtitle ParseSubtitleFile err", zap.Any("stepParam", stepParam), zap.Error(err))
+		return fmt.Errorf("importSubtitle ParseSubtitleFile err: %w", err)
+	}
+	if len(blocks) == 0 {
+		return errors.New("importSubtitle 字幕文件中没有字幕")
+	}
+	updateTaskProcessPct(stepParam.TaskId, 20)
+
+	if stepParam.SubtitleResultType != types.SubtitleResultTypeOriginOnly {
+		if err = s.translateSubtitleBlocks(ctx, stepParam, blocks); err != nil {
+			return fmt.Errorf("importSubtitle translateSubtitleBlocks err: %w", err)
+		}
+	}
+
+	// 不带时间戳的译文和原文，格式与拆分翻译的结果相同，任务完成后用于更新翻译记忆
+	var noTs, bilingual, shortOriginMixed, shortOrigin strings.Builder
+	for i, block := range blocks {
+		noTs.WriteString(fmt.Sprintf("%d\n%s\n%s\n\n", block.Index, block.TargetLanguageSentence, block.OriginLanguageSentence))
+
+		bilingual.WriteString(fmt.Sprintf("%d\n%s\n", block.Index, block.Timestamp))
+		if block.TargetLanguageSentence == "" {
+			bilingual.WriteString(block.OriginLanguageSentence + "\n\n")
+		} else if stepParam.SubtitleResultType == types.SubtitleResultTypeBilingualTranslationOnTop {
+			bilingual.WriteString(block.TargetLanguageSentence + "\n" + block.OriginLanguageSentence + "\n\n")
+		} else {
+			bilingual.WriteString(block.OriginLanguageSentence + "\n" + block.TargetLanguageSentence + "\n\n")
+		}
+
+		// 导入的字幕没有逐词时间戳，原文不再拆成短句
+		mixedNum := 2*i + 1
+		if block.TargetLanguageSentence != "" {
+			shortOriginMixed.WriteString(fmt.Sprintf("%d\n%s\n%s\n\n", mixedNum, block.Timestamp, block.TargetLanguageSentence))
+			mixedNum++
+		}
+		shortOriginMixed.WriteString(fmt.Sprintf("%d\n%s\n%s\n\n", mixedNum, block.Timestamp, block.OriginLanguageSentence))
+		shortOrigin.WriteString(fmt.Sprintf("%d\n%s\n%s\n\n", block.Index, block.Timestamp, block.OriginLanguageSentence))
+	}
+	files := []struct {
+		name    string
+		content string
+	}{
+		{types.SubtitleTaskSrtNoTimestampFileName, noTs.String()},
+		{types.SubtitleTaskBilingualSrtFileName, bilingual.String()},
+		{types.SubtitleTaskShortOriginMixedSrtFileName, shortOriginMixed.String()},
+		{types.SubtitleTaskShortOriginSrtFileName, shortOrigin.String()},
+	}
+	for _, file := range files {
+		if err = os.WriteFile(filepath.Join(stepParam.TaskBasePath, file.name), []byte(file.content), 0644); err != nil {
+			log.GetLogger().Error("importSubtitle write file err", zap.Any("stepParam", stepParam), zap.String("file", file.name), zap.Error(err))
+			return fmt.Errorf("importSubtitle write file err: %w", err)
+		}
+	}
+	stepParam.SmallAudios = []*types.SmallAudio{{
+		Num:         1,
+		SrtNoTsFile: filepath.Join(stepParam.TaskBasePath, types.SubtitleTaskSrtNoTimestampFileName),
+	}}
+	stepParam.BilingualSrtFilePath = filepath.Join(stepParam.TaskBasePath, types.SubtitleTaskBilingualSrtFileName)
+	stepParam.ShortOriginMixedSrtFilePath = filepath.Join(stepParam.TaskBasePath, types.SubtitleTaskShortOriginMixedSrtFileName)
+	updateTaskProcessPct(stepParam.TaskId, 90)
+
+	if err = s.splitSrt(ctx, stepParam); err != nil {
+		return fmt.Errorf("importSubtitle splitSrt err: %w", err)
+	}
+	updateTaskProcessPct(stepParam.TaskId, 95)
+	log.GetLogger().Info("importSubtitle end", zap.String("task id", stepParam.TaskId), zap.Int("subtitle num", len(blocks)))
+	return nil
+}
+
+// translateSubtitleBlocks 分批并行翻译字幕，翻译记忆中有精确匹配的字幕直接使用记忆中的译文
+func (s Service) translateSubtitleBlocks(ctx context.Context, stepParam *types.SubtitleTaskStepParam, blocks []*util.SrtBlock) error {
+	var (
+		batchNum            = (len(blocks) + importSubtitleTranslateBatchSize - 1) / importSubtitleTranslateBatchSize
+		translatedNum       = 0
+		translatedNumMu     sync.Mutex
+		parallelControlChan = make(chan struct{}, config.Conf.App.TranslateParallelNum)
+	)
+	eg, egCtx := errgroup.WithContext(ctx)
+	for i := 0; i < batchNum; i++ {
+		select {
+		case parallelControlChan <- struct{}{}:
+		case <-egCtx.Done():
+		}
+		if egCtx.Err() != nil {
+			break
+		}
+		num := i + 1
+		batch := blocks[i*importSubtitleTranslateBatchSize : min((i+1)*importSubtitleTranslateBatchSize, len(blocks))]
+		eg.Go(func() error {
+			defer func() { <-parallelControlChan }()
+			if err := s.translateSubtitleBatch(egCtx, stepParam, "importSubtitle", num, batch); err != nil {
+				return err
+			}
+			translatedNumMu.Lock()
+			translatedNum++
+			processPct := uint8(20 + 70*translatedNum/batchNum)
+			translatedNumMu.Unlock()
+			updateTaskProcessPct(stepParam.TaskId, processPct)
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// translateSubtitleBatch 一次请求翻译一批字幕，name和num用于记录时间线
+func (s Service) translateSubtitleBatch(ctx context.Context, stepParam *types.SubtitleTaskStepParam, name string, num int, batch []*util.SrtBlock) error {
+	pending := make(map[int]*util.SrtBlock, len(batch))
+	var content strings.Builder
+	for _, block := range batch {
+		if translationMemoryEnabled(stepParam.TargetLanguage) {
+			if translation, ok := storage.TranslationMemoryStore.Lookup(string(stepParam.TargetLanguage), block.OriginLanguageSentence); ok {
+				block.TargetLanguageSentence = translation
+				continue
+			}
+		}
+		pending[block.Index] = block
+		content.WriteString(fmt.Sprintf("[%d] %s\n", block.Index, block.OriginLanguageSentence))
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	prompt := translationMemoryReferences(stepParam.TargetLanguage, content.String()) +
+		fmt.Sprintf(types.TranslateSubtitleLinesPrompt, types.GetStandardLanguageName(stepParam.TargetLanguage))
+	span := startTimelineSpan(stepParam.TaskId, types.TimelineKindTranslate, name, num)
+	var (
+		translations map[int]string
+		err          error
+		attempts     = 0
+	)
+	// 最多尝试4次获取条数完整的翻译结果
+	for i := 0; i < 4; i++ {
+		attempts++
+		var result string
+		result, err = s.ChatCompleter.ChatCompletion(ctx, prompt+content.String())
+		if ctx.Err() != nil {
+			span.finish(attempts, ctx.Err())
+			return ctx.Err()
+		}
+		if err != nil {
+			log.GetLogger().Warn("translateSubtitleBatch ChatCompletion error, retrying...",
+				zap.String("taskId", stepParam.TaskId), zap.Int("attempt", i+1), zap.Error(err))
+			continue
+		}
+		if translations, err = parseTranslatedSubtitleLines(result, pending); err == nil {
+			break
+		}
+		log.GetLogger().Warn("translateSubtitleBatch invalid response, retrying...",
+			zap.String("taskId", stepParam.TaskId), zap.Int("attempt", i+1), zap.Error(err))
+	}
+	span.finish(attempts, err)
+	metrics.LlmRetries.Add(float64(attempts-1), config.Conf.App.LlmProvider)
+	if err != nil {
+		log.GetLogger().Error("translateSubtitleBatch failed after retries", zap.String("taskId", stepParam.TaskId), zap.Int("num", num), zap.Error(err))
+		return fmt.Errorf("translateSubtitleBatch error: %w", err)
+	}
+	for index, translation := range translations {
+		pending[index].TargetLanguageSentence = translation
+	}
+	return nil
+}
+
+// parseTranslatedSubtitleLines 解析大模型返回的[编号] 译文，每条待翻译的字幕都必须有译文
+func parseTranslatedSubtitleLines(result string, pending map[int]*util.SrtBlock) (map[int]string, error) {
+	translations := make(map[int]string, len(pending))
+	for _, line := range strings.Split(result, "\n") {
+		matches := translatedSubtitleLineRegex.FindStringSubmatch(strings.TrimSpace(line))
+		if matches == nil {
+			continue
+		}
+		index, _ := strconv.Atoi(matches[1])
+		translation := strings.TrimSpace(matches[2])
+		if _, ok := pending[index]; ok && translation != "" {
+			translations[index] = translation
+		}
+	}
+	if len(translations) != len(pending) {
+		return nil, fmt.Errorf("translated %d of %d lines", len(translations), len(pending))
+	}
+	return translations, nil
+}
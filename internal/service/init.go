@@ -3,6 +3,7 @@ package service
 import (
 	"go.uber.org/zap"
 	"krillin-ai/config"
+	"krillin-ai/internal/metrics"
 	"krillin-ai/internal/types"
 	"krillin-ai/log"
 	"krillin-ai/pkg/aliyun"
@@ -45,10 +46,22 @@ func NewService() *Service {
 	log.GetLogger().Info("当前选择的LLM源： ", zap.String("llm", config.Conf.App.LlmProvider))
 
 	return &Service{
-		Transcriber:      transcriber,
-		ChatCompleter:    chatCompleter,
+		Transcriber:      metrics.NewInstrumentedTranscriber(config.Conf.App.TranscribeProvider, transcriber),
+		ChatCompleter:    metrics.NewInstrumentedChatCompleter(config.Conf.App.LlmProvider, chatCompleter),
 		TtsClient:        aliyun.NewTtsClient(config.Conf.Aliyun.Speech.AccessKeyId, config.Conf.Aliyun.Speech.AccessKeySecret, config.Conf.Aliyun.Speech.AppKey),
 		OssClient:        aliyun.NewOssClient(config.Conf.Aliyun.Oss.AccessKeyId, config.Conf.Aliyun.Oss.AccessKeySecret, config.Conf.Aliyun.Oss.Bucket),
 		VoiceCloneClient: aliyun.NewVoiceCloneClient(config.Conf.Aliyun.Speech.AccessKeyId, config.Conf.Aliyun.Speech.AccessKeySecret, config.Conf.Aliyun.Speech.AppKey),
 	}
 }
+
+// 转录使用的模型名，用于区分转录缓存
+func transcriptionModelName() string {
+	switch config.Conf.App.TranscribeProvider {
+	case "openai":
+		return "whisper-1"
+	case "aliyun":
+		return "paraformer-realtime-v2"
+	default:
+		return config.Conf.LocalModel.Whisper
+	}
+}
@@ -2,101 +2,100 @@ package service
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"go.uber.org/zap"
-	"krillin-ai/config"
 	"krillin-ai/internal/storage"
 	"krillin-ai/internal/types"
 	"krillin-ai/log"
-	"krillin-ai/pkg/util"
 	"os/exec"
 	"strings"
 )
 
 func (s Service) linkToFile(ctx context.Context, stepParam *types.SubtitleTaskStepParam) error {
-	var (
-		err    error
-		output []byte
-	)
+	var err error
 	link := stepParam.Link
 	audioPath := fmt.Sprintf("%s/%s", stepParam.TaskBasePath, types.SubtitleTaskAudioFileName)
 	videoPath := fmt.Sprintf("%s/%s", stepParam.TaskBasePath, types.SubtitleTaskVideoFileName)
-	storage.SubtitleTasks[stepParam.TaskId].ProcessPct = 3
+	updateTaskProcessPct(stepParam.TaskId, 3)
 	if strings.Contains(link, "local:") {
 		// 本地文件
 		videoPath = strings.ReplaceAll(link, "local:", "")
 		stepParam.InputVideoPath = videoPath
-		cmd := exec.Command(storage.FfmpegPath, "-i", videoPath, "-vn", "-ar", "44100", "-ac", "2", "-ab", "192k", "-f", "mp3", audioPath)
-		output, err = cmd.CombinedOutput()
-		if err != nil {
-			log.GetLogger().Error("generateAudioSubtitles.linkToFile ffmpeg error", zap.Any("step param", stepParam), zap.String("output", string(output)), zap.Error(err))
+		if err = extractAudio(ctx, videoPath, audioPath); err != nil {
+			log.GetLogger().Error("generateAudioSubtitles.linkToFile ffmpeg error", zap.Any("step param", stepParam), zap.Error(err))
 			return fmt.Errorf("generateAudioSubtitles.linkToFile ffmpeg error: %w", err)
 		}
-	} else if strings.Contains(link, "youtube.com") {
-		var videoId string
-		videoId, err = util.GetYouTubeID(link)
+		updateTaskProcessPct(stepParam.TaskId, 6)
+	} else {
+		var source *resolvedSource
+		source, err = resolveSource(link)
 		if err != nil {
-			log.GetLogger().Error("linkToFile.GetYouTubeID error", zap.Any("step param", stepParam), zap.Error(err))
-			return fmt.Errorf("linkToFile.GetYouTubeID error: %w", err)
-		}
-		stepParam.Link = "https://www.youtube.com/watch?v=" + videoId
-		cmdArgs := []string{"-f", "bestaudio", "--extract-audio", "--audio-format", "mp3", "--audio-quality", "192K", "-o", audioPath, stepParam.Link}
-		if config.Conf.App.Proxy != "" {
-			cmdArgs = append(cmdArgs, "--proxy", config.Conf.App.Proxy)
-		}
-		cmdArgs = append(cmdArgs, "--cookies", "./cookies.txt")
-		if storage.FfmpegPath != "ffmpeg" {
-			cmdArgs = append(cmdArgs, "--ffmpeg-location", storage.FfmpegPath)
+			log.GetLogger().Error("linkToFile resolveSource error", zap.Any("step param", stepParam), zap.Error(err))
+			return fmt.Errorf("linkToFile resolveSource error: %w", err)
 		}
-		cmd := exec.Command(storage.YtdlpPath, cmdArgs...)
-		output, err = cmd.CombinedOutput()
-		if err != nil {
-			log.GetLogger().Error("linkToFile download audio yt-dlp error", zap.Any("step param", stepParam), zap.String("output", string(output)), zap.Error(err))
-			return fmt.Errorf("linkToFile download audio yt-dlp error: %w", err)
+		stepParam.Link = source.Url
+		if err = source.resolver.Download(ctx, stepParam, source, audioPath, videoPath); err != nil {
+			return err
 		}
-	} else if strings.Contains(link, "bilibili.com") {
-		videoId := util.GetBilibiliVideoId(link)
-		if videoId == "" {
-			return errors.New("linkToFile error: invalid link")
+		// 已导入字幕时不再需要平台字幕
+		if source.resolver.Captions && stepParam.SubtitleSourcePath == "" &&
+			stepParam.CaptionPolicy != "" && stepParam.CaptionPolicy != types.CaptionPolicyNever {
+			usePlatformCaption(ctx, stepParam, source)
 		}
-		stepParam.Link = "https://www.bilibili.com/video/" + videoId
-		cmdArgs := []string{"-f", "bestaudio[ext=m4a]", "-x", "--audio-format", "mp3", "-o", audioPath, stepParam.Link}
-		if config.Conf.App.Proxy != "" {
-			cmdArgs = append(cmdArgs, "--proxy", config.Conf.App.Proxy)
-		}
-		if storage.FfmpegPath != "ffmpeg" {
-			cmdArgs = append(cmdArgs, "--ffmpeg-location", storage.FfmpegPath)
-		}
-		cmd := exec.Command(storage.YtdlpPath, cmdArgs...)
-		output, err = cmd.CombinedOutput()
+	}
+	stepParam.AudioFilePath = audioPath
+
+	// 更新字幕任务信息
+	updateTaskProcessPct(stepParam.TaskId, 10)
+	return nil
+}
+
+// needInputVideo 只有需要合成字幕视频时才保留原视频
+func needInputVideo(stepParam *types.SubtitleTaskStepParam) bool {
+	return stepParam.EmbedSubtitleVideoType != "none"
+}
+
+// extractAudio 从音视频文件中提取mp3音频
+func extractAudio(ctx context.Context, input, audioPath string) error {
+	cmd := exec.CommandContext(ctx, storage.FfmpegPath, "-y", "-i", input, "-vn", "-ar", "44100", "-ac", "2", "-ab", "192k", "-f", "mp3", audioPath)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		recordProcessFailure(ctx, cmd)
+		return fmt.Errorf("extractAudio ffmpeg error: %w, output: %s", err, string(output))
+	}
+	return nil
+}
+
+// ytdlpDownloader 通过yt-dlp分别下载音频和原视频
+func ytdlpDownloader(audioArgs []string, videoFormat string) sourceDownloader {
+	return func(ctx context.Context, stepParam *types.SubtitleTaskStepParam, src *resolvedSource, audioPath, videoPath string) error {
+		cmdArgs := append(append([]string{}, audioArgs...), "-o", audioPath, src.Url)
+		cmdArgs = append(cmdArgs, ytdlpSourceArgs(src)...)
+		cmd := exec.CommandContext(ctx, storage.YtdlpPath, cmdArgs...)
+		output, err := cmd.CombinedOutput()
 		if err != nil {
+			recordProcessFailure(ctx, cmd)
 			log.GetLogger().Error("linkToFile download audio yt-dlp error", zap.Any("step param", stepParam), zap.String("output", string(output)), zap.Error(err))
 			return fmt.Errorf("linkToFile download audio yt-dlp error: %w", err)
 		}
-	} else {
-		log.GetLogger().Info("linkToFile.unsupported link type", zap.Any("step param", stepParam))
-		return errors.New("linkToFile error: unsupported link, only support youtube, bilibili and local file")
-	}
-	storage.SubtitleTasks[stepParam.TaskId].ProcessPct = 6
-	stepParam.AudioFilePath = audioPath
+		updateTaskProcessPct(stepParam.TaskId, 6)
+		recordDownloadedBytes(audioPath)
 
-	if !strings.HasPrefix(link, "local:") && stepParam.EmbedSubtitleVideoType != "none" {
-		// 需要下载原视频
-		cmdArgs := []string{"-f", "bestvideo[height<=1080][ext=mp4]+bestaudio[ext=m4a]/bestvideo[height<=720][ext=mp4]+bestaudio[ext=m4a]/bestvideo[height<=480][ext=mp4]+bestaudio[ext=m4a]", "-o", videoPath, stepParam.Link}
-		if config.Conf.App.Proxy != "" {
-			cmdArgs = append(cmdArgs, "--proxy", config.Conf.App.Proxy)
+		if !needInputVideo(stepParam) {
+			return nil
 		}
-		cmd := exec.Command(storage.YtdlpPath, cmdArgs...)
+		// 需要下载原视频
+		cmdArgs = []string{"-f", videoFormat, "--merge-output-format", "mp4", "-o", videoPath, src.Url}
+		cmdArgs = append(cmdArgs, ytdlpSourceArgs(src)...)
+		cmd = exec.CommandContext(ctx, storage.YtdlpPath, cmdArgs...)
 		output, err = cmd.CombinedOutput()
 		if err != nil {
+			recordProcessFailure(ctx, cmd)
 			log.GetLogger().Error("linkToFile download video yt-dlp error", zap.Any("step param", stepParam), zap.String("output", string(output)), zap.Error(err))
 			return fmt.Errorf("linkToFile download video yt-dlp error: %w", err)
 		}
 		stepParam.InputVideoPath = videoPath
+		recordDownloadedBytes(videoPath)
+		return nil
 	}
-
-	// 更新字幕任务信息
-	storage.SubtitleTasks[stepParam.TaskId].ProcessPct = 10
-	return nil
 }
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"krillin-ai/internal/metrics"
+	"krillin-ai/internal/storage"
+	"krillin-ai/internal/types"
+	"krillin-ai/log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 任务目录较大时遍历耗时，磁盘占用结果缓存一段时间
+const taskDiskUsageCacheTtl = time.Minute
+
+var taskDiskUsageCache struct {
+	sync.Mutex
+	bytes     int64
+	updatedAt time.Time
+}
+
+var (
+	_ = metrics.NewGaugeFunc("krillin_tasks", "Subtitle tasks by status.", []string{"status"}, collectTasksByStatus)
+	_ = metrics.NewGaugeFunc("krillin_task_queue_depth", "Subtitle tasks waiting in the queue.", nil, func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(taskScheduler.depth())}}
+	})
+	_ = metrics.NewGaugeFunc("krillin_tasks_disk_usage_bytes", "Disk usage of the tasks directory.", nil, collectTaskDiskUsage)
+)
+
+var subtitleTaskStatusNames = map[uint8]string{
+	types.SubtitleTaskStatusProcessing:  "processing",
+	types.SubtitleTaskStatusSuccess:     "success",
+	types.SubtitleTaskStatusFailed:      "failed",
+	types.SubtitleTaskStatusInterrupted: "interrupted",
+	types.SubtitleTaskStatusCancelled:   "cancelled",
+	types.SubtitleTaskStatusQueued:      "queued",
+}
+
+func collectTasksByStatus() []metrics.Sample {
+	if storage.SubtitleTaskRepo == nil {
+		return nil
+	}
+	tasks, _, err := storage.SubtitleTaskRepo.List(storage.SubtitleTaskQuery{})
+	if err != nil {
+		log.GetLogger().Error("collectTasksByStatus list tasks err", zap.Error(err))
+		return nil
+	}
+	counts := make(map[uint8]int)
+	for _, task := range tasks {
+		counts[task.Status]++
+	}
+	samples := make([]metrics.Sample, 0, len(subtitleTaskStatusNames))
+	for status := types.SubtitleTaskStatusProcessing; status <= types.SubtitleTaskStatusQueued; status++ {
+		samples = append(samples, metrics.Sample{
+			LabelValues: []string{subtitleTaskStatusNames[status]},
+			Value:       float64(counts[status]),
+		})
+	}
+	return samples
+}
+
+func collectTaskDiskUsage() []metrics.Sample {
+	taskDiskUsageCache.Lock()
+	defer taskDiskUsageCache.Unlock()
+	if time.Since(taskDiskUsageCache.updatedAt) > taskDiskUsageCacheTtl {
+		entry, err := newDiskEntry("tasks", taskWorkspaceRoot)
+		if err == nil {
+			taskDiskUsageCache.bytes = entry.size
+		}
+		taskDiskUsageCache.updatedAt = time.Now()
+	}
+	return []metrics.Sample{{Value: float64(taskDiskUsageCache.bytes)}}
+}
+
+// 按可执行文件名统计外部进程失败次数，如ffmpeg、ffprobe、yt-dlp。任务取消或超时导致进程被kill的不计入
+func recordProcessFailure(ctx context.Context, cmd *exec.Cmd) {
+	if ctx.Err() != nil {
+		return
+	}
+	name := strings.TrimSuffix(filepath.Base(cmd.Path), filepath.Ext(cmd.Path))
+	metrics.ProcessFailures.Inc(name)
+}
+
+func recordDownloadedBytes(path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	metrics.DownloadedBytes.Add(float64(info.Size()))
+}
+
+// recordStepDuration result标签为success或failure
+func recordStepDuration(step string, duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	metrics.StepDuration.Observe(duration.Seconds(), step, result)
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"krillin-ai/internal/storage"
+	"krillin-ai/internal/types"
+	"krillin-ai/log"
+	"krillin-ai/pkg/util"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// checkCaptionPolicy 检查请求中的平台字幕策略，为空时使用配置
+func checkCaptionPolicy(policy string) error {
+	switch policy {
+	case "", types.CaptionPolicyNever, types.CaptionPolicyManual, types.CaptionPolicyAuto:
+		return nil
+	}
+	return errors.New("不支持的平台字幕策略")
+}
+
+// usePlatformCaption 按字幕策略获取视频平台提供的源语言字幕，获取到时代替语音识别，获取失败不影响任务
+func usePlatformCaption(ctx context.Context, stepParam *types.SubtitleTaskStepParam, src *resolvedSource) {
+	kinds := []string{types.TranscriptSourceManualCaption}
+	if stepParam.CaptionPolicy == types.CaptionPolicyAuto {
+		kinds = append(kinds, types.TranscriptSourceAutoCaption)
+	}
+	for _, kind := range kinds {
+		captionPath, err := downloadPlatformCaption(ctx, stepParam, src, kind)
+		if err != nil {
+			log.GetLogger().Warn("usePlatformCaption downloadPlatformCaption err", zap.String("taskId", stepParam.TaskId), zap.String("kind", kind), zap.Error(err))
+			continue
+		}
+		if captionPath == "" {
+			continue
+		}
+		stepParam.SubtitleSourcePath = captionPath
+		stepParam.TranscriptSource = kind
+		log.GetLogger().Info("使用平台字幕代替语音识别", zap.String("taskId", stepParam.TaskId), zap.String("kind", kind))
+		return
+	}
+	log.GetLogger().Info("没有可用的平台字幕，使用语音识别", zap.String("taskId", stepParam.TaskId))
+}
+
+// platformCaptionLangs 源语言在各平台上可能的字幕语言代码，按优先级排列，yt-dlp按正则匹配
+// b站的AI字幕以ai-开头，虽然和作者字幕一起返回，但属于自动生成的字幕，只在允许自动字幕时使用
+func platformCaptionLangs(originLanguage types.StandardLanguageName, kind string) []string {
+	var (
+		langs []string
+		code  = string(originLanguage)
+	)
+	switch originLanguage {
+	case "", types.LanguageNamePinyin:
+		return nil
+	case types.LanguageNameSimplifiedChinese:
+		langs, code = []string{"zh-Hans", "zh-CN", "zh"}, "zh"
+	case types.LanguageNameTraditionalChinese:
+		langs, code = []string{"zh-Hant", "zh-TW", "zh-HK"}, "zh"
+	default:
+		langs = []string{code, code + "-[A-Za-z]+"}
+	}
+	if kind == types.TranscriptSourceAutoCaption {
+		langs = append(langs, "ai-"+code)
+	}
+	return langs
+}
+
+// downloadPlatformCaption 用yt-dlp下载一种平台字幕，选出语言优先级最高且有内容的一条，规范化为srt后返回路径，没有可用字幕时返回空
+func downloadPlatformCaption(ctx context.Context, stepParam *types.SubtitleTaskStepParam, src *resolvedSource, kind string) (string, error) {
+	langs := platformCaptionLangs(stepParam.OriginLanguage, kind)
+	if len(langs) == 0 {
+		return "", nil
+	}
+	captionDir := filepath.Join(stepParam.TaskBasePath, types.SubtitleTaskPlatformCaptionDirName)
+	if err := os.MkdirAll(captionDir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("downloadPlatformCaption mkdir err: %w", err)
+	}
+	cmdArgs := []string{"--skip-download", "--write-subs"}
+	if kind == types.TranscriptSourceAutoCaption {
+		cmdArgs = append(cmdArgs, "--write-auto-subs")
+	}
+	cmdArgs = append(cmdArgs, "--sub-langs", strings.Join(langs, ","), "--sub-format", "srt/vtt/best",
+		"-o", filepath.Join(captionDir, kind+".%(ext)s"), src.Url)
+	cmdArgs = append(cmdArgs, ytdlpSourceArgs(src)...)
+	cmd := exec.CommandContext(ctx, storage.YtdlpPath, cmdArgs...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("downloadPlatformCaption yt-dlp err: %w, output: %s", err, string(output))
+	}
+
+	// yt-dlp保存的字幕文件名为 <kind>.<语言>.<格式>
+	files, err := filepath.Glob(filepath.Join(captionDir, kind+".*"))
+	if err != nil {
+		return "", fmt.Errorf("downloadPlatformCaption glob err: %w", err)
+	}
+	for _, lang := range langs {
+		langRegex, err := regexp.Compile("^(?:" + lang + ")$")
+		if err != nil {
+			return "", fmt.Errorf("downloadPlatformCaption compile lang err: %w", err)
+		}
+		for _, file := range files {
+			ext := filepath.Ext(file)
+			fileLang := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), kind+"."), ext)
+			if !subtitleUploadExts[strings.ToLower(ext)] || !langRegex.MatchString(fileLang) {
+				continue
+			}
+			blocks, err := util.ParseSubtitleFile(file)
+			if err != nil {
+				log.GetLogger().Warn("downloadPlatformCaption ParseSubtitleFile err", zap.String("taskId", stepParam.TaskId), zap.String("file", file), zap.Error(err))
+				continue
+			}
+			if kind == types.TranscriptSourceAutoCaption {
+				blocks = util.DedupeRollingSubtitle(blocks)
+			}
+			if len(blocks) == 0 {
+				continue
+			}
+			var content strings.Builder
+			for _, block := range blocks {
+				content.WriteString(fmt.Sprintf("%d\n%s\n%s\n\n", block.Index, block.Timestamp, block.OriginLanguageSentence))
+			}
+			captionPath := filepath.Join(stepParam.TaskBasePath, types.SubtitleTaskPlatformCaptionFileName)
+			if err = os.WriteFile(captionPath, []byte(content.String()), 0644); err != nil {
+				return "", fmt.Errorf("downloadPlatformCaption write file err: %w", err)
+			}
+			log.GetLogger().Info("downloadPlatformCaption 获取到平台字幕", zap.String("taskId", stepParam.TaskId), zap.String("file", file), zap.Int("subtitle num", len(blocks)))
+			return captionPath, nil
+		}
+	}
+	return "", nil
+}
+
+// recordTranscriptSource 把原文字幕的来源写入任务
+func recordTranscriptSource(stepParam *types.SubtitleTaskStepParam) {
+	updateTask(stepParam.TaskId, func(task *types.SubtitleTask) {
+		task.TranscriptSource = stepParam.TranscriptSource
+	})
+}
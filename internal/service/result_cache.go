@@ -0,0 +1,322 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"krillin-ai/internal/dto"
+	"krillin-ai/internal/storage"
+	"krillin-ai/internal/types"
+	"krillin-ai/log"
+	"krillin-ai/pkg/util"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 影响任务产物的参数，任意一项不同都不能复用结果
+type subtitleTaskCacheOptions struct {
+	Source                 string   `json:"source"`
+	OriginLanguage         string   `json:"origin_language"`
+	TargetLanguage         string   `json:"target_language"`
+	Bilingual              uint8    `json:"bilingual"`
+	TranslationSubtitlePos uint8    `json:"translation_subtitle_pos"`
+	ModalFilter            uint8    `json:"modal_filter"`
+	Tts                    uint8    `json:"tts"`
+	TtsVoiceCode           uint8    `json:"tts_voice_code"`
+	TtsVoiceCloneSrc       string   `json:"tts_voice_clone_src"`
+	Replace                []string `json:"replace"`
+	EmbedSubtitleVideoType string   `json:"embed_subtitle_video_type"`
+	VerticalMajorTitle     string   `json:"vertical_major_title"`
+	VerticalMinorTitle     string   `json:"vertical_minor_title"`
+	MaxWordOneLine         int      `json:"max_word_one_line"`
+	Steps                  []string `json:"steps"`
+	SubtitleSource         string   `json:"subtitle_source,omitempty"` // 为空时不参与序列化，保持导入字幕功能之前的缓存键不变
+	CaptionPolicy          string   `json:"caption_policy,omitempty"`  // 不使用平台字幕时为空，同上
+}
+
+// subtitleTaskSourceIdentity 规范化视频来源，同一视频的不同链接形式得到相同的标识，本地文件使用内容哈希
+func subtitleTaskSourceIdentity(link string) (string, error) {
+	if strings.HasPrefix(link, "local:") {
+		hash, err := cachedFileSha256(strings.TrimPrefix(link, "local:"))
+		if err != nil {
+			return "", err
+		}
+		return "file:" + hash, nil
+	}
+	source, err := resolveSource(link)
+	if err != nil {
+		return "url:" + link, nil
+	}
+	return source.Identity(), nil
+}
+
+func fileSha256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("fileSha256 open file err: %w", err)
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("fileSha256 read file err: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// fileHashCacheEntry 文件的sha256及计算时的大小和修改时间，文件变化后重新计算
+type fileHashCacheEntry struct {
+	size    int64
+	modTime time.Time
+	hash    string
+}
+
+// 创建任务时需要本地文件的哈希计算缓存键，上传时顺带计算并缓存，避免请求中重新读取大文件
+var fileHashCache sync.Map
+
+// cachedFileSha256 优先使用缓存的哈希，没有缓存或文件已变化时重新计算
+func cachedFileSha256(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("cachedFileSha256 stat err: %w", err)
+	}
+	if value, ok := fileHashCache.Load(filepath.Clean(path)); ok {
+		entry := value.(fileHashCacheEntry)
+		if entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+			return entry.hash, nil
+		}
+	}
+	hash, err := fileSha256(path)
+	if err != nil {
+		return "", err
+	}
+	fileHashCache.Store(filepath.Clean(path), fileHashCacheEntry{size: info.Size(), modTime: info.ModTime(), hash: hash})
+	return hash, nil
+}
+
+// rememberFileSha256 写入文件时已计算出哈希，直接缓存
+func rememberFileSha256(path, hash string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	fileHashCache.Store(filepath.Clean(path), fileHashCacheEntry{size: info.Size(), modTime: info.ModTime(), hash: hash})
+}
+
+func forgetFileSha256(path string) {
+	fileHashCache.Delete(filepath.Clean(path))
+}
+
+func subtitleTaskCacheKey(req dto.StartVideoSubtitleTaskReq, stepNames []string) (string, error) {
+	var (
+		source string
+		err    error
+	)
+	if req.Url != "" {
+		if source, err = subtitleTaskSourceIdentity(req.Url); err != nil {
+			return "", err
+		}
+	}
+	opts := subtitleTaskCacheOptions{
+		Source:                 source,
+		OriginLanguage:         req.OriginLanguage,
+		TargetLanguage:         req.TargetLang,
+		Bilingual:              req.Bilingual,
+		TranslationSubtitlePos: req.TranslationSubtitlePos,
+		ModalFilter:            req.ModalFilter,
+		Tts:                    req.Tts,
+		EmbedSubtitleVideoType: req.EmbedSubtitleVideoType,
+		VerticalMajorTitle:     req.VerticalMajorTitle,
+		VerticalMinorTitle:     req.VerticalMinorTitle,
+		MaxWordOneLine:         req.OriginLanguageWordOneLine,
+		Steps:                  stepNames,
+	}
+	if req.Tts == types.SubtitleTaskTtsYes {
+		opts.TtsVoiceCode = req.TtsVoiceCode
+		if req.TtsVoiceCloneSrcFileUrl != "" {
+			if opts.TtsVoiceCloneSrc, err = subtitleTaskSourceIdentity(req.TtsVoiceCloneSrcFileUrl); err != nil {
+				return "", err
+			}
+		}
+	}
+	if req.SubtitleUrl != "" {
+		if opts.SubtitleSource, err = subtitleTaskSourceIdentity(req.SubtitleUrl); err != nil {
+			return "", err
+		}
+	}
+	// 导入了字幕时不会使用平台字幕
+	if req.CaptionPolicy != types.CaptionPolicyNever && req.SubtitleUrl == "" {
+		opts.CaptionPolicy = req.CaptionPolicy
+	}
+	opts.Replace = append([]string(nil), req.Replace...)
+	sort.Strings(opts.Replace)
+
+	data, err := json.Marshal(opts)
+	if err != nil {
+		return "", fmt.Errorf("subtitleTaskCacheKey marshal err: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// findCachedSubtitleTask 查找相同缓存键的成功任务，产物已被清理的任务不算命中
+func findCachedSubtitleTask(cacheKey string) *types.SubtitleTask {
+	tasks, _, err := storage.SubtitleTaskRepo.List(storage.SubtitleTaskQuery{
+		Status:   types.SubtitleTaskStatusSuccess,
+		CacheKey: cacheKey,
+		OrderBy:  storage.SubtitleTaskOrderByUpdateTime,
+		Desc:     true,
+	})
+	if err != nil {
+		log.GetLogger().Error("findCachedSubtitleTask list err", zap.String("cacheKey", cacheKey), zap.Error(err))
+		return nil
+	}
+	for _, task := range tasks {
+		if subtitleTaskOutputsExist(task) {
+			return task
+		}
+	}
+	return nil
+}
+
+func subtitleTaskOutputPaths(task *types.SubtitleTask) []string {
+	paths := make([]string, 0, len(task.SubtitleInfos)+1)
+	for _, info := range task.SubtitleInfos {
+		paths = append(paths, strings.TrimPrefix(info.DownloadUrl, "/api/file/"))
+	}
+	if task.SpeechDownloadUrl != "" {
+		paths = append(paths, strings.TrimPrefix(task.SpeechDownloadUrl, "/api/file/"))
+	}
+	return paths
+}
+
+func subtitleTaskOutputsExist(task *types.SubtitleTask) bool {
+	for _, path := range subtitleTaskOutputPaths(task) {
+		if _, err := os.Stat(path); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// cloneCachedSubtitleTask 把命中任务的产物复制到新任务目录，并返回指向新目录的任务信息
+func cloneCachedSubtitleTask(src *types.SubtitleTask, taskId, taskBasePath string) (*types.SubtitleTask, error) {
+	srcBasePath := filepath.Join("./tasks", src.TaskId)
+	clonePath := func(path string) (string, error) {
+		rel, err := filepath.Rel(srcBasePath, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return "", fmt.Errorf("cloneCachedSubtitleTask invalid output path: %s", path)
+		}
+		dst := filepath.Join(taskBasePath, rel)
+		if err = os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+			return "", fmt.Errorf("cloneCachedSubtitleTask mkdir err: %w", err)
+		}
+		if err = linkOrCopyFile(path, dst); err != nil {
+			return "", fmt.Errorf("cloneCachedSubtitleTask copy file err: %w", err)
+		}
+		return dst, nil
+	}
+
+	task := &types.SubtitleTask{
+		TaskId:                taskId,
+		Title:                 src.Title,
+		Description:           src.Description,
+		TranslatedTitle:       src.TranslatedTitle,
+		TranslatedDescription: src.TranslatedDescription,
+		Duration:              src.Duration,
+		SrtNum:                src.SrtNum,
+		Cover:                 src.Cover,
+		Status:                types.SubtitleTaskStatusSuccess,
+		ProcessPct:            100,
+		CacheKey:              src.CacheKey,
+		CacheHitTaskId:        src.TaskId,
+		TranscriptSource:      src.TranscriptSource,
+		Uploader:              src.Uploader,
+		UploadDate:            src.UploadDate,
+		Chapters:              append([]types.VideoChapter(nil), src.Chapters...),
+	}
+	for _, info := range src.SubtitleInfos {
+		dst, err := clonePath(strings.TrimPrefix(info.DownloadUrl, "/api/file/"))
+		if err != nil {
+			return nil, err
+		}
+		task.SubtitleInfos = append(task.SubtitleInfos, types.SubtitleInfo{
+			TaskId:      taskId,
+			Name:        info.Name,
+			DownloadUrl: "/api/file/" + filepath.ToSlash(dst),
+		})
+	}
+	if src.SpeechDownloadUrl != "" {
+		dst, err := clonePath(strings.TrimPrefix(src.SpeechDownloadUrl, "/api/file/"))
+		if err != nil {
+			return nil, err
+		}
+		task.SpeechDownloadUrl = "/api/file/" + filepath.ToSlash(dst)
+	}
+	// 封面在output目录下，下载地址指向新任务
+	if strings.HasPrefix(src.Cover, "/api/file/") {
+		dst, err := clonePath(strings.TrimPrefix(src.Cover, "/api/file/"))
+		if err != nil {
+			return nil, err
+		}
+		task.Cover = "/api/file/" + filepath.ToSlash(dst)
+	}
+	// 合成的视频在output目录下，没有下载链接，一并复制
+	outputs, err := os.ReadDir(filepath.Join(srcBasePath, "output"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("cloneCachedSubtitleTask read output dir err: %w", err)
+	}
+	for _, output := range outputs {
+		if output.IsDir() {
+			continue
+		}
+		dst := filepath.Join(taskBasePath, "output", output.Name())
+		if _, err = os.Stat(dst); err == nil {
+			continue
+		}
+		if _, err = clonePath(filepath.Join(srcBasePath, "output", output.Name())); err != nil {
+			return nil, err
+		}
+	}
+	return task, nil
+}
+
+// 优先使用硬链接节省磁盘，跨文件系统等情况下退回复制
+func linkOrCopyFile(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	return util.CopyFile(src, dst)
+}
+
+// startSubtitleTaskFromCache 命中缓存时直接生成一个已成功的任务，不再排队执行
+func (s Service) startSubtitleTaskFromCache(req dto.StartVideoSubtitleTaskReq, cached *types.SubtitleTask, taskId, taskBasePath string) (*dto.StartVideoSubtitleTaskResData, error) {
+	task, err := cloneCachedSubtitleTask(cached, taskId, taskBasePath)
+	if err != nil {
+		log.GetLogger().Error("startSubtitleTaskFromCache cloneCachedSubtitleTask err", zap.String("cached taskId", cached.TaskId), zap.Error(err))
+		_ = os.RemoveAll(taskBasePath)
+		return nil, errors.New("复用已有任务结果失败，请使用force_rerun重新执行")
+	}
+	task.VideoSrc = req.Url
+	task.OriginLanguage = req.OriginLanguage
+	task.TargetLanguage = req.TargetLang
+	task.CallbackUrl = req.CallbackUrl
+	task.CallbackSecret = req.CallbackSecret
+	task.ParentTaskId = req.ParentTaskId
+	if err = storage.SubtitleTaskRepo.Create(task); err != nil {
+		log.GetLogger().Error("startSubtitleTaskFromCache create task err", zap.Any("req", req), zap.Error(err))
+		return nil, errors.New("创建任务失败")
+	}
+	log.GetLogger().Info("StartVideoSubtitleTask 命中已有任务结果", zap.String("taskId", taskId), zap.String("cached taskId", cached.TaskId))
+	publishTaskSucceeded(taskId)
+	return &dto.StartVideoSubtitleTaskResData{
+		TaskId: taskId,
+	}, nil
+}
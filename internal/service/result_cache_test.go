@@ -0,0 +1,42 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_cachedFileSha256(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.mp4")
+	if err := os.WriteFile(path, []byte("aaaa"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := fileSha256(path)
+	if got, err := cachedFileSha256(path); err != nil || got != want {
+		t.Fatalf("cachedFileSha256() = %s, %v, want %s", got, err, want)
+	}
+
+	// 上传时记录的哈希直接使用
+	rememberFileSha256(path, "remembered")
+	if got, _ := cachedFileSha256(path); got != "remembered" {
+		t.Errorf("cachedFileSha256() = %s, want remembered", got)
+	}
+
+	// 文件修改后重新计算
+	if err := os.WriteFile(path, []byte("bbbb"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	want, _ = fileSha256(path)
+	if got, _ := cachedFileSha256(path); got != want {
+		t.Errorf("cachedFileSha256(modified) = %s, want %s", got, want)
+	}
+	forgetFileSha256(path)
+	if _, ok := fileHashCache.Load(filepath.Clean(path)); ok {
+		t.Error("fileHashCache still contains forgotten file")
+	}
+}
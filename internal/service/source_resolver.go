@@ -0,0 +1,299 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"krillin-ai/config"
+	"krillin-ai/internal/storage"
+	"krillin-ai/internal/types"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// resolvedSource 解析后的视频链接
+type resolvedSource struct {
+	Resolver string // 处理该链接的站点
+	Id       string // 站点内的视频id，通用站点为空
+	Url      string // 规范化后交给yt-dlp下载的链接
+	resolver *sourceResolver
+}
+
+// Identity 同一视频的不同链接形式得到相同的标识，用于复用任务结果
+func (src *resolvedSource) Identity() string {
+	if src.Id != "" {
+		return src.Resolver + ":" + src.Id
+	}
+	return "url:" + src.Url
+}
+
+// sourceDownloader 把解析后的链接下载为任务目录下的音频文件，需要原视频时同时设置InputVideoPath
+type sourceDownloader func(ctx context.Context, stepParam *types.SubtitleTaskStepParam, src *resolvedSource, audioPath, videoPath string) error
+
+// sourceResolver 一类链接的处理方式，站点链接通过yt-dlp下载，媒体文件链接直接下载
+type sourceResolver struct {
+	Name     string
+	Match    func(u *url.URL) bool
+	Resolve  func(u *url.URL) (*resolvedSource, error)
+	Download sourceDownloader
+	Captions bool // 是否可以通过yt-dlp获取平台提供的字幕
+}
+
+const (
+	sourceResolverDirect  = "direct"  // 可直接下载的音视频文件
+	sourceResolverStream  = "stream"  // HLS/DASH播放列表，由ffmpeg转封装
+	sourceResolverGeneric = "generic" // 其它yt-dlp支持的站点
+)
+
+var (
+	youtubeIdRegex  = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+	bilibiliIdRegex = regexp.MustCompile(`(?i)^(BV[A-Za-z0-9]{10}|av\d+)$`)
+)
+
+const defaultVideoFormat = "bestvideo[height<=1080][ext=mp4]+bestaudio[ext=m4a]/bestvideo[height<=720][ext=mp4]+bestaudio[ext=m4a]/bestvideo[height<=480][ext=mp4]+bestaudio[ext=m4a]"
+
+// followRedirect 获取短链接跳转后的地址
+var followRedirect = func(link string) (string, error) {
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			Proxy: http.ProxyURL(config.Conf.App.ParsedProxy),
+		},
+	}
+	resp, err := client.Get(link)
+	if err != nil {
+		return "", fmt.Errorf("followRedirect get err: %w", err)
+	}
+	defer resp.Body.Close()
+	return resp.Request.URL.String(), nil
+}
+
+func hostIs(host string, domains ...string) bool {
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// 按顺序匹配，通用站点放在最后兜底
+var sourceResolvers = []sourceResolver{
+	{
+		Name: "youtube",
+		Match: func(u *url.URL) bool {
+			return hostIs(u.Hostname(), "youtube.com", "youtu.be", "youtube-nocookie.com")
+		},
+		Resolve:  resolveYoutubeSource,
+		Download: ytdlpDownloader([]string{"-f", "bestaudio", "--extract-audio", "--audio-format", "mp3", "--audio-quality", "192K"}, defaultVideoFormat),
+		Captions: true,
+	},
+	{
+		Name: "bilibili",
+		Match: func(u *url.URL) bool {
+			return hostIs(u.Hostname(), "bilibili.com", "b23.tv")
+		},
+		Resolve:  resolveBilibiliSource,
+		Download: ytdlpDownloader([]string{"-f", "bestaudio[ext=m4a]", "-x", "--audio-format", "mp3"}, defaultVideoFormat),
+		Captions: true,
+	},
+	{
+		Name: sourceResolverDirect,
+		Match: func(u *url.URL) bool {
+			return directMediaExts[strings.ToLower(path.Ext(u.Path))]
+		},
+		Resolve:  resolveUrlSource(sourceResolverDirect),
+		Download: downloadDirectMedia,
+	},
+	{
+		Name: sourceResolverStream,
+		Match: func(u *url.URL) bool {
+			return streamManifestExts[strings.ToLower(path.Ext(u.Path))]
+		},
+		Resolve:  resolveUrlSource(sourceResolverStream),
+		Download: downloadStreamMedia,
+	},
+	{
+		Name:    sourceResolverGeneric,
+		Match:   func(u *url.URL) bool { return true },
+		Resolve: resolveUrlSource(sourceResolverGeneric),
+		// 其它站点不一定提供mp4和m4a格式，退回到最佳格式后再合并为mp4
+		Download: ytdlpDownloader([]string{"-f", "bestaudio/best", "-x", "--audio-format", "mp3"}, defaultVideoFormat+"/bestvideo[height<=1080]+bestaudio/best[height<=1080]/best"),
+		Captions: true,
+	},
+}
+
+// 不做站点相关的处理，原样使用链接
+func resolveUrlSource(name string) func(u *url.URL) (*resolvedSource, error) {
+	return func(u *url.URL) (*resolvedSource, error) {
+		return &resolvedSource{Resolver: name, Url: u.String()}, nil
+	}
+}
+
+func resolveYoutubeSource(u *url.URL) (*resolvedSource, error) {
+	var videoId string
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch {
+	case hostIs(u.Hostname(), "youtu.be"):
+		videoId = segments[0]
+	case segments[0] == "watch":
+		videoId = u.Query().Get("v")
+	case len(segments) >= 2 && (segments[0] == "shorts" || segments[0] == "embed" || segments[0] == "live" || segments[0] == "v"):
+		videoId = segments[1]
+	}
+	if !youtubeIdRegex.MatchString(videoId) {
+		return nil, errors.New("无法识别的YouTube视频链接")
+	}
+	return &resolvedSource{
+		Resolver: "youtube",
+		Id:       videoId,
+		Url:      "https://www.youtube.com/watch?v=" + videoId,
+	}, nil
+}
+
+func resolveBilibiliSource(u *url.URL) (*resolvedSource, error) {
+	if hostIs(u.Hostname(), "b23.tv") {
+		target, err := followRedirect(u.String())
+		if err != nil {
+			return nil, fmt.Errorf("解析b站短链接失败：%w", err)
+		}
+		if u, err = url.Parse(target); err != nil || !hostIs(strings.ToLower(u.Hostname()), "bilibili.com") {
+			return nil, errors.New("无法识别的b站短链接")
+		}
+	}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) < 2 || segments[0] != "video" || !bilibiliIdRegex.MatchString(segments[1]) {
+		return nil, errors.New("无法识别的b站视频链接")
+	}
+	videoId := segments[1]
+	link := "https://www.bilibili.com/video/" + videoId
+	// 分P视频保留p参数，否则只会下载第一P
+	if p := u.Query().Get("p"); p != "" && p != "1" {
+		videoId += "?p=" + p
+		link += "?p=" + p
+	}
+	return &resolvedSource{
+		Resolver: "bilibili",
+		Id:       videoId,
+		Url:      link,
+	}, nil
+}
+
+// checkSourceDomain 按配置的黑白名单检查链接域名
+func checkSourceDomain(host string) error {
+	for _, domain := range config.Conf.Source.DenyDomains {
+		if hostIs(host, strings.ToLower(strings.TrimSpace(domain))) {
+			return fmt.Errorf("不允许的链接域名：%s", host)
+		}
+	}
+	if len(config.Conf.Source.AllowDomains) == 0 {
+		return nil
+	}
+	for _, domain := range config.Conf.Source.AllowDomains {
+		if hostIs(host, strings.ToLower(strings.TrimSpace(domain))) {
+			return nil
+		}
+	}
+	return fmt.Errorf("不允许的链接域名：%s", host)
+}
+
+// checkSourceAddress 拒绝解析到本机、内网和链路本地地址的链接，防止借助下载访问内部服务，allow_private_hosts中列出的除外
+func checkSourceAddress(ctx context.Context, host string) error {
+	for _, allowed := range config.Conf.Source.AllowPrivateHosts {
+		if hostIs(host, strings.ToLower(strings.TrimSpace(allowed))) {
+			return nil
+		}
+	}
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = append(ips, ip)
+	} else {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			// 解析失败时后续的下载同样会失败
+			return nil
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	for _, ip := range ips {
+		if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+			return fmt.Errorf("不允许访问内网地址：%s", host)
+		}
+	}
+	return nil
+}
+
+// checkRedirectSource 下载时的每次重定向都按原始链接的规则检查目标地址
+func checkRedirectSource(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("重定向次数过多")
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return errors.New("链接不合法")
+	}
+	host := strings.ToLower(req.URL.Hostname())
+	if err := checkSourceDomain(host); err != nil {
+		return err
+	}
+	return checkSourceAddress(req.Context(), host)
+}
+
+// parseSourceLink 检查链接格式和域名是否允许下载，并找到处理该链接的方式
+func parseSourceLink(link string) (*url.URL, *sourceResolver, error) {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return nil, nil, errors.New("链接不合法")
+	}
+	u.Host = strings.ToLower(u.Host)
+	if err = checkSourceDomain(u.Hostname()); err != nil {
+		return nil, nil, err
+	}
+	// allow_domains默认为空，通用站点会接受任意域名，交给yt-dlp或直接下载前先排除内网地址
+	if err = checkSourceAddress(context.Background(), u.Hostname()); err != nil {
+		return nil, nil, err
+	}
+	for i := range sourceResolvers {
+		if sourceResolvers[i].Match(u) {
+			return u, &sourceResolvers[i], nil
+		}
+	}
+	return nil, nil, errors.New("链接不合法")
+}
+
+// resolveSource 检查链接是否允许下载，并交给对应站点规范化
+func resolveSource(link string) (*resolvedSource, error) {
+	u, resolver, err := parseSourceLink(link)
+	if err != nil {
+		return nil, err
+	}
+	src, err := resolver.Resolve(u)
+	if err != nil {
+		return nil, err
+	}
+	src.resolver = resolver
+	return src, nil
+}
+
+// ytdlpSourceArgs 代理、cookies等与站点相关的yt-dlp通用参数
+func ytdlpSourceArgs(src *resolvedSource) []string {
+	args := make([]string, 0)
+	if config.Conf.App.Proxy != "" {
+		args = append(args, "--proxy", config.Conf.App.Proxy)
+	}
+	if cookies := config.Conf.Source.Cookies[src.Resolver]; cookies != "" {
+		args = append(args, "--cookies", cookies)
+	}
+	if storage.FfmpegPath != "ffmpeg" {
+		args = append(args, "--ffmpeg-location", storage.FfmpegPath)
+	}
+	return args
+}
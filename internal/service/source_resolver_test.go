@@ -0,0 +1,69 @@
+package service
+
+import (
+	"krillin-ai/config"
+	"testing"
+)
+
+func Test_resolveSource(t *testing.T) {
+	originFollowRedirect := followRedirect
+	followRedirect = func(link string) (string, error) {
+		return "https://www.bilibili.com/video/BV1GJ411x7h7?share_source=copy_web", nil
+	}
+	defer func() { followRedirect = originFollowRedirect }()
+
+	tests := []struct {
+		link     string
+		resolver string
+		url      string
+		identity string
+	}{
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=42s", "youtube", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", "youtube:dQw4w9WgXcQ"},
+		{"https://m.youtube.com/watch?v=dQw4w9WgXcQ", "youtube", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", "youtube:dQw4w9WgXcQ"},
+		{"https://youtu.be/dQw4w9WgXcQ?si=abc", "youtube", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", "youtube:dQw4w9WgXcQ"},
+		{"https://www.youtube.com/shorts/dQw4w9WgXcQ", "youtube", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", "youtube:dQw4w9WgXcQ"},
+		{"https://www.bilibili.com/video/BV1GJ411x7h7/?spm_id_from=333", "bilibili", "https://www.bilibili.com/video/BV1GJ411x7h7", "bilibili:BV1GJ411x7h7"},
+		{"https://m.bilibili.com/video/BV1GJ411x7h7?p=2", "bilibili", "https://www.bilibili.com/video/BV1GJ411x7h7?p=2", "bilibili:BV1GJ411x7h7?p=2"},
+		{"https://b23.tv/abcdEFG", "bilibili", "https://www.bilibili.com/video/BV1GJ411x7h7", "bilibili:BV1GJ411x7h7"},
+		{"https://cdn.example.com/media/intro.MP4?token=abc", "direct", "https://cdn.example.com/media/intro.MP4?token=abc", "url:https://cdn.example.com/media/intro.MP4?token=abc"},
+		{"https://cdn.example.com/live/index.m3u8", "stream", "https://cdn.example.com/live/index.m3u8", "url:https://cdn.example.com/live/index.m3u8"},
+		{"https://vimeo.com/76979871", "generic", "https://vimeo.com/76979871", "url:https://vimeo.com/76979871"},
+	}
+	for _, tt := range tests {
+		src, err := resolveSource(tt.link)
+		if err != nil {
+			t.Errorf("resolveSource(%q) err = %v", tt.link, err)
+			continue
+		}
+		if src.Resolver != tt.resolver || src.Url != tt.url || src.Identity() != tt.identity {
+			t.Errorf("resolveSource(%q) = %s %s %s, want %s %s %s", tt.link, src.Resolver, src.Url, src.Identity(), tt.resolver, tt.url, tt.identity)
+		}
+	}
+
+	for _, link := range []string{"ftp://example.com/a.mp4", "not a link", "https://www.youtube.com/channel/abc",
+		"http://127.0.0.1:8888/api/file/config.toml", "http://169.254.169.254/latest/meta-data", "http://localhost/video.mp4", "http://[::1]/index.m3u8"} {
+		if _, err := resolveSource(link); err == nil {
+			t.Errorf("resolveSource(%q) want err", link)
+		}
+	}
+}
+
+func Test_checkSourceDomain(t *testing.T) {
+	origin := config.Conf.Source
+	defer func() { config.Conf.Source = origin }()
+
+	config.Conf.Source.AllowDomains = []string{"youtube.com", "Vimeo.com"}
+	config.Conf.Source.DenyDomains = []string{"music.youtube.com"}
+	tests := map[string]bool{
+		"www.youtube.com":   true,
+		"vimeo.com":         true,
+		"music.youtube.com": false,
+		"notyoutube.com":    false,
+		"www.tiktok.com":    false,
+	}
+	for host, allowed := range tests {
+		if err := checkSourceDomain(host); (err == nil) != allowed {
+			t.Errorf("checkSourceDomain(%q) err = %v, want allowed %v", host, err, allowed)
+		}
+	}
+}
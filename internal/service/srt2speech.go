@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"go.uber.org/zap"
+	"krillin-ai/internal/dto"
+	"krillin-ai/internal/metrics"
 	"krillin-ai/internal/storage"
 	"krillin-ai/internal/types"
 	"krillin-ai/log"
@@ -53,9 +55,16 @@ func (s Service) srtFileToSpeech(ctx context.Context, stepParam *types.SubtitleT
 	}
 
 	for i, sub := range subtitles {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		outputFile := filepath.Join(stepParam.TaskBasePath, fmt.Sprintf("subtitle_%d.wav", i+1))
+		span := startTimelineSpan(stepParam.TaskId, types.TimelineKindTts, "srtFileToSpeech", i+1)
+		metrics.TtsRequests.Inc()
 		err = s.TtsClient.Text2Speech(sub.Text, voiceCode, outputFile)
+		span.finish(1, err)
 		if err != nil {
+			metrics.TtsErrors.Inc()
 			log.GetLogger().Error("srtFileToSpeech Text2Speech error", zap.Any("stepParam", stepParam), zap.Any("num", i+1), zap.Error(err))
 			return fmt.Errorf("srtFileToSpeech Text2Speech error: %w", err)
 		}
@@ -76,7 +85,7 @@ func (s Service) srtFileToSpeech(ctx context.Context, stepParam *types.SubtitleT
 			if startTime.Second() > 0 {
 				silenceDurationMs := startTime.Sub(time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC)).Milliseconds()
 				silenceFilePath := filepath.Join(stepParam.TaskBasePath, "silence_0.wav")
-				err := newGenerateSilence(silenceFilePath, float64(silenceDurationMs)/1000)
+				err := newGenerateSilence(ctx, silenceFilePath, float64(silenceDurationMs)/1000)
 				if err != nil {
 					log.GetLogger().Error("srtFileToSpeech newGenerateSilence error", zap.Any("stepParam", stepParam), zap.Error(err))
 					return fmt.Errorf("srtFileToSpeech newGenerateSilence error: %w", err)
@@ -104,13 +113,19 @@ func (s Service) srtFileToSpeech(ctx context.Context, stepParam *types.SubtitleT
 		}
 
 		adjustedFile := filepath.Join(stepParam.TaskBasePath, fmt.Sprintf("adjusted_%d.wav", i+1))
-		err = adjustAudioDuration(outputFile, adjustedFile, stepParam.TaskBasePath, duration)
+		err = adjustAudioDuration(ctx, outputFile, adjustedFile, stepParam.TaskBasePath, duration)
 		if err != nil {
 			log.GetLogger().Error("srtFileToSpeech adjustAudioDuration error", zap.Any("stepParam", stepParam), zap.Any("num", i+1), zap.Error(err))
 			return fmt.Errorf("srtFileToSpeech adjustAudioDuration error: %w", err)
 		}
 
 		audioFiles = append(audioFiles, adjustedFile)
+		publishTaskEvent(dto.SubtitleTaskEvent{
+			Type:    SubtitleTaskEventTtsProgress,
+			TaskId:  stepParam.TaskId,
+			Current: i + 1,
+			Total:   len(subtitles),
+		})
 
 		// 计算音频的实际时长
 		audioDuration, err := util.GetAudioDuration(adjustedFile)
@@ -128,14 +143,14 @@ func (s Service) srtFileToSpeech(ctx context.Context, stepParam *types.SubtitleT
 
 	// Step 6: 拼接所有音频文件
 	finalOutput := filepath.Join(stepParam.TaskBasePath, types.TtsResultAudioFileName)
-	err = concatenateAudioFiles(audioFiles, finalOutput, stepParam.TaskBasePath)
+	err = concatenateAudioFiles(ctx, audioFiles, finalOutput, stepParam.TaskBasePath)
 	if err != nil {
 		log.GetLogger().Error("srtFileToSpeech concatenateAudioFiles error", zap.Any("stepParam", stepParam), zap.Error(err))
 		return fmt.Errorf("srtFileToSpeech concatenateAudioFiles error: %w", err)
 	}
 	stepParam.TtsResultFilePath = finalOutput
 	// 更新字幕任务信息
-	storage.SubtitleTasks[stepParam.TaskId].ProcessPct = 98
+	updateTaskProcessPct(stepParam.TaskId, 98)
 	log.GetLogger().Info("srtFileToSpeech success", zap.String("task id", stepParam.TaskId))
 	return nil
 }
@@ -161,13 +176,14 @@ func parseSRT(filePath string) ([]types.SrtSentenceWithStrTime, error) {
 	return subtitles, nil
 }
 
-func newGenerateSilence(outputAudio string, duration float64) error {
+func newGenerateSilence(ctx context.Context, outputAudio string, duration float64) error {
 	// 生成 PCM 格式的静音文件
-	cmd := exec.Command(storage.FfmpegPath, "-y", "-f", "lavfi", "-i", "anullsrc=channel_layout=mono:sample_rate=44100", "-t",
+	cmd := exec.CommandContext(ctx, storage.FfmpegPath, "-y", "-f", "lavfi", "-i", "anullsrc=channel_layout=mono:sample_rate=44100", "-t",
 		fmt.Sprintf("%.3f", duration), "-ar", "44100", "-ac", "1", "-c:a", "pcm_s16le", outputAudio)
 	cmd.Stderr = os.Stderr
 	err := cmd.Run()
 	if err != nil {
+		recordProcessFailure(ctx, cmd)
 		return fmt.Errorf("newGenerateSilence failed to generate PCM silence: %w", err)
 	}
 
@@ -175,7 +191,7 @@ func newGenerateSilence(outputAudio string, duration float64) error {
 }
 
 // 调整音频时长，确保音频与字幕时长一致
-func adjustAudioDuration(inputFile, outputFile, taskBasePath string, subtitleDuration float64) error {
+func adjustAudioDuration(ctx context.Context, inputFile, outputFile, taskBasePath string, subtitleDuration float64) error {
 	// 获取音频时长
 	audioDuration, err := util.GetAudioDuration(inputFile)
 	if err != nil {
@@ -189,7 +205,7 @@ func adjustAudioDuration(inputFile, outputFile, taskBasePath string, subtitleDur
 
 		// 生成静音音频
 		silenceFile := filepath.Join(taskBasePath, "silence.wav")
-		err := newGenerateSilence(silenceFile, silenceDuration)
+		err := newGenerateSilence(ctx, silenceFile, silenceDuration)
 		if err != nil {
 			return fmt.Errorf("error generating silence: %v", err)
 		}
@@ -211,11 +227,12 @@ func adjustAudioDuration(inputFile, outputFile, taskBasePath string, subtitleDur
 		}
 		f.Close()
 
-		cmd := exec.Command(storage.FfmpegPath, "-y", "-f", "concat", "-safe", "0", "-i", concatFile, "-c", "copy", outputFile)
+		cmd := exec.CommandContext(ctx, storage.FfmpegPath, "-y", "-f", "concat", "-safe", "0", "-i", concatFile, "-c", "copy", outputFile)
 		log.GetLogger().Info("adjustAudioDuration", zap.Any("inputFile", inputFile), zap.Any("outputFile", outputFile), zap.String("run command", cmd.String()))
 		cmd.Stderr = os.Stderr
 		err = cmd.Run()
 		if err != nil {
+			recordProcessFailure(ctx, cmd)
 			return fmt.Errorf("adjustAudioDuration concat audio and silence  error: %v", err)
 		}
 
@@ -234,9 +251,13 @@ func adjustAudioDuration(inputFile, outputFile, taskBasePath string, subtitleDur
 		//}
 
 		// 使用 atempo 滤镜调整音频播放速率
-		cmd := exec.Command(storage.FfmpegPath, "-y", "-i", inputFile, "-filter:a", fmt.Sprintf("atempo=%.2f", speed), outputFile)
+		cmd := exec.CommandContext(ctx, storage.FfmpegPath, "-y", "-i", inputFile, "-filter:a", fmt.Sprintf("atempo=%.2f", speed), outputFile)
 		cmd.Stderr = os.Stderr
-		return cmd.Run()
+		if err = cmd.Run(); err != nil {
+			recordProcessFailure(ctx, cmd)
+			return err
+		}
+		return nil
 	}
 
 	// 如果音频时长和字幕时长相同，则直接复制文件
@@ -244,7 +265,7 @@ func adjustAudioDuration(inputFile, outputFile, taskBasePath string, subtitleDur
 }
 
 // 拼接音频文件
-func concatenateAudioFiles(audioFiles []string, outputFile, taskBasePath string) error {
+func concatenateAudioFiles(ctx context.Context, audioFiles []string, outputFile, taskBasePath string) error {
 	// 创建一个临时文件保存音频文件列表
 	listFile := filepath.Join(taskBasePath, "audio_list.txt")
 	f, err := os.Create(listFile)
@@ -261,7 +282,11 @@ func concatenateAudioFiles(audioFiles []string, outputFile, taskBasePath string)
 	}
 	f.Close()
 
-	cmd := exec.Command(storage.FfmpegPath, "-y", "-f", "concat", "-safe", "0", "-i", listFile, "-c", "copy", outputFile)
+	cmd := exec.CommandContext(ctx, storage.FfmpegPath, "-y", "-f", "concat", "-safe", "0", "-i", listFile, "-c", "copy", outputFile)
 	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	if err := cmd.Run(); err != nil {
+		recordProcessFailure(ctx, cmd)
+		return err
+	}
+	return nil
 }
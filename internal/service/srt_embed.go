@@ -24,7 +24,7 @@ func (s Service) embedSubtitles(ctx context.Context, stepParam *types.SubtitleTa
 	var err error
 	if stepParam.EmbedSubtitleVideoType == "horizontal" || stepParam.EmbedSubtitleVideoType == "vertical" || stepParam.EmbedSubtitleVideoType == "all" {
 		var width, height int
-		width, height, err = getResolution(stepParam.InputVideoPath)
+		width, height, err = getResolution(ctx, stepParam.InputVideoPath)
 		// 横屏可以合成竖屏的，但竖屏暂时不支持合成横屏的
 		if stepParam.EmbedSubtitleVideoType == "horizontal" || stepParam.EmbedSubtitleVideoType == "all" {
 			if width < height {
@@ -32,7 +32,7 @@ func (s Service) embedSubtitles(ctx context.Context, stepParam *types.SubtitleTa
 				return nil
 			}
 			log.GetLogger().Info("合成字幕嵌入视频：横屏")
-			err = embedSubtitles(stepParam, true)
+			err = embedSubtitles(ctx, stepParam, true)
 			if err != nil {
 				log.GetLogger().Error("embedSubtitles embedSubtitles error", zap.Any("step param", stepParam), zap.Error(err))
 				return fmt.Errorf("embedSubtitles embedSubtitles error: %w", err)
@@ -42,7 +42,7 @@ func (s Service) embedSubtitles(ctx context.Context, stepParam *types.SubtitleTa
 			if width > height {
 				// 生成竖屏视频
 				transferredVerticalVideoPath := filepath.Join(stepParam.TaskBasePath, types.SubtitleTaskTransferredVerticalVideoFileName)
-				err = convertToVertical(stepParam.InputVideoPath, transferredVerticalVideoPath, stepParam.VerticalVideoMajorTitle, stepParam.VerticalVideoMinorTitle)
+				err = convertToVertical(ctx, stepParam.TaskId, stepParam.InputVideoPath, transferredVerticalVideoPath, stepParam.VerticalVideoMajorTitle, stepParam.VerticalVideoMinorTitle)
 				if err != nil {
 					log.GetLogger().Error("embedSubtitles convertToVertical error", zap.Any("step param", stepParam), zap.Error(err))
 					return fmt.Errorf("embedSubtitles convertToVertical error: %w", err)
@@ -50,7 +50,7 @@ func (s Service) embedSubtitles(ctx context.Context, stepParam *types.SubtitleTa
 				stepParam.InputVideoPath = transferredVerticalVideoPath
 			}
 			log.GetLogger().Info("合成字幕嵌入视频：竖屏")
-			err = embedSubtitles(stepParam, false)
+			err = embedSubtitles(ctx, stepParam, false)
 			if err != nil {
 				log.GetLogger().Error("embedSubtitles embedSubtitles error", zap.Any("step param", stepParam), zap.Error(err))
 				return fmt.Errorf("embedSubtitles embedSubtitles error: %w", err)
@@ -165,7 +165,7 @@ func formatTimestamp(t time.Duration) string {
 	return fmt.Sprintf("%02d:%02d:%02d.%02d", hours, minutes, seconds, milliseconds)
 }
 
-func srtToAss(inputSRT, outputASS string, isHorizontal bool, stepParam *types.SubtitleTaskStepParam) error {
+func srtToAss(inputSRT, outputASS string, isHorizontal bool, stepParam *types.SubtitleTaskStepParam, chapters []types.VideoChapter) error {
 	file, err := os.Open(inputSRT)
 	if err != nil {
 		log.GetLogger().Error("srtToAss Open input srt error", zap.Error(err))
@@ -301,23 +301,36 @@ func srtToAss(inputSRT, outputASS string, isHorizontal bool, stepParam *types.Su
 			}
 		}
 	}
+	// 章节开始时在顶部显示章节标题
+	_, _ = assFile.WriteString(buildChaptersAssEvents(chapters))
 	return nil
 }
 
-func embedSubtitles(stepParam *types.SubtitleTaskStepParam, isHorizontal bool) error {
+func embedSubtitles(ctx context.Context, stepParam *types.SubtitleTaskStepParam, isHorizontal bool) error {
 	outputFileName := types.SubtitleTaskVerticalEmbedVideoFileName
 	if isHorizontal {
 		outputFileName = types.SubtitleTaskHorizontalEmbedVideoFileName
 	}
 	assPath := filepath.Join(stepParam.TaskBasePath, "formatted_subtitles.ass")
+	chapters := loadTaskChapters(stepParam.TaskId)
 
-	if err := srtToAss(stepParam.BilingualSrtFilePath, assPath, isHorizontal, stepParam); err != nil {
+	if err := srtToAss(stepParam.BilingualSrtFilePath, assPath, isHorizontal, stepParam, chapters); err != nil {
 		log.GetLogger().Error("embedSubtitles srtToAss error", zap.Any("step param", stepParam), zap.Error(err))
 		return fmt.Errorf("embedSubtitles srtToAss error: %w", err)
 	}
 
-	cmd := exec.Command(storage.FfmpegPath, "-y", "-i", stepParam.InputVideoPath, "-vf", fmt.Sprintf("ass=%s", strings.ReplaceAll(assPath, "\\", "/")), "-c:a", "aac", "-b:a", "192k", filepath.Join(stepParam.TaskBasePath, fmt.Sprintf("/output/%s", outputFileName)))
-	output, err := cmd.CombinedOutput()
+	cmdArgs := []string{"-y", "-i", stepParam.InputVideoPath}
+	if len(chapters) > 0 {
+		// 章节来自单独的元数据文件，音视频流仍然只来自原视频
+		metadataPath := filepath.Join(stepParam.TaskBasePath, types.SubtitleTaskChaptersFfmetadataFileName)
+		if err := writeChaptersFfmetadata(chapters, metadataPath); err != nil {
+			log.GetLogger().Error("embedSubtitles writeChaptersFfmetadata error", zap.Any("step param", stepParam), zap.Error(err))
+			return fmt.Errorf("embedSubtitles writeChaptersFfmetadata error: %w", err)
+		}
+		cmdArgs = append(cmdArgs, "-f", "ffmetadata", "-i", metadataPath, "-map_chapters", "1")
+	}
+	cmdArgs = append(cmdArgs, "-vf", fmt.Sprintf("ass=%s", strings.ReplaceAll(assPath, "\\", "/")), "-c:a", "aac", "-b:a", "192k", filepath.Join(stepParam.TaskBasePath, fmt.Sprintf("/output/%s", outputFileName)))
+	output, err := runFfmpegWithProgress(ctx, stepParam.TaskId, outputFileName, stepParam.InputVideoPath, cmdArgs...)
 	if err != nil {
 		log.GetLogger().Error("embedSubtitles embed subtitle into video ffmpeg error", zap.String("video path", stepParam.InputVideoPath), zap.String("output", string(output)), zap.Error(err))
 		return fmt.Errorf("embedSubtitles embed subtitle into video ffmpeg error: %w", err)
@@ -338,7 +351,7 @@ func getFontPaths() (string, string, error) {
 	}
 }
 
-func getResolution(inputVideo string) (int, int, error) {
+func getResolution(ctx context.Context, inputVideo string) (int, int, error) {
 	// 获取视频信息
 	cmdArgs := []string{
 		"-v", "error",
@@ -347,12 +360,13 @@ func getResolution(inputVideo string) (int, int, error) {
 		"-of", "csv=s=x:p=0",
 		inputVideo,
 	}
-	cmd := exec.Command(storage.FfprobePath, cmdArgs...)
+	cmd := exec.CommandContext(ctx, storage.FfprobePath, cmdArgs...)
 	var out bytes.Buffer
 	cmd.Stdout = &out
 	cmd.Stderr = &out
 
 	if err := cmd.Run(); err != nil {
+		recordProcessFailure(ctx, cmd)
 		log.GetLogger().Error("获取视频分辨率失败", zap.String("output", out.String()), zap.Error(err))
 		return 0, 0, err
 	}
@@ -368,7 +382,7 @@ func getResolution(inputVideo string) (int, int, error) {
 	return width, height, nil
 }
 
-func convertToVertical(inputVideo, outputVideo, majorTitle, minorTitle string) error {
+func convertToVertical(ctx context.Context, taskId, inputVideo, outputVideo, majorTitle, minorTitle string) error {
 	if _, err := os.Stat(outputVideo); err == nil {
 		log.GetLogger().Info("竖屏视频已存在", zap.String("outputVideo", outputVideo))
 		return nil
@@ -393,9 +407,8 @@ func convertToVertical(inputVideo, outputVideo, majorTitle, minorTitle string) e
 		"-y",
 		outputVideo,
 	}
-	cmd := exec.Command(storage.FfmpegPath, cmdArgs...)
 	var output []byte
-	output, err = cmd.CombinedOutput()
+	output, err = runFfmpegWithProgress(ctx, taskId, filepath.Base(outputVideo), inputVideo, cmdArgs...)
 	if err != nil {
 		log.GetLogger().Error("视频转竖屏失败", zap.String("output", string(output)), zap.Error(err))
 		return err
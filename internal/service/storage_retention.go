@@ -0,0 +1,314 @@
+package service
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"io/fs"
+	"krillin-ai/config"
+	"krillin-ai/internal/dto"
+	"krillin-ai/internal/storage"
+	"krillin-ai/internal/types"
+	"krillin-ai/log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	taskWorkspaceRoot = "./tasks"
+	uploadRoot        = "./uploads"
+)
+
+// 后台清理和手动清理不并发执行
+var storageCleanupMu sync.Mutex
+
+type diskEntry struct {
+	id       string // 任务id或上传文件名
+	path     string
+	size     int64
+	lastUsed time.Time
+	active   bool // 排队或运行中的任务及其引用的上传文件，不参与清理
+}
+
+// StartStorageJanitor 按配置的间隔在后台清理过期的任务目录和上传文件
+func StartStorageJanitor() {
+	interval := time.Duration(config.Conf.Retention.CleanupIntervalMinutes) * time.Minute
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			res, err := cleanupStorage()
+			if err != nil {
+				log.GetLogger().Error("StartStorageJanitor cleanupStorage err", zap.Error(err))
+				continue
+			}
+			if len(res.RemovedTasks) > 0 || len(res.RemovedUploads) > 0 {
+				log.GetLogger().Info("后台清理完成", zap.Strings("removed tasks", res.RemovedTasks),
+					zap.Strings("removed uploads", res.RemovedUploads), zap.Int64("freed bytes", res.FreedBytes))
+			}
+		}
+	}()
+	log.GetLogger().Info("后台清理已启动", zap.Duration("interval", interval))
+}
+
+func (s Service) GetStorageUsage() (*dto.StorageUsageResData, error) {
+	tasks, uploads, err := scanDiskEntries()
+	if err != nil {
+		log.GetLogger().Error("GetStorageUsage scanDiskEntries err", zap.Error(err))
+		return nil, errors.New("统计磁盘占用失败")
+	}
+	res := &dto.StorageUsageResData{
+		TaskCount:            len(tasks),
+		TaskBytes:            sumDiskEntrySize(tasks),
+		UploadCount:          len(uploads),
+		UploadBytes:          sumDiskEntrySize(uploads),
+		MaxDiskBytes:         int64(config.Conf.Retention.MaxDiskMb) << 20,
+		TaskTtlHours:         config.Conf.Retention.TaskTtlHours,
+		UploadTtlHours:       config.Conf.Retention.UploadTtlHours,
+		KeepOnlyFinalOutputs: config.Conf.Retention.KeepOnlyFinalOutputs,
+	}
+	res.TotalBytes = res.TaskBytes + res.UploadBytes
+	return res, nil
+}
+
+func (s Service) CleanupStorage() (*dto.StorageCleanupResData, error) {
+	res, err := cleanupStorage()
+	if err != nil {
+		log.GetLogger().Error("CleanupStorage cleanupStorage err", zap.Error(err))
+		return nil, errors.New("清理失败")
+	}
+	return res, nil
+}
+
+// cleanupStorage 先按保留时长清理，再在超出磁盘上限时按最久未使用的顺序清理任务目录
+func cleanupStorage() (*dto.StorageCleanupResData, error) {
+	storageCleanupMu.Lock()
+	defer storageCleanupMu.Unlock()
+
+	tasks, uploads, err := scanDiskEntries()
+	if err != nil {
+		return nil, err
+	}
+	res := &dto.StorageCleanupResData{
+		RemovedTasks:   make([]string, 0),
+		RemovedUploads: make([]string, 0),
+	}
+	total := sumDiskEntrySize(tasks) + sumDiskEntrySize(uploads)
+	now := time.Now()
+
+	remove := func(entry *diskEntry, isTask bool) {
+		if err := os.RemoveAll(entry.path); err != nil {
+			log.GetLogger().Error("cleanupStorage remove err", zap.String("path", entry.path), zap.Error(err))
+			return
+		}
+		entry.active = true // 标记为已处理，避免重复删除
+		total -= entry.size
+		res.FreedBytes += entry.size
+		if isTask {
+			clearCleanedTaskOutputs(entry.id)
+			res.RemovedTasks = append(res.RemovedTasks, entry.id)
+		} else {
+			forgetFileSha256(entry.path)
+			res.RemovedUploads = append(res.RemovedUploads, entry.id)
+		}
+	}
+
+	if ttl := config.Conf.Retention.TaskTtlHours; ttl > 0 {
+		for _, entry := range tasks {
+			if !entry.active && now.Sub(entry.lastUsed) > time.Duration(ttl)*time.Hour {
+				remove(entry, true)
+			}
+		}
+	}
+	uploadTtl := time.Duration(config.Conf.Retention.UploadTtlHours) * time.Hour
+	for _, entry := range uploads {
+		if entry.active {
+			continue
+		}
+		if uploadId, ok := strings.CutSuffix(entry.id, uploadingDirSuffix); ok {
+			// 分片上传的目录，长时间没有新分片的放弃上传
+			if now.Sub(entry.lastUsed) > chunkedUploadTtl || (uploadTtl > 0 && now.Sub(entry.lastUsed) > uploadTtl) {
+				remove(entry, false)
+				releaseUploadLock(uploadId)
+			}
+			continue
+		}
+		if uploadTtl > 0 && now.Sub(entry.lastUsed) > uploadTtl {
+			remove(entry, false)
+		}
+	}
+
+	if maxBytes := int64(config.Conf.Retention.MaxDiskMb) << 20; maxBytes > 0 && total > maxBytes {
+		// 上传文件和任务目录一起按最久未使用排序淘汰
+		candidates := make([]*diskEntry, 0, len(tasks)+len(uploads))
+		candidates = append(candidates, tasks...)
+		candidates = append(candidates, uploads...)
+		sort.Slice(candidates, func(i, j int) bool {
+			return candidates[i].lastUsed.Before(candidates[j].lastUsed)
+		})
+		taskSet := make(map[*diskEntry]bool, len(tasks))
+		for _, entry := range tasks {
+			taskSet[entry] = true
+		}
+		for _, entry := range candidates {
+			if total <= maxBytes {
+				break
+			}
+			if entry.active {
+				continue
+			}
+			remove(entry, taskSet[entry])
+		}
+		if total > maxBytes {
+			log.GetLogger().Info("cleanupStorage 运行中的任务占用超出磁盘上限", zap.Int64("total bytes", total), zap.Int64("max bytes", maxBytes))
+		}
+	}
+	res.TotalBytes = total
+	return res, nil
+}
+
+// clearCleanedTaskOutputs 任务目录删除后保留任务记录，清空指向目录内文件的下载地址和缓存键，避免返回失效的链接或被当作缓存命中
+func clearCleanedTaskOutputs(taskId string) {
+	err := storage.SubtitleTaskRepo.Update(taskId, func(task *types.SubtitleTask) {
+		task.SubtitleInfos = nil
+		task.SpeechDownloadUrl = ""
+		task.ArchiveDownloadUrl = ""
+		if strings.HasPrefix(task.Cover, "/api/file/") {
+			task.Cover = ""
+		}
+		task.CacheKey = ""
+	})
+	if err != nil && !errors.Is(err, storage.ErrSubtitleTaskNotFound) {
+		log.GetLogger().Error("clearCleanedTaskOutputs update task err", zap.String("taskId", taskId), zap.Error(err))
+	}
+}
+
+// 扫描任务目录和上传目录，任务的最后使用时间以任务更新时间为准，找不到任务记录时使用目录修改时间
+func scanDiskEntries() (tasks []*diskEntry, uploads []*diskEntry, err error) {
+	activeUploads := make(map[string]bool)
+	activeTasks := make(map[string]bool)
+	updateTimes := make(map[string]int64)
+	allTasks, _, err := storage.SubtitleTaskRepo.List(storage.SubtitleTaskQuery{})
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, task := range allTasks {
+		updateTimes[task.TaskId] = task.UpdateTime
+		if task.Status == types.SubtitleTaskStatusProcessing || task.Status == types.SubtitleTaskStatusQueued {
+			activeTasks[task.TaskId] = true
+			// 旧版本的任务记录没有LocalInputs，只有视频来源
+			for _, link := range append([]string{task.VideoSrc}, task.LocalInputs...) {
+				if strings.HasPrefix(link, "local:") {
+					activeUploads[filepath.Clean(strings.TrimPrefix(link, "local:"))] = true
+				}
+			}
+		}
+	}
+
+	taskDirs, err := os.ReadDir(taskWorkspaceRoot)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	for _, dir := range taskDirs {
+		if !dir.IsDir() {
+			continue
+		}
+		entry, err := newDiskEntry(dir.Name(), filepath.Join(taskWorkspaceRoot, dir.Name()))
+		if err != nil {
+			log.GetLogger().Error("scanDiskEntries stat task dir err", zap.String("taskId", dir.Name()), zap.Error(err))
+			continue
+		}
+		if updateTime := updateTimes[dir.Name()]; updateTime > 0 {
+			entry.lastUsed = time.Unix(updateTime, 0)
+		}
+		entry.active = activeTasks[dir.Name()]
+		tasks = append(tasks, entry)
+	}
+
+	uploadFiles, err := os.ReadDir(uploadRoot)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	for _, file := range uploadFiles {
+		path := filepath.Join(uploadRoot, file.Name())
+		entry, err := newDiskEntry(file.Name(), path)
+		if err != nil {
+			log.GetLogger().Error("scanDiskEntries stat upload err", zap.String("file", file.Name()), zap.Error(err))
+			continue
+		}
+		entry.active = activeUploads[path]
+		uploads = append(uploads, entry)
+	}
+	return tasks, uploads, nil
+}
+
+func newDiskEntry(id, path string) (*diskEntry, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	entry := &diskEntry{
+		id:       id,
+		path:     path,
+		lastUsed: info.ModTime(),
+	}
+	err = filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			fileInfo, err := d.Info()
+			if err != nil {
+				return err
+			}
+			entry.size += fileInfo.Size()
+		}
+		return nil
+	})
+	return entry, err
+}
+
+func sumDiskEntrySize(entries []*diskEntry) int64 {
+	var total int64
+	for _, entry := range entries {
+		total += entry.size
+	}
+	return total
+}
+
+// pruneTaskIntermediates 任务成功后删除中间文件，只保留output目录和下载链接指向的文件
+func pruneTaskIntermediates(taskId, taskBasePath string) {
+	task, err := storage.SubtitleTaskRepo.Get(taskId)
+	if err != nil {
+		log.GetLogger().Error("pruneTaskIntermediates get task err", zap.String("taskId", taskId), zap.Error(err))
+		return
+	}
+	keep := map[string]bool{
+		filepath.Clean(filepath.Join(taskBasePath, "output")): true,
+	}
+	for _, info := range task.SubtitleInfos {
+		keep[filepath.Clean(strings.TrimPrefix(info.DownloadUrl, "/api/file/"))] = true
+	}
+	if task.SpeechDownloadUrl != "" {
+		keep[filepath.Clean(strings.TrimPrefix(task.SpeechDownloadUrl, "/api/file/"))] = true
+	}
+
+	entries, err := os.ReadDir(taskBasePath)
+	if err != nil {
+		log.GetLogger().Error("pruneTaskIntermediates read dir err", zap.String("taskId", taskId), zap.Error(err))
+		return
+	}
+	for _, entry := range entries {
+		path := filepath.Clean(filepath.Join(taskBasePath, entry.Name()))
+		if keep[path] {
+			continue
+		}
+		if err = os.RemoveAll(path); err != nil {
+			log.GetLogger().Error("pruneTaskIntermediates remove err", zap.String("path", path), zap.Error(err))
+		}
+	}
+	log.GetLogger().Info("已清理任务中间文件", zap.String("taskId", taskId))
+}
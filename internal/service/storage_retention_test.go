@@ -0,0 +1,115 @@
+package service
+
+import (
+	"go.uber.org/zap"
+	"krillin-ai/config"
+	"krillin-ai/internal/dto"
+	"krillin-ai/internal/storage"
+	"krillin-ai/internal/types"
+	"krillin-ai/log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_cleanupStorageClearsTaskOutputs(t *testing.T) {
+	log.Logger = zap.NewNop()
+	chdirTemp(t)
+	useMemoryTaskRepo(t)
+	originRetention := config.Conf.Retention
+	config.Conf.Retention.TaskTtlHours = 0
+	config.Conf.Retention.UploadTtlHours = 0
+	config.Conf.Retention.MaxDiskMb = 1
+	defer func() { config.Conf.Retention = originRetention }()
+
+	outputDir := filepath.Join(taskWorkspaceRoot, "task1", "output")
+	if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	srtPath := filepath.Join(outputDir, "bilingual.srt")
+	if err := os.WriteFile(srtPath, make([]byte, 2<<20), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := storage.SubtitleTaskRepo.Create(&types.SubtitleTask{
+		TaskId:            "task1",
+		Status:            types.SubtitleTaskStatusSuccess,
+		SubtitleInfos:     []types.SubtitleInfo{{Name: "双语字幕", DownloadUrl: "/api/file/" + filepath.ToSlash(srtPath)}},
+		SpeechDownloadUrl: "/api/file/" + filepath.ToSlash(filepath.Join(outputDir, "speech.wav")),
+		Cover:             "/api/file/" + filepath.ToSlash(filepath.Join(outputDir, "cover.jpg")),
+		CacheKey:          "key1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := cleanupStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.RemovedTasks) != 1 || res.RemovedTasks[0] != "task1" {
+		t.Fatalf("cleanupStorage() removed %v, want [task1]", res.RemovedTasks)
+	}
+	task, err := storage.SubtitleTaskRepo.Get("task1")
+	if err != nil {
+		t.Fatalf("task record removed: %v", err)
+	}
+	if len(task.SubtitleInfos) != 0 || task.SpeechDownloadUrl != "" || task.Cover != "" || task.CacheKey != "" {
+		t.Errorf("task outputs not cleared: %+v", task)
+	}
+	if cached := findCachedSubtitleTask("key1"); cached != nil {
+		t.Errorf("findCachedSubtitleTask() = %s, want nil", cached.TaskId)
+	}
+}
+
+func Test_cleanupStorageKeepsActiveTaskInputs(t *testing.T) {
+	log.Logger = zap.NewNop()
+	chdirTemp(t)
+	useMemoryTaskRepo(t)
+	originRetention := config.Conf.Retention
+	config.Conf.Retention.TaskTtlHours = 0
+	config.Conf.Retention.UploadTtlHours = 1
+	config.Conf.Retention.MaxDiskMb = 0
+	defer func() { config.Conf.Retention = originRetention }()
+
+	if err := os.MkdirAll(uploadRoot, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	stale := time.Now().Add(-2 * time.Hour)
+	for _, name := range []string{"video.mp4", "subtitle.srt", "voice.wav", "unused.mp4"} {
+		path := filepath.Join(uploadRoot, name)
+		if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, stale, stale); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := dto.StartVideoSubtitleTaskReq{
+		Url:                     "local:./uploads/video.mp4",
+		SubtitleUrl:             "local:./uploads/subtitle.srt",
+		TtsVoiceCloneSrcFileUrl: "local:./uploads/voice.wav",
+	}
+	err := storage.SubtitleTaskRepo.Create(&types.SubtitleTask{
+		TaskId:      "task1",
+		Status:      types.SubtitleTaskStatusQueued,
+		VideoSrc:    req.Url,
+		LocalInputs: subtitleTaskLocalInputs(req),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := cleanupStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.RemovedUploads) != 1 || res.RemovedUploads[0] != "unused.mp4" {
+		t.Errorf("cleanupStorage() removed %v, want [unused.mp4]", res.RemovedUploads)
+	}
+	for _, name := range []string{"video.mp4", "subtitle.srt", "voice.wav"} {
+		if _, err = os.Stat(filepath.Join(uploadRoot, name)); err != nil {
+			t.Errorf("input %s of queued task removed: %v", name, err)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/samber/lo"
 	"go.uber.org/zap"
+	"krillin-ai/config"
 	"krillin-ai/internal/dto"
 	"krillin-ai/internal/storage"
 	"krillin-ai/internal/types"
@@ -15,22 +16,52 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 )
 
 func (s Service) StartSubtitleTask(req dto.StartVideoSubtitleTaskReq) (*dto.StartVideoSubtitleTaskResData, error) {
-	// 校验链接
-	if strings.Contains(req.Url, "youtube.com") {
-		videoId, _ := util.GetYouTubeID(req.Url)
-		if videoId == "" {
-			return nil, fmt.Errorf("链接不合法")
+	// 校验链接，并规范化为同一视频的标准链接
+	if req.Url == "" && req.SubtitleUrl == "" {
+		return nil, errors.New("链接不合法")
+	}
+	if strings.HasPrefix(req.Url, "local:") {
+		if _, err := resolveUploadedFile(req.Url); err != nil {
+			return nil, err
+		}
+	} else if req.Url != "" {
+		source, err := resolveSource(req.Url)
+		if err != nil {
+			return nil, err
 		}
+		req.Url = source.Url
 	}
-	if strings.Contains(req.Url, "bilibili.com") {
-		videoId := util.GetBilibiliVideoId(req.Url)
-		if videoId == "" {
-			return nil, fmt.Errorf("链接不合法")
+	if req.TtsVoiceCloneSrcFileUrl != "" {
+		if _, err := resolveUploadedFile(req.TtsVoiceCloneSrcFileUrl); err != nil {
+			return nil, err
 		}
 	}
+	var subtitleSourcePath string
+	if req.SubtitleUrl != "" {
+		var err error
+		if subtitleSourcePath, err = checkSubtitleSource(req.SubtitleUrl); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkCaptionPolicy(req.CaptionPolicy); err != nil {
+		return nil, err
+	}
+	if req.CaptionPolicy == "" {
+		req.CaptionPolicy = config.Conf.App.CaptionPolicy
+	}
+	if req.CallbackUrl != "" {
+		if err := validateCallbackUrl(req.CallbackUrl); err != nil {
+			return nil, err
+		}
+	}
+	steps, err := s.buildSubtitleTaskSteps(req.Steps, subtitleTaskInitialArtifacts(req.Url, subtitleSourcePath))
+	if err != nil {
+		return nil, err
+	}
 	// 生成任务id
 	taskId := util.GenerateRandStringWithUpperLowerNum(8)
 	// 构造任务所需参数
@@ -61,8 +92,6 @@ func (s Service) StartSubtitleTask(req dto.StartVideoSubtitleTaskReq) (*dto.Star
 			}
 		}
 	}
-	var err error
-	ctx := context.Background()
 	// 创建字幕任务文件夹
 	taskBasePath := filepath.Join("./tasks", taskId)
 	if _, err = os.Stat(taskBasePath); os.IsNotExist(err) {
@@ -73,11 +102,36 @@ func (s Service) StartSubtitleTask(req dto.StartVideoSubtitleTaskReq) (*dto.Star
 		}
 	}
 
+	stepNames := lo.Map(steps, func(step subtitleTaskStep, _ int) string {
+		return step.Name
+	})
+	cacheKey, err := subtitleTaskCacheKey(req, stepNames)
+	if err != nil {
+		// 缓存键计算失败只是无法复用结果，不影响任务
+		log.GetLogger().Error("StartVideoSubtitleTask subtitleTaskCacheKey err", zap.Any("req", req), zap.Error(err))
+	}
+	if cacheKey != "" && !req.ForceRerun {
+		if cached := findCachedSubtitleTask(cacheKey); cached != nil {
+			return s.startSubtitleTaskFromCache(req, cached, taskId, taskBasePath)
+		}
+	}
+
 	// 创建任务
-	storage.SubtitleTasks[taskId] = &types.SubtitleTask{
-		TaskId:   taskId,
-		VideoSrc: req.Url,
-		Status:   types.SubtitleTaskStatusProcessing,
+	err = storage.SubtitleTaskRepo.Create(&types.SubtitleTask{
+		TaskId:         taskId,
+		VideoSrc:       req.Url,
+		LocalInputs:    subtitleTaskLocalInputs(req),
+		OriginLanguage: req.OriginLanguage,
+		TargetLanguage: req.TargetLang,
+		Status:         types.SubtitleTaskStatusQueued,
+		CallbackUrl:    req.CallbackUrl,
+		CallbackSecret: req.CallbackSecret,
+		CacheKey:       cacheKey,
+		ParentTaskId:   req.ParentTaskId,
+	})
+	if err != nil {
+		log.GetLogger().Error("StartVideoSubtitleTask create task err", zap.Any("req", req), zap.Error(err))
+		return nil, errors.New("创建任务失败")
 	}
 	var ttsVoiceCode string
 	if req.TtsVoiceCode == types.SubtitleTaskTtsVoiceCodeLongyu {
@@ -89,7 +143,7 @@ func (s Service) StartSubtitleTask(req dto.StartVideoSubtitleTaskReq) (*dto.Star
 	// 处理声音克隆源
 	var voiceCloneAudioUrl string
 	if req.TtsVoiceCloneSrcFileUrl != "" {
-		localFileUrl := strings.TrimPrefix(req.TtsVoiceCloneSrcFileUrl, "local:")
+		localFileUrl := filepath.Clean(strings.TrimPrefix(req.TtsVoiceCloneSrcFileUrl, "local:"))
 		fileKey := util.GenerateRandStringWithUpperLowerNum(5) + filepath.Ext(localFileUrl) // 防止url encode的问题，这里统一处理
 		err = s.OssClient.UploadFile(context.Background(), fileKey, localFileUrl, s.OssClient.Bucket)
 		if err != nil {
@@ -117,90 +171,178 @@ func (s Service) StartSubtitleTask(req dto.StartVideoSubtitleTaskReq) (*dto.Star
 		VerticalVideoMajorTitle: req.VerticalMajorTitle,
 		VerticalVideoMinorTitle: req.VerticalMinorTitle,
 		MaxWordOneLine:          12, // 默认值
+		StepNames:               stepNames,
+		SubtitleSourcePath:      subtitleSourcePath,
+		CaptionPolicy:           req.CaptionPolicy,
+	}
+	if subtitleSourcePath != "" {
+		stepParam.TranscriptSource = types.TranscriptSourceImported
 	}
 	if req.OriginLanguageWordOneLine != 0 {
 		stepParam.MaxWordOneLine = req.OriginLanguageWordOneLine
 	}
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				const size = 64 << 10
-				buf := make([]byte, size)
-				buf = buf[:runtime.Stack(buf, false)]
-				log.GetLogger().Error("autoVideoSubtitle panic", zap.Any("panic:", r), zap.Any("stack:", buf))
-				storage.SubtitleTasks[taskId].Status = types.SubtitleTaskStatusFailed
-			}
-		}()
-		// 新版流程：链接->本地音频文件->视频信息获取（若有）->本地字幕文件->语言合成->视频合成->字幕文件链接生成
-		log.GetLogger().Info("video subtitle start task", zap.String("taskId", taskId))
-		err = s.linkToFile(ctx, &stepParam)
-		if err != nil {
-			log.GetLogger().Error("StartVideoSubtitleTask linkToFile err", zap.Any("req", req), zap.Error(err))
-			storage.SubtitleTasks[stepParam.TaskId].Status = types.SubtitleTaskStatusFailed
-			storage.SubtitleTasks[stepParam.TaskId].FailReason = err.Error()
-			return
+	// 保存初始断点，第一步失败时也可以恢复
+	if err = saveStepParam(&stepParam); err != nil {
+		log.GetLogger().Error("StartVideoSubtitleTask saveStepParam err", zap.Any("req", req), zap.Error(err))
+	}
+	taskScheduler.enqueue(taskId, req.Priority, func(ctx context.Context) {
+		s.runSubtitleTask(ctx, &stepParam, 1)
+	})
+
+	return &dto.StartVideoSubtitleTaskResData{
+		TaskId: taskId,
+	}, nil
+}
+
+// subtitleTaskLocalInputs 任务用到的上传文件，任务排队和运行期间不会被后台清理
+func subtitleTaskLocalInputs(req dto.StartVideoSubtitleTaskReq) []string {
+	return lo.Filter([]string{req.Url, req.SubtitleUrl, req.TtsVoiceCloneSrcFileUrl}, func(link string, _ int) bool {
+		return strings.HasPrefix(link, "local:")
+	})
+}
+
+// runSubtitleTask 从startStepNum开始依次执行stepParam.StepNames中的步骤，每步成功后保存断点
+// ctx由调度器在出队时创建并注册，取消任务时被取消
+// 步骤序号从1开始，与SubtitleTask.LastSuccessStepNum对应
+func (s Service) runSubtitleTask(ctx context.Context, stepParam *types.SubtitleTaskStepParam, startStepNum int) {
+	defer func() {
+		if r := recover(); r != nil {
+			const size = 64 << 10
+			buf := make([]byte, size)
+			buf = buf[:runtime.Stack(buf, false)]
+			log.GetLogger().Error("autoVideoSubtitle panic", zap.Any("panic:", r), zap.Any("stack:", buf))
+			updateTaskFailed(stepParam.TaskId, fmt.Sprintf("panic: %v", r))
 		}
-		// 暂时不加视频信息
-		//err = s.getVideoInfo(ctx, &stepParam)
-		//if err != nil {
-		//	log.GetLogger().Error("StartVideoSubtitleTask getVideoInfo err", zap.Any("req", req), zap.Error(err))
-		//	storage.SubtitleTasks[stepParam.TaskId].Status = types.SubtitleTaskStatusFailed
-		//	storage.SubtitleTasks[stepParam.TaskId].FailReason = "get video info error"
-		//	return
-		//}
-		err = s.audioToSubtitle(ctx, &stepParam)
-		if err != nil {
-			log.GetLogger().Error("StartVideoSubtitleTask audioToSubtitle err", zap.Any("req", req), zap.Error(err))
-			storage.SubtitleTasks[stepParam.TaskId].Status = types.SubtitleTaskStatusFailed
-			storage.SubtitleTasks[stepParam.TaskId].FailReason = err.Error()
+	}()
+	// 步骤中途panic时没有写入的分段记录
+	defer takePendingTimeline(stepParam.TaskId)
+	// 只有仍在排队的任务才开始运行，出队后、开始运行前被取消的任务直接收尾
+	var started bool
+	if ctx.Err() == nil {
+		updateTask(stepParam.TaskId, func(task *types.SubtitleTask) {
+			if task.Status != types.SubtitleTaskStatusQueued {
+				return
+			}
+			started = true
+			task.Status = types.SubtitleTaskStatusProcessing
+		})
+	}
+	if !started {
+		onSubtitleTaskCancelled(stepParam)
+		return
+	}
+	publishTaskEvent(dto.SubtitleTaskEvent{
+		Type:   SubtitleTaskEventStarted,
+		TaskId: stepParam.TaskId,
+		Status: types.SubtitleTaskStatusProcessing,
+	})
+
+	log.GetLogger().Info("video subtitle start task", zap.String("taskId", stepParam.TaskId), zap.Int("start step", startStepNum))
+	steps, err := s.buildSubtitleTaskSteps(stepParam.StepNames, subtitleTaskInitialArtifacts(stepParam.Link, stepParam.SubtitleSourcePath))
+	if err != nil {
+		log.GetLogger().Error("StartVideoSubtitleTask buildSubtitleTaskSteps err", zap.String("taskId", stepParam.TaskId), zap.Error(err))
+		updateTaskFailed(stepParam.TaskId, err.Error())
+		return
+	}
+	for i := startStepNum - 1; i < len(steps); i++ {
+		step := steps[i]
+		if ctx.Err() != nil {
+			onSubtitleTaskCancelled(stepParam)
 			return
 		}
-		err = s.srtFileToSpeech(ctx, &stepParam)
-		if err != nil {
-			log.GetLogger().Error("StartVideoSubtitleTask srtFileToSpeech err", zap.Any("req", req), zap.Error(err))
-			storage.SubtitleTasks[stepParam.TaskId].Status = types.SubtitleTaskStatusFailed
-			storage.SubtitleTasks[stepParam.TaskId].FailReason = err.Error()
+		publishTaskEvent(dto.SubtitleTaskEvent{
+			Type:    SubtitleTaskEventStageStarted,
+			TaskId:  stepParam.TaskId,
+			Stage:   step.Name,
+			Current: i + 1,
+			Total:   len(steps),
+		})
+		span := startTimelineSpan(stepParam.TaskId, types.TimelineKindStep, step.Name, 0)
+		stepStart := time.Now()
+		err := step.Run(ctx, stepParam)
+		recordStepDuration(step.Name, time.Since(stepStart), err)
+		span.finish(1, err)
+		if ctx.Err() != nil {
+			log.GetLogger().Info("StartVideoSubtitleTask task cancelled", zap.String("taskId", stepParam.TaskId), zap.String("step", step.Name))
+			onSubtitleTaskCancelled(stepParam)
 			return
 		}
-		err = s.embedSubtitles(ctx, &stepParam)
 		if err != nil {
-			log.GetLogger().Error("StartVideoSubtitleTask embedSubtitles err", zap.Any("req", req), zap.Error(err))
-			storage.SubtitleTasks[stepParam.TaskId].Status = types.SubtitleTaskStatusFailed
-			storage.SubtitleTasks[stepParam.TaskId].FailReason = err.Error()
+			log.GetLogger().Error("StartVideoSubtitleTask step err", zap.String("taskId", stepParam.TaskId), zap.String("step", step.Name), zap.Error(err))
+			updateTaskFailed(stepParam.TaskId, err.Error())
 			return
 		}
-		err = s.uploadSubtitles(ctx, &stepParam)
-		if err != nil {
-			log.GetLogger().Error("StartVideoSubtitleTask uploadSubtitles err", zap.Any("req", req), zap.Error(err))
-			storage.SubtitleTasks[stepParam.TaskId].Status = types.SubtitleTaskStatusFailed
-			storage.SubtitleTasks[stepParam.TaskId].FailReason = err.Error()
-			return
+		// 先落盘断点再更新步骤序号，保证序号对应的断点一定存在
+		if err = saveStepParam(stepParam); err != nil {
+			// 断点保存失败只影响恢复，不中断任务
+			log.GetLogger().Error("StartVideoSubtitleTask saveStepParam err", zap.String("taskId", stepParam.TaskId), zap.String("step", step.Name), zap.Error(err))
+			continue
 		}
-
-		log.GetLogger().Info("video subtitle task end", zap.String("taskId", taskId))
-	}()
-
-	return &dto.StartVideoSubtitleTaskResData{
-		TaskId: taskId,
-	}, nil
+		stepNum := uint8(i + 1)
+		updateTask(stepParam.TaskId, func(task *types.SubtitleTask) {
+			task.LastSuccessStepNum = stepNum
+		})
+		publishTaskEvent(dto.SubtitleTaskEvent{
+			Type:    SubtitleTaskEventStageFinished,
+			TaskId:  stepParam.TaskId,
+			Stage:   step.Name,
+			Current: i + 1,
+			Total:   len(steps),
+		})
+	}
+	log.GetLogger().Info("video subtitle task end", zap.String("taskId", stepParam.TaskId))
+	publishTaskSucceeded(stepParam.TaskId)
+	harvestTranslationMemory(stepParam)
+	if config.Conf.Retention.KeepOnlyFinalOutputs {
+		pruneTaskIntermediates(stepParam.TaskId, stepParam.TaskBasePath)
+	}
 }
 
 func (s Service) GetTaskStatus(req dto.GetVideoSubtitleTaskReq) (*dto.GetVideoSubtitleTaskResData, error) {
-	task := storage.SubtitleTasks[req.TaskId]
-	if task == nil {
-		return nil, errors.New("任务不存在")
+	task, err := storage.SubtitleTaskRepo.Get(req.TaskId)
+	if err != nil {
+		if errors.Is(err, storage.ErrSubtitleTaskNotFound) {
+			return nil, errors.New("任务不存在")
+		}
+		log.GetLogger().Error("GetTaskStatus get task err", zap.String("taskId", req.TaskId), zap.Error(err))
+		return nil, errors.New("查询任务失败")
 	}
-	if task.Status == types.SubtitleTaskStatusFailed {
+	if task.Status == types.SubtitleTaskStatusFailed || task.Status == types.SubtitleTaskStatusInterrupted {
 		return nil, fmt.Errorf("任务失败，原因：%s", task.FailReason)
 	}
+	if task.Status == types.SubtitleTaskStatusCancelled {
+		return nil, errors.New("任务已取消")
+	}
+	return buildSubtitleTaskResData(task), nil
+}
+
+func buildSubtitleTaskResData(task *types.SubtitleTask) *dto.GetVideoSubtitleTaskResData {
+	var queuePosition int
+	if task.Status == types.SubtitleTaskStatusQueued {
+		queuePosition = taskScheduler.position(task.TaskId)
+	}
 	return &dto.GetVideoSubtitleTaskResData{
 		TaskId:         task.TaskId,
+		Status:         task.Status,
+		QueuePosition:  queuePosition,
 		ProcessPercent: task.ProcessPct,
 		VideoInfo: &dto.VideoInfo{
 			Title:                 task.Title,
 			Description:           task.Description,
 			TranslatedTitle:       task.TranslatedTitle,
 			TranslatedDescription: task.TranslatedDescription,
+			Duration:              task.Duration,
+			Cover:                 task.Cover,
+			Uploader:              task.Uploader,
+			UploadDate:            task.UploadDate,
+			Chapters: lo.Map(task.Chapters, func(item types.VideoChapter, _ int) *dto.VideoChapter {
+				return &dto.VideoChapter{
+					StartTime:       item.StartTime,
+					EndTime:         item.EndTime,
+					Title:           item.Title,
+					TranslatedTitle: item.TranslatedTitle,
+				}
+			}),
 		},
 		SubtitleInfo: lo.Map(task.SubtitleInfos, func(item types.SubtitleInfo, _ int) *dto.SubtitleInfo {
 			return &dto.SubtitleInfo{
@@ -210,5 +352,69 @@ func (s Service) GetTaskStatus(req dto.GetVideoSubtitleTaskReq) (*dto.GetVideoSu
 		}),
 		TargetLanguage:    task.TargetLanguage,
 		SpeechDownloadUrl: task.SpeechDownloadUrl,
-	}, nil
+		CacheHitTaskId:    task.CacheHitTaskId,
+		Files:             buildTaskFileResData(task),
+		TranscriptSource:  task.TranscriptSource,
+		SrtNum:            task.SrtNum,
+		Timeline: lo.Map(task.Timeline, func(item types.TimelineEntry, _ int) *dto.TimelineEntry {
+			return &dto.TimelineEntry{
+				Kind:       item.Kind,
+				Name:       item.Name,
+				Segment:    item.Segment,
+				StartTime:  item.StartTime,
+				EndTime:    item.EndTime,
+				DurationMs: item.DurationMs,
+				Attempts:   item.Attempts,
+				Error:      item.Error,
+			}
+		}),
+	}
+}
+
+// 更新任务信息，存储失败只记录日志，不影响任务流程
+func updateTask(taskId string, fn func(task *types.SubtitleTask)) {
+	if err := storage.SubtitleTaskRepo.Update(taskId, fn); err != nil {
+		log.GetLogger().Error("updateTask err", zap.String("taskId", taskId), zap.Error(err))
+	}
+}
+
+func updateTaskProcessPct(taskId string, processPct uint8) {
+	updateTask(taskId, func(task *types.SubtitleTask) {
+		task.ProcessPct = processPct
+	})
+	publishTaskEvent(dto.SubtitleTaskEvent{
+		Type:           SubtitleTaskEventProgress,
+		TaskId:         taskId,
+		ProcessPercent: processPct,
+	})
+}
+
+func updateTaskFailed(taskId, failReason string) {
+	updateTask(taskId, func(task *types.SubtitleTask) {
+		task.Status = types.SubtitleTaskStatusFailed
+		task.FailReason = failReason
+	})
+	publishTaskEvent(dto.SubtitleTaskEvent{
+		Type:    SubtitleTaskEventFailed,
+		TaskId:  taskId,
+		Status:  types.SubtitleTaskStatusFailed,
+		Message: failReason,
+	})
+	notifyTaskWebhook(taskId, SubtitleTaskEventFailed)
+}
+
+func publishTaskSucceeded(taskId string) {
+	task, err := storage.SubtitleTaskRepo.Get(taskId)
+	if err != nil {
+		log.GetLogger().Error("publishTaskSucceeded get task err", zap.String("taskId", taskId), zap.Error(err))
+		return
+	}
+	publishTaskEvent(dto.SubtitleTaskEvent{
+		Type:           SubtitleTaskEventSucceeded,
+		TaskId:         taskId,
+		Status:         task.Status,
+		ProcessPercent: task.ProcessPct,
+		Result:         buildSubtitleTaskResData(task),
+	})
+	notifyTaskWebhook(taskId, SubtitleTaskEventSucceeded)
 }
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"krillin-ai/internal/storage"
+	"krillin-ai/internal/types"
+	"krillin-ai/log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 正在打包产物的批量任务，避免重复打包
+var archivingBatchTasks sync.Map
+
+// 压缩包中manifest.json的内容
+type batchArchiveManifest struct {
+	BatchTaskId string                     `json:"batch_task_id"`
+	CreateTime  string                     `json:"create_time"`
+	Tasks       []batchArchiveManifestTask `json:"tasks"`
+}
+
+type batchArchiveManifestTask struct {
+	TaskId     string                     `json:"task_id"`
+	Source     string                     `json:"source"`
+	Status     uint8                      `json:"status"`
+	FailReason string                     `json:"fail_reason,omitempty"`
+	Files      []batchArchiveManifestFile `json:"files"`
+}
+
+type batchArchiveManifestFile struct {
+	Name string `json:"name"` // 字幕名称或文件名
+	Type string `json:"type"`
+	Path string `json:"path"` // 压缩包内的路径
+}
+
+// startSubtitleBatchArchive 在后台打包批量任务的产物，同一任务同时只打包一次
+func startSubtitleBatchArchive(taskId string) {
+	if _, loaded := archivingBatchTasks.LoadOrStore(taskId, struct{}{}); loaded {
+		return
+	}
+	go func() {
+		defer archivingBatchTasks.Delete(taskId)
+		archivePath, err := buildSubtitleBatchArchive(taskId)
+		if err != nil {
+			log.GetLogger().Error("startSubtitleBatchArchive buildSubtitleBatchArchive err", zap.String("taskId", taskId), zap.Error(err))
+			return
+		}
+		updateTask(taskId, func(task *types.SubtitleTask) {
+			task.ArchiveDownloadUrl = "/api/file/" + filepath.ToSlash(archivePath)
+		})
+		log.GetLogger().Info("批量任务产物打包完成", zap.String("taskId", taskId), zap.String("path", archivePath))
+	}()
+}
+
+// buildSubtitleBatchArchive 把所有成功子任务的字幕、配音和合成视频打包为一个zip，每个子任务一个目录
+func buildSubtitleBatchArchive(taskId string) (string, error) {
+	task, err := storage.SubtitleTaskRepo.Get(taskId)
+	if err != nil {
+		return "", fmt.Errorf("buildSubtitleBatchArchive get task err: %w", err)
+	}
+	outputDir := filepath.Join("./tasks", taskId, "output")
+	if err = os.MkdirAll(outputDir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("buildSubtitleBatchArchive mkdir err: %w", err)
+	}
+	archivePath := filepath.Join(outputDir, fmt.Sprintf("batch_%s.zip", taskId))
+	// 先写临时文件，打包完成后再替换，避免下载到不完整的压缩包
+	tmpPath := archivePath + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return "", fmt.Errorf("buildSubtitleBatchArchive create file err: %w", err)
+	}
+	defer os.Remove(tmpPath)
+
+	zw := zip.NewWriter(f)
+	manifest := batchArchiveManifest{
+		BatchTaskId: taskId,
+		CreateTime:  time.Now().Format(time.RFC3339),
+		Tasks:       make([]batchArchiveManifestTask, 0, len(task.ChildTaskIds)),
+	}
+	for i, childId := range task.ChildTaskIds {
+		child, err := storage.SubtitleTaskRepo.Get(childId)
+		if err != nil {
+			manifest.Tasks = append(manifest.Tasks, batchArchiveManifestTask{
+				TaskId:     childId,
+				Status:     types.SubtitleTaskStatusFailed,
+				FailReason: "任务不存在",
+				Files:      []batchArchiveManifestFile{},
+			})
+			continue
+		}
+		item := batchArchiveManifestTask{
+			TaskId:     childId,
+			Source:     child.VideoSrc,
+			Status:     child.Status,
+			FailReason: child.FailReason,
+			Files:      []batchArchiveManifestFile{},
+		}
+		if child.Status == types.SubtitleTaskStatusSuccess {
+			dir := fmt.Sprintf("%02d_%s", i+1, archiveEntryName(child))
+			if item.Files, err = addSubtitleTaskToArchive(zw, dir, child); err != nil {
+				zw.Close()
+				f.Close()
+				return "", err
+			}
+		}
+		manifest.Tasks = append(manifest.Tasks, item)
+	}
+
+	w, err := zw.Create("manifest.json")
+	if err == nil {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(manifest)
+	}
+	if err != nil {
+		zw.Close()
+		f.Close()
+		return "", fmt.Errorf("buildSubtitleBatchArchive write manifest err: %w", err)
+	}
+	if err = zw.Close(); err != nil {
+		f.Close()
+		return "", fmt.Errorf("buildSubtitleBatchArchive close zip err: %w", err)
+	}
+	if err = f.Close(); err != nil {
+		return "", fmt.Errorf("buildSubtitleBatchArchive close file err: %w", err)
+	}
+	if err = os.Rename(tmpPath, archivePath); err != nil {
+		return "", fmt.Errorf("buildSubtitleBatchArchive rename err: %w", err)
+	}
+	return archivePath, nil
+}
+
+// archiveEntryName 用来源文件名或视频标题作为子任务目录名，去掉路径分隔符等不适合做文件名的字符
+func archiveEntryName(task *types.SubtitleTask) string {
+	name := task.Title
+	if strings.HasPrefix(task.VideoSrc, "local:") {
+		base := filepath.Base(strings.TrimPrefix(task.VideoSrc, "local:"))
+		name = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if name == "" {
+		return task.TaskId
+	}
+	if runes := []rune(name); len(runes) > 60 {
+		name = string(runes[:60])
+	}
+	return name
+}
+
+// addSubtitleTaskToArchive 写入子任务的字幕、配音和output目录下的文件，返回写入的文件列表
+func addSubtitleTaskToArchive(zw *zip.Writer, dir string, task *types.SubtitleTask) ([]batchArchiveManifestFile, error) {
+	files := make([]batchArchiveManifestFile, 0)
+	for _, file := range subtitleTaskFiles(task) {
+		entryPath := path.Join(dir, filepath.Base(file.Path))
+		compress := file.Type == taskFileTypeSubtitle || file.Type == taskFileTypeText
+		if err := addFileToArchive(zw, file.Path, entryPath, compress); err != nil {
+			if os.IsNotExist(err) {
+				// 产物可能已被过期清理
+				log.GetLogger().Warn("addSubtitleTaskToArchive file not exist", zap.String("taskId", task.TaskId), zap.String("path", file.Path))
+				continue
+			}
+			return nil, fmt.Errorf("addSubtitleTaskToArchive add file err: %w", err)
+		}
+		files = append(files, batchArchiveManifestFile{Name: file.Name, Type: file.Type, Path: entryPath})
+	}
+	return files, nil
+}
+
+// addFileToArchive 文本文件压缩存储，音视频本身已压缩，直接存储节省时间
+func addFileToArchive(zw *zip.Writer, localPath, entryPath string, compress bool) error {
+	src, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = entryPath
+	header.Method = zip.Store
+	if compress {
+		header.Method = zip.Deflate
+	}
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, src)
+	return err
+}
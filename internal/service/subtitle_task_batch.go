@@ -0,0 +1,486 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+	"krillin-ai/internal/dto"
+	"krillin-ai/internal/storage"
+	"krillin-ai/internal/types"
+	"krillin-ai/log"
+	"krillin-ai/pkg/util"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 一个批量任务最多包含的子任务数量
+	maxBatchChildTasks = 500
+)
+
+// 汇总子任务状态的间隔
+var batchTaskWatchInterval = 5 * time.Second
+
+// 正在展开播放列表或创建子任务的批量任务，value为取消展开的context.CancelFunc
+var expandingBatchTasks sync.Map
+
+// 已有后台协程在汇总子任务状态的批量任务
+var watchingBatchTasks sync.Map
+
+// yt-dlp --flat-playlist -J 输出中用到的字段
+type ytdlpPlaylist struct {
+	Type    string               `json:"_type"`
+	Entries []ytdlpPlaylistEntry `json:"entries"`
+}
+
+type ytdlpPlaylistEntry struct {
+	Type  string `json:"_type"`
+	Id    string `json:"id"`
+	Url   string `json:"url"`
+	IeKey string `json:"ie_key"`
+}
+
+// listPlaylistLinks 通过yt-dlp列出播放列表、合集或频道中的视频链接，频道的标签页会再展开一层
+func listPlaylistLinks(ctx context.Context, src *resolvedSource, limit int, depth int) ([]string, error) {
+	cmdArgs := []string{"--flat-playlist", "--dump-single-json", "--playlist-end", strconv.Itoa(limit), src.Url}
+	cmdArgs = append(cmdArgs, ytdlpSourceArgs(src)...)
+	cmd := exec.CommandContext(ctx, storage.YtdlpPath, cmdArgs...)
+	output, err := cmd.Output()
+	if err != nil {
+		recordProcessFailure(ctx, cmd)
+		return nil, fmt.Errorf("listPlaylistLinks yt-dlp err: %w", err)
+	}
+	var playlist ytdlpPlaylist
+	if err = json.Unmarshal(output, &playlist); err != nil {
+		return nil, fmt.Errorf("listPlaylistLinks unmarshal err: %w", err)
+	}
+	if playlist.Type != "playlist" {
+		return nil, errors.New("链接不是播放列表、合集或频道")
+	}
+
+	links := make([]string, 0, len(playlist.Entries))
+	for _, entry := range playlist.Entries {
+		if len(links) >= limit {
+			break
+		}
+		if entry.Type == "playlist" || strings.HasSuffix(entry.IeKey, "Tab") {
+			if depth > 0 && entry.Url != "" {
+				nested, err := listPlaylistLinks(ctx, &resolvedSource{Resolver: src.Resolver, Url: entry.Url, resolver: src.resolver}, limit-len(links), depth-1)
+				if err != nil {
+					log.GetLogger().Warn("listPlaylistLinks nested playlist err", zap.String("url", entry.Url), zap.Error(err))
+					continue
+				}
+				links = append(links, nested...)
+			}
+			continue
+		}
+		link := entry.Url
+		if !strings.HasPrefix(link, "http") && entry.IeKey == "Youtube" && entry.Id != "" {
+			link = "https://www.youtube.com/watch?v=" + entry.Id
+		}
+		if !strings.HasPrefix(link, "http") {
+			continue
+		}
+		links = append(links, link)
+	}
+	return links, nil
+}
+
+// StartSubtitleBatchTask 创建批量任务，填写urls时为每个链接创建子任务，否则先展开播放列表，
+// 子任务在后台创建，使用相同的参数
+func (s Service) StartSubtitleBatchTask(req dto.StartVideoSubtitleBatchReq) (*dto.StartVideoSubtitleTaskResData, error) {
+	var (
+		playlistUrl *url.URL
+		resolver    *sourceResolver
+		links       []string
+		err         error
+	)
+	if len(req.Urls) > 0 {
+		if links, err = checkBatchLinks(req.Urls); err != nil {
+			return nil, err
+		}
+	} else {
+		playlistUrl, resolver, err = parseSourceLink(req.Url)
+		if err != nil {
+			return nil, err
+		}
+		if resolver.Name == sourceResolverDirect || resolver.Name == sourceResolverStream {
+			return nil, errors.New("链接不是播放列表、合集或频道")
+		}
+	}
+	if req.CallbackUrl != "" {
+		if err = validateCallbackUrl(req.CallbackUrl); err != nil {
+			return nil, err
+		}
+	}
+	// 提前校验步骤，避免每个子任务都创建失败。批量任务的每个子任务都有视频链接，字幕只能逐个导入
+	if req.SubtitleUrl != "" {
+		return nil, errors.New("批量任务不支持导入字幕")
+	}
+	if err = checkCaptionPolicy(req.CaptionPolicy); err != nil {
+		return nil, err
+	}
+	if _, err = s.buildSubtitleTaskSteps(req.Steps, []stepArtifact{artifactLink}); err != nil {
+		return nil, err
+	}
+
+	taskId := util.GenerateRandStringWithUpperLowerNum(8)
+	if err = os.MkdirAll(filepath.Join("./tasks", taskId, "output"), os.ModePerm); err != nil {
+		log.GetLogger().Error("StartSubtitleBatchTask MkdirAll err", zap.Any("req", req), zap.Error(err))
+	}
+	task := &types.SubtitleTask{
+		TaskId:         taskId,
+		TaskType:       types.SubtitleTaskTypeBatch,
+		OriginLanguage: req.OriginLanguage,
+		TargetLanguage: req.TargetLang,
+		Status:         types.SubtitleTaskStatusProcessing,
+		BatchExpanding: true,
+	}
+	if playlistUrl != nil {
+		task.VideoSrc = playlistUrl.String()
+	}
+	if err = storage.SubtitleTaskRepo.Create(task); err != nil {
+		log.GetLogger().Error("StartSubtitleBatchTask create task err", zap.Any("req", req), zap.Error(err))
+		return nil, errors.New("创建任务失败")
+	}
+
+	// 创建子任务可能需要较长时间，和展开播放列表一样在后台进行，展开完成前不根据子任务汇总状态
+	ctx, cancel := context.WithCancel(context.Background())
+	expandingBatchTasks.Store(taskId, cancel)
+	var src *resolvedSource
+	if playlistUrl != nil {
+		src = &resolvedSource{Resolver: resolver.Name, Url: playlistUrl.String(), resolver: resolver}
+	}
+	limit := req.MaxItems
+	if limit <= 0 || limit > maxBatchChildTasks {
+		limit = maxBatchChildTasks
+	}
+	go s.expandSubtitleBatchTask(ctx, taskId, src, links, req.StartVideoSubtitleTaskReq, limit)
+
+	return &dto.StartVideoSubtitleTaskResData{
+		TaskId: taskId,
+	}, nil
+}
+
+// checkBatchLinks 批量提交的链接在创建任务前全部校验，避免部分创建后才发现错误，
+// 返回规范化并去重后的链接，同一视频的不同链接只创建一个子任务
+func checkBatchLinks(links []string) ([]string, error) {
+	if len(links) > maxBatchChildTasks {
+		return nil, fmt.Errorf("一次最多提交%d个视频", maxBatchChildTasks)
+	}
+	normalized := make([]string, 0, len(links))
+	for _, link := range links {
+		link, err := checkBatchMemberLink(link)
+		if err != nil {
+			return nil, err
+		}
+		normalized = append(normalized, link)
+	}
+	return lo.Uniq(normalized), nil
+}
+
+// checkBatchMemberLink 校验单个链接，返回规范化后的链接
+func checkBatchMemberLink(link string) (string, error) {
+	if strings.HasPrefix(link, "local:") {
+		if _, err := resolveUploadedFile(link); err != nil {
+			return "", fmt.Errorf("%w：%s", err, link)
+		}
+		return link, nil
+	}
+	source, err := resolveSource(link)
+	if err != nil {
+		return "", fmt.Errorf("%w：%s", err, link)
+	}
+	return source.Url, nil
+}
+
+// expandSubtitleBatchTask 展开播放列表后为每个视频创建子任务，src为空时直接使用links，取消ctx可以停止展开
+func (s Service) expandSubtitleBatchTask(ctx context.Context, taskId string, src *resolvedSource, links []string, req dto.StartVideoSubtitleTaskReq, limit int) {
+	defer func() {
+		if r := recover(); r != nil {
+			const size = 64 << 10
+			buf := make([]byte, size)
+			buf = buf[:runtime.Stack(buf, false)]
+			log.GetLogger().Error("expandSubtitleBatchTask panic", zap.Any("panic:", r), zap.Any("stack:", buf))
+			finishSubtitleBatchExpansion(taskId)
+			updateTaskFailed(taskId, fmt.Sprintf("panic: %v", r))
+		}
+	}()
+
+	if src != nil {
+		var err error
+		links, err = listPlaylistLinks(ctx, src, limit, 1)
+		if err != nil || len(links) == 0 {
+			if !finishSubtitleBatchExpansion(taskId) {
+				s.stopSubtitleBatchExpansion(taskId)
+				return
+			}
+			if err != nil {
+				log.GetLogger().Error("expandSubtitleBatchTask listPlaylistLinks err", zap.String("taskId", taskId), zap.String("url", src.Url), zap.Error(err))
+				updateTaskFailed(taskId, "展开播放列表失败")
+			} else {
+				updateTaskFailed(taskId, "播放列表中没有视频")
+			}
+			return
+		}
+		links = lo.Uniq(links)
+	}
+
+	s.startSubtitleBatchChildren(ctx, taskId, req, links)
+}
+
+// finishSubtitleBatchExpansion 结束展开，返回false表示展开已被取消，由取消请求负责收尾
+func finishSubtitleBatchExpansion(taskId string) bool {
+	cancel, ok := expandingBatchTasks.LoadAndDelete(taskId)
+	if ok {
+		cancel.(context.CancelFunc)()
+	}
+	return ok
+}
+
+// startSubtitleBatchChildren 为每个链接创建子任务，全部创建后开始跟踪子任务进度
+func (s Service) startSubtitleBatchChildren(ctx context.Context, taskId string, req dto.StartVideoSubtitleTaskReq, links []string) {
+	req.ParentTaskId = taskId
+	failedNum := 0
+	for _, link := range links {
+		if ctx.Err() != nil {
+			break
+		}
+		req.Url = link
+		data, err := s.StartSubtitleTask(req)
+		if err != nil {
+			failedNum++
+			log.GetLogger().Warn("startSubtitleBatchChildren StartSubtitleTask err", zap.String("taskId", taskId), zap.String("url", link), zap.Error(err))
+			continue
+		}
+		updateTask(taskId, func(task *types.SubtitleTask) {
+			task.ChildTaskIds = append(task.ChildTaskIds, data.TaskId)
+		})
+	}
+	if !finishSubtitleBatchExpansion(taskId) {
+		s.stopSubtitleBatchExpansion(taskId)
+		return
+	}
+	if failedNum == len(links) {
+		updateTaskFailed(taskId, "所有视频创建任务失败")
+		return
+	}
+	updateTask(taskId, func(task *types.SubtitleTask) {
+		task.BatchExpanding = false
+		if failedNum > 0 {
+			task.FailReason = fmt.Sprintf("%d个视频创建任务失败", failedNum)
+		}
+	})
+	log.GetLogger().Info("批量任务子任务创建完成", zap.String("taskId", taskId), zap.Int("video num", len(links)), zap.Int("failed num", failedNum))
+	s.startSubtitleBatchWatcher(taskId)
+}
+
+// stopSubtitleBatchExpansion 展开被取消后取消已创建的子任务，没有子任务时直接把批量任务标记为已取消
+func (s Service) stopSubtitleBatchExpansion(taskId string) {
+	task, err := storage.SubtitleTaskRepo.Get(taskId)
+	if err != nil {
+		log.GetLogger().Error("stopSubtitleBatchExpansion get task err", zap.String("taskId", taskId), zap.Error(err))
+		return
+	}
+	s.cancelSubtitleBatchChildren(task)
+	updateTask(taskId, func(task *types.SubtitleTask) {
+		task.BatchExpanding = false
+	})
+	if len(task.ChildTaskIds) == 0 {
+		updateTaskCancelled(taskId)
+		return
+	}
+	log.GetLogger().Info("批量任务展开已取消", zap.String("taskId", taskId), zap.Int("child num", len(task.ChildTaskIds)))
+	s.startSubtitleBatchWatcher(taskId)
+}
+
+// startSubtitleBatchWatcher 启动批量任务的状态汇总协程，同一个批量任务只会有一个
+func (s Service) startSubtitleBatchWatcher(taskId string) {
+	if _, watching := watchingBatchTasks.LoadOrStore(taskId, struct{}{}); watching {
+		return
+	}
+	go func() {
+		defer watchingBatchTasks.Delete(taskId)
+		s.watchSubtitleBatchTask(taskId)
+	}()
+}
+
+// RestoreSubtitleBatchTasks 服务启动时处理上次未结束的批量任务：展开被中断的标记为失败，其余重新汇总子任务状态，未结束的继续在后台汇总
+func RestoreSubtitleBatchTasks() {
+	tasks, _, err := storage.SubtitleTaskRepo.List(storage.SubtitleTaskQuery{})
+	if err != nil {
+		log.GetLogger().Error("RestoreSubtitleBatchTasks list task err", zap.Error(err))
+		return
+	}
+	s := Service{}
+	for _, task := range tasks {
+		if task.TaskType != types.SubtitleTaskTypeBatch {
+			continue
+		}
+		if task.Status == types.SubtitleTaskStatusSuccess || task.Status == types.SubtitleTaskStatusFailed || task.Status == types.SubtitleTaskStatusCancelled {
+			continue
+		}
+		if task.BatchExpanding {
+			// 展开协程已随服务退出，保留展开标记，不再汇总已创建的部分子任务
+			updateTask(task.TaskId, func(task *types.SubtitleTask) {
+				task.Status = types.SubtitleTaskStatusFailed
+				if task.FailReason == "" {
+					task.FailReason = "服务重启，批量任务的子任务未全部创建"
+				}
+			})
+			log.GetLogger().Info("批量任务展开被中断，标记为失败", zap.String("taskId", task.TaskId))
+			continue
+		}
+		data, err := s.refreshSubtitleBatchTask(task.TaskId)
+		if err != nil {
+			log.GetLogger().Error("RestoreSubtitleBatchTasks refresh err", zap.String("taskId", task.TaskId), zap.Error(err))
+			continue
+		}
+		if !isSubtitleTaskEnded(data.Status) {
+			s.startSubtitleBatchWatcher(task.TaskId)
+		}
+	}
+}
+
+// watchSubtitleBatchTask 定期汇总子任务状态，全部结束后打包产物
+func (s Service) watchSubtitleBatchTask(taskId string) {
+	ticker := time.NewTicker(batchTaskWatchInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		data, err := s.refreshSubtitleBatchTask(taskId)
+		if err != nil {
+			log.GetLogger().Error("watchSubtitleBatchTask refresh err", zap.String("taskId", taskId), zap.Error(err))
+			return
+		}
+		if isSubtitleTaskEnded(data.Status) {
+			return
+		}
+	}
+}
+
+// aggregateBatchStatus 有子任务未结束时为处理中，全部成功为成功，全部取消为取消，其余为失败
+func aggregateBatchStatus(statusCount map[uint8]int, total int) uint8 {
+	if statusCount[types.SubtitleTaskStatusProcessing]+statusCount[types.SubtitleTaskStatusQueued] > 0 {
+		return types.SubtitleTaskStatusProcessing
+	}
+	if statusCount[types.SubtitleTaskStatusSuccess] == total {
+		return types.SubtitleTaskStatusSuccess
+	}
+	if statusCount[types.SubtitleTaskStatusCancelled] == total {
+		return types.SubtitleTaskStatusCancelled
+	}
+	return types.SubtitleTaskStatusFailed
+}
+
+// GetSubtitleBatchTask 查询批量任务及所有子任务的状态
+func (s Service) GetSubtitleBatchTask(req dto.GetVideoSubtitleTaskReq) (*dto.GetVideoSubtitleBatchResData, error) {
+	return s.refreshSubtitleBatchTask(req.TaskId)
+}
+
+// refreshSubtitleBatchTask 汇总批量任务下所有子任务的状态、进度和产物，全部结束后触发打包
+func (s Service) refreshSubtitleBatchTask(taskId string) (*dto.GetVideoSubtitleBatchResData, error) {
+	task, err := storage.SubtitleTaskRepo.Get(taskId)
+	if err != nil {
+		if errors.Is(err, storage.ErrSubtitleTaskNotFound) {
+			return nil, errors.New("任务不存在")
+		}
+		log.GetLogger().Error("refreshSubtitleBatchTask get task err", zap.String("taskId", taskId), zap.Error(err))
+		return nil, errors.New("查询任务失败")
+	}
+	if task.TaskType != types.SubtitleTaskTypeBatch {
+		return nil, errors.New("不是批量任务")
+	}
+	// 展开状态保存在任务记录中，服务重启时未展开完成的批量任务会被标记为中断，不再汇总子任务状态
+	expanding := task.BatchExpanding && !isSubtitleTaskEnded(task.Status)
+
+	data := &dto.GetVideoSubtitleBatchResData{
+		TaskId:      task.TaskId,
+		VideoSrc:    task.VideoSrc,
+		Status:      task.Status,
+		FailReason:  task.FailReason,
+		Expanding:   expanding,
+		Total:       len(task.ChildTaskIds),
+		StatusCount: make(map[uint8]int),
+		Children:    make([]*dto.GetVideoSubtitleTaskResData, 0, len(task.ChildTaskIds)),
+
+		ArchiveDownloadUrl: task.ArchiveDownloadUrl,
+	}
+	percentSum := 0
+	for _, childId := range task.ChildTaskIds {
+		child, err := storage.SubtitleTaskRepo.Get(childId)
+		if err != nil {
+			// 子任务可能已被清理
+			log.GetLogger().Warn("refreshSubtitleBatchTask get child task err", zap.String("taskId", task.TaskId), zap.String("child taskId", childId), zap.Error(err))
+			data.StatusCount[types.SubtitleTaskStatusFailed]++
+			percentSum += 100
+			continue
+		}
+		data.StatusCount[child.Status]++
+		if isSubtitleTaskEnded(child.Status) {
+			percentSum += 100
+		} else {
+			percentSum += int(child.ProcessPct)
+		}
+		data.Children = append(data.Children, buildSubtitleTaskResData(child))
+	}
+	if data.Total > 0 {
+		data.ProcessPercent = uint8(percentSum / data.Total)
+	}
+
+	// 展开完成后由子任务汇总状态，并同步到批量任务记录中，便于列表查询
+	if !task.BatchExpanding && data.Total > 0 {
+		data.Status = aggregateBatchStatus(data.StatusCount, data.Total)
+		if data.Status != task.Status || data.ProcessPercent != task.ProcessPct {
+			updateTask(task.TaskId, func(task *types.SubtitleTask) {
+				task.Status = data.Status
+				task.ProcessPct = data.ProcessPercent
+			})
+		}
+		if isSubtitleTaskEnded(data.Status) && task.ArchiveDownloadUrl == "" && data.StatusCount[types.SubtitleTaskStatusSuccess] > 0 {
+			startSubtitleBatchArchive(task.TaskId)
+		}
+	}
+	_, data.Archiving = archivingBatchTasks.Load(task.TaskId)
+	return data, nil
+}
+
+// cancelSubtitleBatchTask 取消批量任务下所有未结束的子任务，正在展开时停止展开，已创建的子任务在展开结束时取消
+func (s Service) cancelSubtitleBatchTask(task *types.SubtitleTask) error {
+	if cancel, expanding := expandingBatchTasks.LoadAndDelete(task.TaskId); expanding {
+		cancel.(context.CancelFunc)()
+		log.GetLogger().Info("cancelSubtitleBatchTask expansion cancelled", zap.String("taskId", task.TaskId))
+		return nil
+	}
+	if s.cancelSubtitleBatchChildren(task) == 0 {
+		return errors.New("没有可以取消的子任务")
+	}
+	return nil
+}
+
+// cancelSubtitleBatchChildren 取消所有未结束的子任务，返回取消的数量
+func (s Service) cancelSubtitleBatchChildren(task *types.SubtitleTask) int {
+	cancelled := 0
+	for _, childId := range task.ChildTaskIds {
+		child, err := storage.SubtitleTaskRepo.Get(childId)
+		if err != nil || isSubtitleTaskEnded(child.Status) {
+			continue
+		}
+		if err = s.CancelSubtitleTask(dto.CancelVideoSubtitleTaskReq{TaskId: childId}); err != nil {
+			log.GetLogger().Warn("cancelSubtitleBatchChildren cancel child err", zap.String("taskId", task.TaskId), zap.String("child taskId", childId), zap.Error(err))
+			continue
+		}
+		cancelled++
+	}
+	log.GetLogger().Info("cancelSubtitleBatchChildren children cancelled", zap.String("taskId", task.TaskId), zap.Int("cancelled", cancelled))
+	return cancelled
+}
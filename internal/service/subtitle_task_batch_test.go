@@ -0,0 +1,295 @@
+package service
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"go.uber.org/zap"
+	"krillin-ai/internal/dto"
+	"krillin-ai/internal/storage"
+	"krillin-ai/internal/types"
+	"krillin-ai/log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func Test_aggregateBatchStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		statusCount map[uint8]int
+		total       int
+		want        uint8
+	}{
+		{"queued child", map[uint8]int{types.SubtitleTaskStatusSuccess: 2, types.SubtitleTaskStatusQueued: 1}, 3, types.SubtitleTaskStatusProcessing},
+		{"processing child", map[uint8]int{types.SubtitleTaskStatusFailed: 1, types.SubtitleTaskStatusProcessing: 1}, 2, types.SubtitleTaskStatusProcessing},
+		{"all success", map[uint8]int{types.SubtitleTaskStatusSuccess: 3}, 3, types.SubtitleTaskStatusSuccess},
+		{"all cancelled", map[uint8]int{types.SubtitleTaskStatusCancelled: 2}, 2, types.SubtitleTaskStatusCancelled},
+		{"success and failed", map[uint8]int{types.SubtitleTaskStatusSuccess: 2, types.SubtitleTaskStatusFailed: 1}, 3, types.SubtitleTaskStatusFailed},
+		{"success and cancelled", map[uint8]int{types.SubtitleTaskStatusSuccess: 1, types.SubtitleTaskStatusCancelled: 1}, 2, types.SubtitleTaskStatusFailed},
+		{"interrupted", map[uint8]int{types.SubtitleTaskStatusInterrupted: 1}, 1, types.SubtitleTaskStatusFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := aggregateBatchStatus(tt.statusCount, tt.total); got != tt.want {
+				t.Errorf("aggregateBatchStatus() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// chdirTemp 切换到临时目录，测试中的uploads、tasks等相对路径都落在临时目录下
+func chdirTemp(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func Test_checkBatchLinks(t *testing.T) {
+	log.Logger = zap.NewNop()
+	chdirTemp(t)
+	if err := os.MkdirAll(uploadRoot, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(uploadRoot, "abc_video.mp4"), []byte("1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	links, err := checkBatchLinks([]string{
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ",
+		"https://youtu.be/dQw4w9WgXcQ?si=abc",
+		"local:./uploads/abc_video.mp4",
+		"https://www.bilibili.com/video/BV1GJ411x7h7/?spm_id_from=333",
+		"local:./uploads/abc_video.mp4",
+	})
+	want := []string{
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ",
+		"local:./uploads/abc_video.mp4",
+		"https://www.bilibili.com/video/BV1GJ411x7h7",
+	}
+	if err != nil || !reflect.DeepEqual(links, want) {
+		t.Errorf("checkBatchLinks() = %v, %v, want %v, nil", links, err, want)
+	}
+
+	tooMany := make([]string, maxBatchChildTasks+1)
+	for i := range tooMany {
+		tooMany[i] = "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
+	}
+	for name, links := range map[string][]string{
+		"invalid url":    {"https://www.youtube.com/watch?v=dQw4w9WgXcQ", "ftp://example.com/a.mp4"},
+		"missing upload": {"local:./uploads/missing.mp4"},
+		"outside upload": {"local:./config/config.toml"},
+		"too many":       tooMany,
+	} {
+		if got, err := checkBatchLinks(links); err == nil {
+			t.Errorf("checkBatchLinks(%s) = %v, want err", name, got)
+		}
+	}
+}
+
+func Test_buildSubtitleBatchArchive(t *testing.T) {
+	log.Logger = zap.NewNop()
+	chdirTemp(t)
+	useMemoryTaskRepo(t)
+
+	outputDir := filepath.Join(taskWorkspaceRoot, "child1", "output")
+	if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	srtPath := filepath.Join(taskWorkspaceRoot, "child1", "output", "bilingual.srt")
+	if err := os.WriteFile(srtPath, []byte("1\n00:00:00,000 --> 00:00:01,000\nhello\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outputDir, "horizontal_embed.mp4"), []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, task := range []*types.SubtitleTask{
+		{TaskId: "batch1", TaskType: types.SubtitleTaskTypeBatch, ChildTaskIds: []string{"child1", "child2", "gone"}},
+		{
+			TaskId:        "child1",
+			Title:         "Intro: part/1",
+			VideoSrc:      "https://www.youtube.com/watch?v=dQw4w9WgXcQ",
+			Status:        types.SubtitleTaskStatusSuccess,
+			SubtitleInfos: []types.SubtitleInfo{{Name: "双语字幕", DownloadUrl: "/api/file/" + filepath.ToSlash(srtPath)}},
+		},
+		{TaskId: "child2", VideoSrc: "local:./uploads/b.mp4", Status: types.SubtitleTaskStatusFailed, FailReason: "转录失败"},
+	} {
+		if err := storage.SubtitleTaskRepo.Create(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archivePath, err := buildSubtitleBatchArchive("batch1")
+	if err != nil {
+		t.Fatalf("buildSubtitleBatchArchive() err = %v", err)
+	}
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	var (
+		manifest batchArchiveManifest
+		entries  []string
+	)
+	for _, file := range zr.File {
+		entries = append(entries, file.Name)
+		if file.Name != "manifest.json" {
+			continue
+		}
+		r, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = json.NewDecoder(r).Decode(&manifest)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sort.Strings(entries)
+	wantEntries := []string{"01_Intro_ part_1/bilingual.srt", "01_Intro_ part_1/horizontal_embed.mp4", "manifest.json"}
+	if !reflect.DeepEqual(entries, wantEntries) {
+		t.Errorf("archive entries = %v, want %v", entries, wantEntries)
+	}
+
+	if manifest.BatchTaskId != "batch1" || len(manifest.Tasks) != 3 {
+		t.Fatalf("manifest = %+v, want 3 tasks of batch1", manifest)
+	}
+	if got := manifest.Tasks[0]; got.TaskId != "child1" || got.Status != types.SubtitleTaskStatusSuccess || len(got.Files) != 2 ||
+		got.Files[0].Name != "双语字幕" || got.Files[0].Path != "01_Intro_ part_1/bilingual.srt" {
+		t.Errorf("manifest.Tasks[0] = %+v", got)
+	}
+	if got := manifest.Tasks[1]; got.TaskId != "child2" || got.Status != types.SubtitleTaskStatusFailed || got.FailReason != "转录失败" || len(got.Files) != 0 {
+		t.Errorf("manifest.Tasks[1] = %+v", got)
+	}
+	if got := manifest.Tasks[2]; got.TaskId != "gone" || got.Status != types.SubtitleTaskStatusFailed || got.FailReason != "任务不存在" {
+		t.Errorf("manifest.Tasks[2] = %+v", got)
+	}
+}
+
+// useMemoryTaskRepo 测试期间使用内存存储
+func useMemoryTaskRepo(t *testing.T) {
+	originRepo := storage.SubtitleTaskRepo
+	storage.SubtitleTaskRepo = storage.NewMemorySubtitleTaskRepo()
+	t.Cleanup(func() { storage.SubtitleTaskRepo = originRepo })
+}
+
+func Test_cancelSubtitleBatchTaskWhileExpanding(t *testing.T) {
+	log.Logger = zap.NewNop()
+	chdirTemp(t)
+	useMemoryTaskRepo(t)
+	err := storage.SubtitleTaskRepo.Create(&types.SubtitleTask{
+		TaskId:         "batch1",
+		TaskType:       types.SubtitleTaskTypeBatch,
+		Status:         types.SubtitleTaskStatusProcessing,
+		BatchExpanding: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	expandingBatchTasks.Store("batch1", cancel)
+
+	task, _ := storage.SubtitleTaskRepo.Get("batch1")
+	if err = (Service{}).cancelSubtitleBatchTask(task); err != nil {
+		t.Fatalf("cancelSubtitleBatchTask() err = %v", err)
+	}
+	if ctx.Err() == nil {
+		t.Fatal("expansion context not cancelled")
+	}
+	// 展开协程发现已取消后不再创建子任务
+	(Service{}).startSubtitleBatchChildren(ctx, "batch1", dto.StartVideoSubtitleTaskReq{}, []string{"https://www.youtube.com/watch?v=dQw4w9WgXcQ"})
+
+	task, _ = storage.SubtitleTaskRepo.Get("batch1")
+	if task.Status != types.SubtitleTaskStatusCancelled || task.BatchExpanding || len(task.ChildTaskIds) != 0 {
+		t.Errorf("batch task = status %d, expanding %v, children %v, want cancelled without children", task.Status, task.BatchExpanding, task.ChildTaskIds)
+	}
+	if _, ok := expandingBatchTasks.Load("batch1"); ok {
+		t.Error("expandingBatchTasks still contains batch1")
+	}
+}
+
+func Test_refreshSubtitleBatchTaskInterruptedExpansion(t *testing.T) {
+	log.Logger = zap.NewNop()
+	useMemoryTaskRepo(t)
+	for _, task := range []*types.SubtitleTask{
+		// 服务重启时只创建了部分子任务
+		{TaskId: "batch1", TaskType: types.SubtitleTaskTypeBatch, Status: types.SubtitleTaskStatusInterrupted, BatchExpanding: true, ChildTaskIds: []string{"child1"}},
+		{TaskId: "child1", Status: types.SubtitleTaskStatusSuccess},
+	} {
+		if err := storage.SubtitleTaskRepo.Create(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := (Service{}).refreshSubtitleBatchTask("batch1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Status != types.SubtitleTaskStatusInterrupted || data.Expanding {
+		t.Errorf("refreshSubtitleBatchTask() = status %d, expanding %v, want interrupted", data.Status, data.Expanding)
+	}
+}
+
+func Test_RestoreSubtitleBatchTasks(t *testing.T) {
+	log.Logger = zap.NewNop()
+	useMemoryTaskRepo(t)
+	originInterval := batchTaskWatchInterval
+	batchTaskWatchInterval = 10 * time.Millisecond
+	t.Cleanup(func() { batchTaskWatchInterval = originInterval })
+	for _, task := range []*types.SubtitleTask{
+		// 展开被中断的批量任务
+		{TaskId: "batch1", TaskType: types.SubtitleTaskTypeBatch, Status: types.SubtitleTaskStatusInterrupted, BatchExpanding: true, ChildTaskIds: []string{"child1"}},
+		{TaskId: "child1", ParentTaskId: "batch1", Status: types.SubtitleTaskStatusSuccess},
+		// 展开已完成，子任务还在排队
+		{TaskId: "batch2", TaskType: types.SubtitleTaskTypeBatch, Status: types.SubtitleTaskStatusInterrupted, ChildTaskIds: []string{"child2"}},
+		{TaskId: "child2", ParentTaskId: "batch2", Status: types.SubtitleTaskStatusQueued},
+	} {
+		if err := storage.SubtitleTaskRepo.Create(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	RestoreSubtitleBatchTasks()
+
+	task, _ := storage.SubtitleTaskRepo.Get("batch1")
+	if task.Status != types.SubtitleTaskStatusFailed || task.FailReason == "" {
+		t.Errorf("batch1 = status %d, fail reason %q, want failed", task.Status, task.FailReason)
+	}
+	if _, ok := watchingBatchTasks.Load("batch1"); ok {
+		t.Error("watchingBatchTasks contains batch1")
+	}
+	task, _ = storage.SubtitleTaskRepo.Get("batch2")
+	if task.Status != types.SubtitleTaskStatusProcessing {
+		t.Errorf("batch2 status = %d, want processing", task.Status)
+	}
+	if _, ok := watchingBatchTasks.Load("batch2"); !ok {
+		t.Fatal("watchingBatchTasks does not contain batch2")
+	}
+
+	// 子任务结束后汇总协程更新批量任务状态并退出
+	updateTaskFailed("child2", "test")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := watchingBatchTasks.Load("batch2"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("batch2 watcher did not exit")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	task, _ = storage.SubtitleTaskRepo.Get("batch2")
+	if task.Status != types.SubtitleTaskStatusFailed {
+		t.Errorf("batch2 status = %d, want failed", task.Status)
+	}
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"krillin-ai/internal/dto"
+	"krillin-ai/internal/storage"
+	"krillin-ai/internal/types"
+	"krillin-ai/log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// 运行中任务的取消函数，key为taskId
+var runningTaskCancels = struct {
+	sync.Mutex
+	m map[string]context.CancelFunc
+}{m: make(map[string]context.CancelFunc)}
+
+func registerRunningTask(taskId string, cancel context.CancelFunc) {
+	runningTaskCancels.Lock()
+	defer runningTaskCancels.Unlock()
+	runningTaskCancels.m[taskId] = cancel
+}
+
+func unregisterRunningTask(taskId string) {
+	runningTaskCancels.Lock()
+	defer runningTaskCancels.Unlock()
+	delete(runningTaskCancels.m, taskId)
+}
+
+func cancelRunningTask(taskId string) bool {
+	runningTaskCancels.Lock()
+	defer runningTaskCancels.Unlock()
+	cancel, ok := runningTaskCancels.m[taskId]
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// CancelSubtitleTask 取消运行中的任务，任务ctx被取消后子进程会被kill，中间文件会被清理
+func (s Service) CancelSubtitleTask(req dto.CancelVideoSubtitleTaskReq) error {
+	task, err := storage.SubtitleTaskRepo.Get(req.TaskId)
+	if err != nil {
+		if errors.Is(err, storage.ErrSubtitleTaskNotFound) {
+			return errors.New("任务不存在")
+		}
+		log.GetLogger().Error("CancelSubtitleTask get task err", zap.String("taskId", req.TaskId), zap.Error(err))
+		return errors.New("查询任务失败")
+	}
+	if task.TaskType == types.SubtitleTaskTypeBatch {
+		return s.cancelSubtitleBatchTask(task)
+	}
+	if task.Status == types.SubtitleTaskStatusQueued && taskScheduler.remove(task.TaskId) {
+		// 还未开始执行，直接出队
+		onSubtitleTaskCancelled(&types.SubtitleTaskStepParam{
+			TaskId:       task.TaskId,
+			TaskBasePath: filepath.Join("./tasks", task.TaskId),
+		})
+		log.GetLogger().Info("CancelSubtitleTask queued task cancelled", zap.String("taskId", task.TaskId))
+		return nil
+	}
+	if task.Status != types.SubtitleTaskStatusProcessing && task.Status != types.SubtitleTaskStatusQueued {
+		return errors.New("任务不在处理中，无法取消")
+	}
+	if !cancelRunningTask(task.TaskId) {
+		return errors.New("任务未在运行，无法取消")
+	}
+	updateTaskCancelled(task.TaskId)
+	log.GetLogger().Info("CancelSubtitleTask task cancelled", zap.String("taskId", task.TaskId))
+	return nil
+}
+
+// updateTaskCancelled 标记任务已取消。取消请求和任务的收尾都会调用，只有实际改变状态的一次发布事件
+func updateTaskCancelled(taskId string) {
+	var changed bool
+	updateTask(taskId, func(task *types.SubtitleTask) {
+		if task.Status == types.SubtitleTaskStatusCancelled {
+			return
+		}
+		changed = true
+		task.Status = types.SubtitleTaskStatusCancelled
+		task.FailReason = "任务已取消"
+	})
+	if !changed {
+		return
+	}
+	publishTaskEvent(dto.SubtitleTaskEvent{
+		Type:    SubtitleTaskEventCancelled,
+		TaskId:  taskId,
+		Status:  types.SubtitleTaskStatusCancelled,
+		Message: "任务已取消",
+	})
+}
+
+// 任务被取消后的收尾：更新状态并清理任务目录下的文件
+func onSubtitleTaskCancelled(stepParam *types.SubtitleTaskStepParam) {
+	updateTaskCancelled(stepParam.TaskId)
+	if err := os.RemoveAll(stepParam.TaskBasePath); err != nil {
+		log.GetLogger().Error("onSubtitleTaskCancelled remove task dir err", zap.String("taskId", stepParam.TaskId), zap.Error(err))
+		return
+	}
+	log.GetLogger().Info("已清理被取消任务的文件", zap.String("taskId", stepParam.TaskId))
+}
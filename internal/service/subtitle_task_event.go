@@ -0,0 +1,143 @@
+package service
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"krillin-ai/internal/dto"
+	"krillin-ai/internal/storage"
+	"krillin-ai/internal/types"
+	"krillin-ai/log"
+	"sync"
+	"time"
+)
+
+const (
+	SubtitleTaskEventSnapshot           = "snapshot"
+	SubtitleTaskEventStarted            = "started"
+	SubtitleTaskEventStageStarted       = "stage_started"
+	SubtitleTaskEventStageFinished      = "stage_finished"
+	SubtitleTaskEventProgress           = "progress"
+	SubtitleTaskEventSegmentTranscribed = "segment_transcribed"
+	SubtitleTaskEventSegmentTranslated  = "segment_translated"
+	SubtitleTaskEventTtsProgress        = "tts_progress"
+	SubtitleTaskEventFfmpegProgress     = "ffmpeg_progress"
+	SubtitleTaskEventDownloadProgress   = "download_progress" // current/total为已下载和总字节数，总大小未知时total为0
+	SubtitleTaskEventSucceeded          = "succeeded"
+	SubtitleTaskEventFailed             = "failed"
+	SubtitleTaskEventCancelled          = "cancelled"
+)
+
+// 订阅者的缓冲区，消费过慢时丢弃中间事件，客户端可以依赖后续事件或重连拿到最新快照
+const subtitleTaskEventBufferSize = 64
+
+// taskEventHub 按任务分发进度事件，并保留每个运行中任务的最新状态用于重连时的快照
+type taskEventHub struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan dto.SubtitleTaskEvent]struct{}
+	latest      map[string]dto.SubtitleTaskEvent
+}
+
+var taskEvents = &taskEventHub{
+	subscribers: make(map[string]map[chan dto.SubtitleTaskEvent]struct{}),
+	latest:      make(map[string]dto.SubtitleTaskEvent),
+}
+
+func (h *taskEventHub) publish(event dto.SubtitleTaskEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// 事件只携带自己关心的字段，其余从上一条事件继承，保证每条事件都是完整的状态
+	prev := h.latest[event.TaskId]
+	if event.Status == 0 {
+		event.Status = prev.Status
+	}
+	if event.ProcessPercent == 0 {
+		event.ProcessPercent = prev.ProcessPercent
+	}
+	if event.Stage == "" {
+		event.Stage = prev.Stage
+	}
+	event.Time = time.Now().Unix()
+
+	if isSubtitleTaskEnded(event.Status) {
+		// 结束后的快照直接从任务存储构造
+		delete(h.latest, event.TaskId)
+	} else {
+		h.latest[event.TaskId] = event
+	}
+	for ch := range h.subscribers[event.TaskId] {
+		select {
+		case ch <- event:
+		default:
+			log.GetLogger().Info("taskEventHub subscriber too slow, drop event", zap.String("taskId", event.TaskId), zap.String("type", event.Type))
+		}
+	}
+}
+
+func (h *taskEventHub) subscribe(taskId string) (chan dto.SubtitleTaskEvent, *dto.SubtitleTaskEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch := make(chan dto.SubtitleTaskEvent, subtitleTaskEventBufferSize)
+	if h.subscribers[taskId] == nil {
+		h.subscribers[taskId] = make(map[chan dto.SubtitleTaskEvent]struct{})
+	}
+	h.subscribers[taskId][ch] = struct{}{}
+	var snapshot *dto.SubtitleTaskEvent
+	if latest, ok := h.latest[taskId]; ok {
+		latest.Type = SubtitleTaskEventSnapshot
+		snapshot = &latest
+	}
+	return ch, snapshot
+}
+
+func (h *taskEventHub) unsubscribe(taskId string, ch chan dto.SubtitleTaskEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers[taskId], ch)
+	if len(h.subscribers[taskId]) == 0 {
+		delete(h.subscribers, taskId)
+	}
+}
+
+func publishTaskEvent(event dto.SubtitleTaskEvent) {
+	taskEvents.publish(event)
+}
+
+func isSubtitleTaskEnded(status uint8) bool {
+	return status == types.SubtitleTaskStatusSuccess || status == types.SubtitleTaskStatusFailed ||
+		status == types.SubtitleTaskStatusInterrupted || status == types.SubtitleTaskStatusCancelled
+}
+
+// SubscribeSubtitleTaskEvents 订阅任务进度事件，返回的快照是订阅时任务的最新状态
+func (s Service) SubscribeSubtitleTaskEvents(taskId string) (events <-chan dto.SubtitleTaskEvent, snapshot *dto.SubtitleTaskEvent, unsubscribe func(), err error) {
+	// 先订阅再读取任务，读取期间发布的事件会进入订阅的通道，不会丢失
+	ch, snapshot := taskEvents.subscribe(taskId)
+	task, err := storage.SubtitleTaskRepo.Get(taskId)
+	if err != nil {
+		taskEvents.unsubscribe(taskId, ch)
+		if errors.Is(err, storage.ErrSubtitleTaskNotFound) {
+			return nil, nil, nil, errors.New("任务不存在")
+		}
+		log.GetLogger().Error("SubscribeSubtitleTaskEvents get task err", zap.String("taskId", taskId), zap.Error(err))
+		return nil, nil, nil, errors.New("查询任务失败")
+	}
+	if snapshot == nil {
+		// 任务未运行过或已结束，用存储中的状态构造快照
+		snapshot = &dto.SubtitleTaskEvent{
+			Type:           SubtitleTaskEventSnapshot,
+			TaskId:         task.TaskId,
+			Status:         task.Status,
+			ProcessPercent: task.ProcessPct,
+			Message:        task.FailReason,
+			Time:           time.Now().Unix(),
+		}
+		if task.Status == types.SubtitleTaskStatusSuccess {
+			snapshot.Result = buildSubtitleTaskResData(task)
+		}
+	}
+	return ch, snapshot, func() { taskEvents.unsubscribe(taskId, ch) }, nil
+}
+
+// IsSubtitleTaskEventFinal 判断事件之后是否还会有新的事件
+func IsSubtitleTaskEventFinal(event dto.SubtitleTaskEvent) bool {
+	return isSubtitleTaskEnded(event.Status)
+}
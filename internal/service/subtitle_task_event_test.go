@@ -0,0 +1,72 @@
+package service
+
+import (
+	"go.uber.org/zap"
+	"krillin-ai/internal/dto"
+	"krillin-ai/internal/storage"
+	"krillin-ai/internal/types"
+	"krillin-ai/log"
+	"testing"
+)
+
+func Test_SubscribeSubtitleTaskEvents(t *testing.T) {
+	log.Logger = zap.NewNop()
+	useMemoryTaskRepo(t)
+	if err := storage.SubtitleTaskRepo.Create(&types.SubtitleTask{TaskId: "task1", Status: types.SubtitleTaskStatusProcessing, ProcessPct: 20}); err != nil {
+		t.Fatal(err)
+	}
+
+	events, snapshot, unsubscribe, err := Service{}.SubscribeSubtitleTaskEvents("task1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+	if snapshot.Type != SubtitleTaskEventSnapshot || snapshot.Status != types.SubtitleTaskStatusProcessing || snapshot.ProcessPercent != 20 {
+		t.Errorf("snapshot = %+v", snapshot)
+	}
+	publishTaskEvent(dto.SubtitleTaskEvent{Type: SubtitleTaskEventProgress, TaskId: "task1", ProcessPercent: 30})
+	if event := <-events; event.Type != SubtitleTaskEventProgress || event.ProcessPercent != 30 {
+		t.Errorf("event = %+v", event)
+	}
+	publishTaskEvent(dto.SubtitleTaskEvent{Type: SubtitleTaskEventSucceeded, TaskId: "task1", Status: types.SubtitleTaskStatusSuccess, ProcessPercent: 100})
+	<-events
+
+	// 任务不存在时不保留订阅
+	if _, _, _, err = (Service{}).SubscribeSubtitleTaskEvents("missing"); err == nil {
+		t.Error("SubscribeSubtitleTaskEvents(missing) want err")
+	}
+	taskEvents.mu.Lock()
+	_, subscribed := taskEvents.subscribers["missing"]
+	taskEvents.mu.Unlock()
+	if subscribed {
+		t.Error("subscriber of missing task not removed")
+	}
+}
+
+func Test_updateTaskCancelledPublishesOnce(t *testing.T) {
+	log.Logger = zap.NewNop()
+	useMemoryTaskRepo(t)
+	if err := storage.SubtitleTaskRepo.Create(&types.SubtitleTask{TaskId: "task1", Status: types.SubtitleTaskStatusProcessing}); err != nil {
+		t.Fatal(err)
+	}
+	events, _, unsubscribe, err := Service{}.SubscribeSubtitleTaskEvents("task1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+
+	// 取消请求和任务收尾各调用一次
+	updateTaskCancelled("task1")
+	updateTaskCancelled("task1")
+	if event := <-events; event.Type != SubtitleTaskEventCancelled {
+		t.Errorf("event = %+v, want cancelled", event)
+	}
+	select {
+	case event := <-events:
+		t.Errorf("unexpected event %+v", event)
+	default:
+	}
+	if task, _ := storage.SubtitleTaskRepo.Get("task1"); task.Status != types.SubtitleTaskStatusCancelled {
+		t.Errorf("task status = %d, want cancelled", task.Status)
+	}
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"errors"
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+	"krillin-ai/internal/dto"
+	"krillin-ai/internal/storage"
+	"krillin-ai/internal/types"
+	"krillin-ai/log"
+)
+
+const (
+	listSubtitleTaskDefaultPageSize = 20
+	listSubtitleTaskMaxPageSize     = 100
+	listSubtitleTaskMaxPage         = 1000000 // 避免页码过大时偏移量溢出
+)
+
+func (s Service) ListSubtitleTasks(req dto.ListVideoSubtitleTaskReq) (*dto.ListVideoSubtitleTaskResData, error) {
+	if req.OrderBy == "" {
+		req.OrderBy = storage.SubtitleTaskOrderByCreateTime
+	}
+	if req.OrderBy != storage.SubtitleTaskOrderByCreateTime && req.OrderBy != storage.SubtitleTaskOrderByUpdateTime {
+		return nil, errors.New("不支持的排序字段")
+	}
+	if req.Order != "" && req.Order != "asc" && req.Order != "desc" {
+		return nil, errors.New("不支持的排序方式")
+	}
+	if req.StartTime != 0 && req.EndTime != 0 && req.StartTime > req.EndTime {
+		return nil, errors.New("时间范围不合法")
+	}
+	if req.Page <= 0 {
+		req.Page = 1
+	}
+	if req.Page > listSubtitleTaskMaxPage {
+		req.Page = listSubtitleTaskMaxPage
+	}
+	if req.PageSize <= 0 {
+		req.PageSize = listSubtitleTaskDefaultPageSize
+	}
+	if req.PageSize > listSubtitleTaskMaxPageSize {
+		req.PageSize = listSubtitleTaskMaxPageSize
+	}
+
+	tasks, total, err := storage.SubtitleTaskRepo.List(storage.SubtitleTaskQuery{
+		Status:    req.Status,
+		Language:  req.Language,
+		StartTime: req.StartTime,
+		EndTime:   req.EndTime,
+		OrderBy:   req.OrderBy,
+		Desc:      req.Order != "asc",
+		Offset:    (req.Page - 1) * req.PageSize,
+		Limit:     req.PageSize,
+	})
+	if err != nil {
+		log.GetLogger().Error("ListSubtitleTasks list err", zap.Any("req", req), zap.Error(err))
+		return nil, errors.New("查询任务列表失败")
+	}
+
+	return &dto.ListVideoSubtitleTaskResData{
+		Total:    total,
+		Page:     req.Page,
+		PageSize: req.PageSize,
+		List: lo.Map(tasks, func(task *types.SubtitleTask, _ int) *dto.SubtitleTaskSummary {
+			return &dto.SubtitleTaskSummary{
+				TaskId:         task.TaskId,
+				VideoSrc:       task.VideoSrc,
+				OriginLanguage: task.OriginLanguage,
+				TargetLanguage: task.TargetLanguage,
+				Status:         task.Status,
+				ProcessPercent: task.ProcessPct,
+				FailReason:     task.FailReason,
+				TaskType:       task.TaskType,
+				ParentTaskId:   task.ParentTaskId,
+				CreateTime:     task.CreateTime,
+				UpdateTime:     task.UpdateTime,
+			}
+		}),
+	}, nil
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"krillin-ai/internal/dto"
+	"krillin-ai/internal/storage"
+	"krillin-ai/internal/types"
+	"krillin-ai/log"
+	"os"
+	"path/filepath"
+)
+
+// ResumeSubtitleTask 从失败或中断任务的最后一个成功步骤之后继续执行，复用任务目录下的中间文件
+func (s Service) ResumeSubtitleTask(req dto.ResumeVideoSubtitleTaskReq) (*dto.StartVideoSubtitleTaskResData, error) {
+	task, err := storage.SubtitleTaskRepo.Get(req.TaskId)
+	if err != nil {
+		if errors.Is(err, storage.ErrSubtitleTaskNotFound) {
+			return nil, errors.New("任务不存在")
+		}
+		log.GetLogger().Error("ResumeSubtitleTask get task err", zap.String("taskId", req.TaskId), zap.Error(err))
+		return nil, errors.New("查询任务失败")
+	}
+	if task.Status != types.SubtitleTaskStatusFailed && task.Status != types.SubtitleTaskStatusInterrupted {
+		return nil, errors.New("只有失败或中断的任务可以恢复")
+	}
+
+	stepParam, err := loadStepParam(filepath.Join("./tasks", task.TaskId))
+	if err != nil {
+		log.GetLogger().Error("ResumeSubtitleTask loadStepParam err", zap.String("taskId", task.TaskId), zap.Error(err))
+		return nil, errors.New("任务断点不存在，无法恢复")
+	}
+	steps, err := s.buildSubtitleTaskSteps(stepParam.StepNames, subtitleTaskInitialArtifacts(stepParam.Link, stepParam.SubtitleSourcePath))
+	if err != nil {
+		log.GetLogger().Error("ResumeSubtitleTask buildSubtitleTaskSteps err", zap.String("taskId", task.TaskId), zap.Error(err))
+		return nil, errors.New("任务步骤不合法，无法恢复")
+	}
+	startStepNum := int(task.LastSuccessStepNum) + 1
+	if startStepNum > len(steps) {
+		return nil, errors.New("任务所有步骤均已完成，无需恢复")
+	}
+
+	// 检查状态和重置任务在同一次更新中完成，同时恢复同一个任务时只有一个请求能成功
+	var resumed bool
+	err = storage.SubtitleTaskRepo.Update(task.TaskId, func(task *types.SubtitleTask) {
+		if task.Status != types.SubtitleTaskStatusFailed && task.Status != types.SubtitleTaskStatusInterrupted {
+			return
+		}
+		task.Status = types.SubtitleTaskStatusQueued
+		task.FailReason = ""
+		// 进度回到已完成步骤的比例，中断步骤的时间线记录丢弃后重新计时
+		task.ProcessPct = uint8((startStepNum - 1) * 100 / len(steps))
+		task.Timeline = trimInterruptedTimeline(task.Timeline)
+		resumed = true
+	})
+	if err != nil {
+		log.GetLogger().Error("ResumeSubtitleTask update task err", zap.String("taskId", task.TaskId), zap.Error(err))
+		return nil, errors.New("恢复任务失败")
+	}
+	if !resumed {
+		return nil, errors.New("任务状态已变化，无法恢复")
+	}
+	log.GetLogger().Info("ResumeSubtitleTask resume task", zap.String("taskId", task.TaskId), zap.Int("start step", startStepNum))
+	taskScheduler.enqueue(task.TaskId, 0, func(ctx context.Context) {
+		s.runSubtitleTask(ctx, stepParam, startStepNum)
+	})
+	// 所属批量任务可能已汇总为结束，需要重新跟踪子任务状态
+	if task.ParentTaskId != "" {
+		s.startSubtitleBatchWatcher(task.ParentTaskId)
+	}
+
+	return &dto.StartVideoSubtitleTaskResData{
+		TaskId: task.TaskId,
+	}, nil
+}
+
+// saveStepParam 保存步骤参数作为断点
+func saveStepParam(stepParam *types.SubtitleTaskStepParam) error {
+	target := filepath.Join(stepParam.TaskBasePath, types.SubtitleTaskStepParamGobPersistenceFileName)
+	tmp := target + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("saveStepParam create file err: %w", err)
+	}
+	if err = gob.NewEncoder(file).Encode(stepParam); err != nil {
+		file.Close()
+		return fmt.Errorf("saveStepParam encode err: %w", err)
+	}
+	if err = file.Close(); err != nil {
+		return fmt.Errorf("saveStepParam close file err: %w", err)
+	}
+	if err = os.Rename(tmp, target); err != nil {
+		return fmt.Errorf("saveStepParam rename err: %w", err)
+	}
+	return nil
+}
+
+func loadStepParam(taskBasePath string) (*types.SubtitleTaskStepParam, error) {
+	file, err := os.Open(filepath.Join(taskBasePath, types.SubtitleTaskStepParamGobPersistenceFileName))
+	if err != nil {
+		return nil, fmt.Errorf("loadStepParam open file err: %w", err)
+	}
+	defer file.Close()
+	var stepParam types.SubtitleTaskStepParam
+	if err = gob.NewDecoder(file).Decode(&stepParam); err != nil {
+		return nil, fmt.Errorf("loadStepParam decode err: %w", err)
+	}
+	return &stepParam, nil
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"krillin-ai/config"
+	"krillin-ai/internal/dto"
+	"krillin-ai/internal/storage"
+	"krillin-ai/internal/types"
+	"krillin-ai/log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_ResumeSubtitleTask(t *testing.T) {
+	log.Logger = zap.NewNop()
+	chdirTemp(t)
+	useMemoryTaskRepo(t)
+	// 只有一个worker，先用一个阻塞的任务占住，便于检查恢复后排队中的任务
+	originScheduler, originWorkerNum := taskScheduler, config.Conf.App.MaxConcurrentTasks
+	taskScheduler, config.Conf.App.MaxConcurrentTasks = newSubtitleTaskScheduler(), 1
+	t.Cleanup(func() { taskScheduler, config.Conf.App.MaxConcurrentTasks = originScheduler, originWorkerNum })
+	release := make(chan struct{})
+	taskScheduler.enqueue("blocker", 0, func(ctx context.Context) { <-release })
+
+	// 导入字幕后配音已完成，上传字幕步骤失败
+	taskBasePath := filepath.Join(taskWorkspaceRoot, "task1")
+	if err := os.MkdirAll(taskBasePath, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	err := saveStepParam(&types.SubtitleTaskStepParam{
+		TaskId:             "task1",
+		TaskBasePath:       taskBasePath,
+		SubtitleSourcePath: filepath.Join(taskBasePath, "origin.srt"),
+		SubtitleInfos:      []types.SubtitleFileInfo{{Name: "bilingual.srt", Path: filepath.Join(taskBasePath, "bilingual.srt")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage.SubtitleTaskRepo.Create(&types.SubtitleTask{
+		TaskId:             "task1",
+		Status:             types.SubtitleTaskStatusFailed,
+		FailReason:         "upload failed",
+		ProcessPct:         95,
+		LastSuccessStepNum: 2,
+		Timeline: []types.TimelineEntry{
+			{Kind: types.TimelineKindStep, Name: "importSubtitle"},
+			{Kind: types.TimelineKindTts, Name: "srtFileToSpeech", Segment: 1},
+			{Kind: types.TimelineKindStep, Name: "srtFileToSpeech"},
+			{Kind: types.TimelineKindStep, Name: "uploadSubtitles", Error: "upload failed"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = (Service{}).ResumeSubtitleTask(dto.ResumeVideoSubtitleTaskReq{TaskId: "task1"}); err != nil {
+		t.Fatalf("ResumeSubtitleTask() err = %v", err)
+	}
+	task, _ := storage.SubtitleTaskRepo.Get("task1")
+	if task.Status != types.SubtitleTaskStatusQueued || task.FailReason != "" || task.ProcessPct != 66 || len(task.Timeline) != 3 {
+		t.Errorf("resumed task = status %d, fail reason %q, percent %d, timeline %d, want queued, empty, 66, 3",
+			task.Status, task.FailReason, task.ProcessPct, len(task.Timeline))
+	}
+	// 重复恢复时状态已变化
+	if _, err = (Service{}).ResumeSubtitleTask(dto.ResumeVideoSubtitleTaskReq{TaskId: "task1"}); err == nil {
+		t.Error("ResumeSubtitleTask() twice err = nil")
+	}
+
+	// 只有一个worker，之后入队的任务运行时恢复的任务已经执行完
+	finished := make(chan struct{})
+	taskScheduler.enqueue("after", 0, func(ctx context.Context) { close(finished) })
+	close(release)
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("resumed task not finished")
+	}
+	task, _ = storage.SubtitleTaskRepo.Get("task1")
+	if task.Status != types.SubtitleTaskStatusSuccess || task.LastSuccessStepNum != 3 {
+		t.Fatalf("task = status %d, fail reason %q, last step %d, want success at step 3", task.Status, task.FailReason, task.LastSuccessStepNum)
+	}
+	// 从第三步开始执行，前两步没有重新运行
+	names := make([]string, 0, len(task.Timeline))
+	for _, entry := range task.Timeline {
+		if entry.Kind == types.TimelineKindStep {
+			names = append(names, entry.Name)
+		}
+	}
+	if want := []string{"importSubtitle", "srtFileToSpeech", "uploadSubtitles"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("timeline steps = %v, want %v", names, want)
+	}
+	if last := task.Timeline[len(task.Timeline)-1]; last.Error != "" {
+		t.Errorf("last timeline entry = %+v, want no error", last)
+	}
+}
+
+func Test_trimInterruptedTimeline(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeline []types.TimelineEntry
+		want     int
+	}{
+		{"空", nil, 0},
+		{"第一步失败", []types.TimelineEntry{
+			{Kind: types.TimelineKindTranscribe, Name: "audioToSrt", Segment: 1},
+			{Kind: types.TimelineKindStep, Name: "audioToSubtitle", Error: "err"},
+		}, 0},
+		{"中断时没有步骤记录", []types.TimelineEntry{
+			{Kind: types.TimelineKindStep, Name: "linkToFile"},
+			{Kind: types.TimelineKindTranscribe, Name: "audioToSrt", Segment: 1},
+		}, 1},
+		{"失败步骤的分段记录", []types.TimelineEntry{
+			{Kind: types.TimelineKindStep, Name: "linkToFile"},
+			{Kind: types.TimelineKindTranscribe, Name: "audioToSrt", Segment: 1, Error: "err"},
+			{Kind: types.TimelineKindStep, Name: "audioToSubtitle", Error: "err"},
+		}, 1},
+	}
+	for _, tt := range tests {
+		if got := trimInterruptedTimeline(tt.timeline); len(got) != tt.want {
+			t.Errorf("%s: trimInterruptedTimeline() = %d entries, want %d", tt.name, len(got), tt.want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"container/heap"
+	"context"
+	"go.uber.org/zap"
+	"krillin-ai/config"
+	"krillin-ai/log"
+	"sync"
+)
+
+// 排队中的任务，priority越大越先执行，相同优先级按入队顺序执行
+type queuedTask struct {
+	taskId   string
+	priority int
+	seq      uint64
+	run      func(ctx context.Context)
+	index    int
+}
+
+func (t *queuedTask) before(other *queuedTask) bool {
+	if t.priority != other.priority {
+		return t.priority > other.priority
+	}
+	return t.seq < other.seq
+}
+
+type taskQueue []*queuedTask
+
+func (q taskQueue) Len() int           { return len(q) }
+func (q taskQueue) Less(i, j int) bool { return q[i].before(q[j]) }
+func (q taskQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *taskQueue) Push(x any) {
+	item := x.(*queuedTask)
+	item.index = len(*q)
+	*q = append(*q, item)
+}
+
+func (q *taskQueue) Pop() any {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*q = old[:n-1]
+	return item
+}
+
+// subtitleTaskScheduler 限制同时运行的任务数量，超出的任务排队等待
+type subtitleTaskScheduler struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	queue taskQueue
+	items map[string]*queuedTask
+	seq   uint64
+	once  sync.Once
+}
+
+var taskScheduler = newSubtitleTaskScheduler()
+
+func newSubtitleTaskScheduler() *subtitleTaskScheduler {
+	sch := &subtitleTaskScheduler{
+		items: make(map[string]*queuedTask),
+	}
+	sch.cond = sync.NewCond(&sch.mu)
+	return sch
+}
+
+// 首次入队时按配置启动worker，保证读取到的是加载后的配置
+func (sch *subtitleTaskScheduler) start() {
+	sch.once.Do(func() {
+		workerNum := config.Conf.App.MaxConcurrentTasks
+		if workerNum <= 0 {
+			workerNum = 1
+		}
+		for i := 0; i < workerNum; i++ {
+			go sch.work()
+		}
+		log.GetLogger().Info("任务调度器启动", zap.Int("worker num", workerNum))
+	})
+}
+
+func (sch *subtitleTaskScheduler) work() {
+	for {
+		sch.mu.Lock()
+		for len(sch.queue) == 0 {
+			sch.cond.Wait()
+		}
+		item := heap.Pop(&sch.queue).(*queuedTask)
+		delete(sch.items, item.taskId)
+		// 出队的同时注册取消函数，任务离开队列后到开始运行前也可以取消
+		ctx, cancel := context.WithCancel(context.Background())
+		registerRunningTask(item.taskId, cancel)
+		sch.mu.Unlock()
+
+		item.run(ctx)
+		unregisterRunningTask(item.taskId)
+		cancel()
+	}
+}
+
+// enqueue 任务出队后在worker中执行run，ctx在任务被取消时取消
+func (sch *subtitleTaskScheduler) enqueue(taskId string, priority int, run func(ctx context.Context)) {
+	sch.start()
+	sch.mu.Lock()
+	defer sch.mu.Unlock()
+	sch.seq++
+	item := &queuedTask{
+		taskId:   taskId,
+		priority: priority,
+		seq:      sch.seq,
+		run:      run,
+	}
+	heap.Push(&sch.queue, item)
+	sch.items[taskId] = item
+	sch.cond.Signal()
+}
+
+// remove 把还未开始执行的任务移出队列，任务已被worker取走时返回false
+func (sch *subtitleTaskScheduler) remove(taskId string) bool {
+	sch.mu.Lock()
+	defer sch.mu.Unlock()
+	item, ok := sch.items[taskId]
+	if !ok {
+		return false
+	}
+	heap.Remove(&sch.queue, item.index)
+	delete(sch.items, taskId)
+	return true
+}
+
+func (sch *subtitleTaskScheduler) depth() int {
+	sch.mu.Lock()
+	defer sch.mu.Unlock()
+	return len(sch.queue)
+}
+
+// position 返回任务在队列中的位置，从1开始，不在队列中返回0
+func (sch *subtitleTaskScheduler) position(taskId string) int {
+	sch.mu.Lock()
+	defer sch.mu.Unlock()
+	item, ok := sch.items[taskId]
+	if !ok {
+		return 0
+	}
+	pos := 1
+	for _, other := range sch.queue {
+		if other != item && other.before(item) {
+			pos++
+		}
+	}
+	return pos
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"krillin-ai/internal/dto"
+	"krillin-ai/internal/storage"
+	"krillin-ai/internal/types"
+	"krillin-ai/log"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_subtitleTaskSchedulerCancelBeforeRun(t *testing.T) {
+	log.Logger = zap.NewNop()
+	sch := newSubtitleTaskScheduler()
+	type result struct {
+		registered bool
+		ctxErr     error
+	}
+	done := make(chan result, 1)
+	// 任务开始执行时取消函数已注册，取消后ctx立即结束
+	sch.enqueue("task1", 0, func(ctx context.Context) {
+		registered := cancelRunningTask("task1")
+		done <- result{registered: registered, ctxErr: ctx.Err()}
+	})
+	select {
+	case res := <-done:
+		if !res.registered || res.ctxErr != context.Canceled {
+			t.Errorf("task registered = %v, ctx err = %v, want registered and context.Canceled", res.registered, res.ctxErr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("task not run")
+	}
+	// 运行结束后注销
+	for i := 0; cancelRunningTask("task1"); i++ {
+		if i >= 100 {
+			t.Fatal("cancel func still registered after task finished")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_CancelSubtitleTaskAfterDequeue(t *testing.T) {
+	log.Logger = zap.NewNop()
+	chdirTemp(t)
+	useMemoryTaskRepo(t)
+	for _, taskId := range []string{"task1", "task2"} {
+		if err := storage.SubtitleTaskRepo.Create(&types.SubtitleTask{TaskId: taskId, Status: types.SubtitleTaskStatusQueued}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sch := newSubtitleTaskScheduler()
+	done := make(chan error, 1)
+	sch.enqueue("task1", 0, func(ctx context.Context) {
+		// 已出队但还未开始运行时取消
+		err := Service{}.CancelSubtitleTask(dto.CancelVideoSubtitleTaskReq{TaskId: "task1"})
+		Service{}.runSubtitleTask(ctx, &types.SubtitleTaskStepParam{TaskId: "task1", TaskBasePath: filepath.Join(taskWorkspaceRoot, "task1")}, 1)
+		done <- err
+	})
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("CancelSubtitleTask() err = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("task not run")
+	}
+	if task, _ := storage.SubtitleTaskRepo.Get("task1"); task.Status != types.SubtitleTaskStatusCancelled {
+		t.Errorf("task1 status = %d, want cancelled", task.Status)
+	}
+
+	// 状态已不是排队中时不会被改回处理中
+	updateTaskCancelled("task2")
+	Service{}.runSubtitleTask(context.Background(), &types.SubtitleTaskStepParam{TaskId: "task2", TaskBasePath: filepath.Join(taskWorkspaceRoot, "task2")}, 1)
+	if task, _ := storage.SubtitleTaskRepo.Get("task2"); task.Status != types.SubtitleTaskStatusCancelled {
+		t.Errorf("task2 status = %d, want cancelled", task.Status)
+	}
+}
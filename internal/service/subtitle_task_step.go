@@ -0,0 +1,198 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"krillin-ai/internal/types"
+	"strings"
+)
+
+// stepArtifact 步骤之间传递的产物，对应SubtitleTaskStepParam中由步骤填充的字段
+type stepArtifact string
+
+const (
+	artifactLink          stepArtifact = "link"           // Link，任务创建时即存在
+	artifactSubtitleSrc   stepArtifact = "subtitle_src"   // SubtitleSourcePath，导入字幕时任务创建即存在
+	artifactAudio         stepArtifact = "audio"          // AudioFilePath
+	artifactInputVideo    stepArtifact = "input_video"    // InputVideoPath
+	artifactVideoInfo     stepArtifact = "video_info"     // 任务的标题、描述、时长、封面等元数据
+	artifactVideoInfoTr   stepArtifact = "video_info_tr"  // 任务翻译后的标题、描述
+	artifactChapters      stepArtifact = "chapters"       // 翻译后的章节标题和output目录下的章节文件
+	artifactBilingualSrt  stepArtifact = "bilingual_srt"  // BilingualSrtFilePath
+	artifactSubtitleFiles stepArtifact = "subtitle_files" // SubtitleInfos
+	artifactTtsSource     stepArtifact = "tts_source"     // TtsSourceFilePath
+	artifactTtsAudio      stepArtifact = "tts_audio"      // TtsResultFilePath
+	artifactEmbedVideo    stepArtifact = "embed_video"    // output目录下的视频
+	artifactResult        stepArtifact = "result"         // 任务结果已写入任务记录
+)
+
+// subtitleTaskInitialArtifacts 任务创建时就具备的产物
+func subtitleTaskInitialArtifacts(link, subtitleSourcePath string) []stepArtifact {
+	artifacts := make([]stepArtifact, 0, 2)
+	if link != "" {
+		artifacts = append(artifacts, artifactLink)
+	}
+	if subtitleSourcePath != "" {
+		artifacts = append(artifacts, artifactSubtitleSrc)
+	}
+	return artifacts
+}
+
+type subtitleTaskStep struct {
+	Name    string
+	Inputs  []stepArtifact
+	Outputs []stepArtifact
+	Run     func(ctx context.Context, stepParam *types.SubtitleTaskStepParam) error
+}
+
+// 新版流程：链接->本地音频文件->视频信息获取->章节->本地字幕文件->语言合成->视频合成->字幕文件链接生成
+// 标题和描述的翻译translateVideoInfo需要在steps中指定
+var defaultSubtitleTaskStepNames = []string{
+	"linkToFile",
+	"getVideoInfo",
+	"generateChapters",
+	"audioToSubtitle",
+	"srtFileToSpeech",
+	"embedSubtitles",
+	"uploadSubtitles",
+}
+
+// 导入已有字幕时用字幕代替转录，没有视频时只生成字幕和配音
+var (
+	importSubtitleTaskStepNames = []string{
+		"linkToFile",
+		"getVideoInfo",
+		"generateChapters",
+		"importSubtitle",
+		"srtFileToSpeech",
+		"embedSubtitles",
+		"uploadSubtitles",
+	}
+	importSubtitleOnlyTaskStepNames = []string{
+		"importSubtitle",
+		"srtFileToSpeech",
+		"uploadSubtitles",
+	}
+)
+
+// 最后一步必须产出任务结果，否则任务无法进入成功状态
+const finalSubtitleTaskStepOutput = artifactResult
+
+func (s Service) subtitleTaskStepRegistry() map[string]subtitleTaskStep {
+	steps := []subtitleTaskStep{
+		{
+			Name:    "linkToFile",
+			Inputs:  []stepArtifact{artifactLink},
+			Outputs: []stepArtifact{artifactAudio, artifactInputVideo},
+			Run:     s.linkToFile,
+		},
+		{
+			Name:    "getVideoInfo",
+			Inputs:  []stepArtifact{artifactLink},
+			Outputs: []stepArtifact{artifactVideoInfo},
+			Run:     s.getVideoInfo,
+		},
+		{
+			Name:    "translateVideoInfo",
+			Inputs:  []stepArtifact{artifactVideoInfo},
+			Outputs: []stepArtifact{artifactVideoInfoTr},
+			Run:     s.translateVideoInfo,
+		},
+		{
+			Name:    "generateChapters",
+			Inputs:  []stepArtifact{artifactVideoInfo},
+			Outputs: []stepArtifact{artifactChapters},
+			Run:     s.generateChapters,
+		},
+		{
+			Name:    "audioToSubtitle",
+			Inputs:  []stepArtifact{artifactAudio},
+			Outputs: []stepArtifact{artifactBilingualSrt, artifactSubtitleFiles, artifactTtsSource},
+			Run:     s.audioToSubtitle,
+		},
+		{
+			Name:    "importSubtitle",
+			Inputs:  []stepArtifact{artifactSubtitleSrc},
+			Outputs: []stepArtifact{artifactBilingualSrt, artifactSubtitleFiles, artifactTtsSource},
+			Run:     s.importSubtitle,
+		},
+		{
+			Name:    "srtFileToSpeech",
+			Inputs:  []stepArtifact{artifactTtsSource},
+			Outputs: []stepArtifact{artifactTtsAudio},
+			Run:     s.srtFileToSpeech,
+		},
+		{
+			Name:    "embedSubtitles",
+			Inputs:  []stepArtifact{artifactInputVideo, artifactBilingualSrt},
+			Outputs: []stepArtifact{artifactEmbedVideo},
+			Run:     s.embedSubtitles,
+		},
+		{
+			Name:    "uploadSubtitles",
+			Inputs:  []stepArtifact{artifactSubtitleFiles},
+			Outputs: []stepArtifact{artifactResult},
+			Run:     s.uploadSubtitles,
+		},
+	}
+	registry := make(map[string]subtitleTaskStep, len(steps))
+	for _, step := range steps {
+		registry[step.Name] = step
+	}
+	return registry
+}
+
+// buildSubtitleTaskSteps 按名称组装步骤，并检查每个步骤的输入都能由任务创建时的产物或之前的步骤提供
+func (s Service) buildSubtitleTaskSteps(names []string, initial []stepArtifact) ([]subtitleTaskStep, error) {
+	available := make(map[stepArtifact]bool)
+	for _, artifact := range initial {
+		available[artifact] = true
+	}
+	if len(names) == 0 {
+		switch {
+		case available[artifactSubtitleSrc] && available[artifactLink]:
+			names = importSubtitleTaskStepNames
+		case available[artifactSubtitleSrc]:
+			names = importSubtitleOnlyTaskStepNames
+		default:
+			names = defaultSubtitleTaskStepNames
+		}
+	}
+	registry := s.subtitleTaskStepRegistry()
+	used := make(map[string]bool)
+	steps := make([]subtitleTaskStep, 0, len(names))
+	for _, name := range names {
+		step, ok := registry[name]
+		if !ok {
+			return nil, fmt.Errorf("不支持的步骤：%s", name)
+		}
+		if used[name] {
+			return nil, fmt.Errorf("步骤重复：%s", name)
+		}
+		used[name] = true
+		missing := make([]string, 0)
+		for _, input := range step.Inputs {
+			if !available[input] {
+				missing = append(missing, string(input))
+			}
+		}
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("步骤%s缺少输入：%s", name, strings.Join(missing, ","))
+		}
+		for _, output := range step.Outputs {
+			available[output] = true
+		}
+		steps = append(steps, step)
+	}
+	last := steps[len(steps)-1]
+	producesResult := false
+	for _, output := range last.Outputs {
+		if output == finalSubtitleTaskStepOutput {
+			producesResult = true
+		}
+	}
+	if !producesResult {
+		return nil, fmt.Errorf("最后一个步骤必须生成任务结果，当前为：%s", last.Name)
+	}
+	return steps, nil
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_buildSubtitleTaskSteps(t *testing.T) {
+	tests := []struct {
+		name      string
+		names     []string
+		initial   []stepArtifact
+		wantSteps []string
+		wantErr   string
+	}{
+		{
+			name:      "默认步骤",
+			initial:   subtitleTaskInitialArtifacts("https://www.youtube.com/watch?v=dQw4w9WgXcQ", ""),
+			wantSteps: defaultSubtitleTaskStepNames,
+		},
+		{
+			name:      "导入字幕",
+			initial:   subtitleTaskInitialArtifacts("local:./uploads/a.mp4", "local:./uploads/a.srt"),
+			wantSteps: importSubtitleTaskStepNames,
+		},
+		{
+			name:      "只导入字幕",
+			initial:   subtitleTaskInitialArtifacts("", "local:./uploads/a.srt"),
+			wantSteps: importSubtitleOnlyTaskStepNames,
+		},
+		{
+			name:      "指定步骤",
+			names:     []string{"linkToFile", "getVideoInfo", "translateVideoInfo", "audioToSubtitle", "uploadSubtitles"},
+			initial:   []stepArtifact{artifactLink},
+			wantSteps: []string{"linkToFile", "getVideoInfo", "translateVideoInfo", "audioToSubtitle", "uploadSubtitles"},
+		},
+		{
+			name:    "步骤重复",
+			names:   []string{"linkToFile", "audioToSubtitle", "audioToSubtitle", "uploadSubtitles"},
+			initial: []stepArtifact{artifactLink},
+			wantErr: "步骤重复：audioToSubtitle",
+		},
+		{
+			name:    "不支持的步骤",
+			names:   []string{"linkToFile", "unknown", "uploadSubtitles"},
+			initial: []stepArtifact{artifactLink},
+			wantErr: "不支持的步骤：unknown",
+		},
+		{
+			name:    "缺少输入",
+			names:   []string{"linkToFile", "uploadSubtitles"},
+			initial: []stepArtifact{artifactLink},
+			wantErr: "步骤uploadSubtitles缺少输入：subtitle_files",
+		},
+		{
+			name:    "没有视频时嵌入字幕",
+			names:   []string{"importSubtitle", "embedSubtitles", "uploadSubtitles"},
+			initial: []stepArtifact{artifactSubtitleSrc},
+			wantErr: "步骤embedSubtitles缺少输入：input_video",
+		},
+		{
+			name:    "没有链接",
+			names:   []string{"linkToFile", "audioToSubtitle", "uploadSubtitles"},
+			initial: nil,
+			wantErr: "步骤linkToFile缺少输入：link",
+		},
+		{
+			name:    "最后一步不生成结果",
+			names:   []string{"linkToFile", "audioToSubtitle", "uploadSubtitles", "embedSubtitles"},
+			initial: []stepArtifact{artifactLink},
+			wantErr: "最后一个步骤必须生成任务结果，当前为：embedSubtitles",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps, err := Service{}.buildSubtitleTaskSteps(tt.names, tt.initial)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("buildSubtitleTaskSteps() err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("buildSubtitleTaskSteps() err = %v", err)
+			}
+			names := make([]string, 0, len(steps))
+			for _, step := range steps {
+				if step.Run == nil {
+					t.Errorf("step %s has no Run", step.Name)
+				}
+				names = append(names, step.Name)
+			}
+			if !reflect.DeepEqual(names, tt.wantSteps) {
+				t.Errorf("buildSubtitleTaskSteps() = %v, want %v", names, tt.wantSteps)
+			}
+		})
+	}
+}
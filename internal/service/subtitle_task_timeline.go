@@ -0,0 +1,92 @@
+package service
+
+import (
+	"krillin-ai/internal/types"
+	"sync"
+	"time"
+)
+
+// 单个任务最多保留的时间线记录数，避免字幕很多时任务记录过大
+const timelineMaxEntries = 2000
+
+// 分段的记录先缓存在内存中，所在步骤结束时和步骤记录一起写入任务，避免每个分段都重写一次任务记录
+var pendingTimelines = struct {
+	sync.Mutex
+	m map[string][]types.TimelineEntry
+}{m: make(map[string][]types.TimelineEntry)}
+
+type timelineSpan struct {
+	taskId string
+	entry  types.TimelineEntry
+	start  time.Time
+}
+
+func startTimelineSpan(taskId, kind, name string, segment int) *timelineSpan {
+	now := time.Now()
+	return &timelineSpan{
+		taskId: taskId,
+		entry: types.TimelineEntry{
+			Kind:      kind,
+			Name:      name,
+			Segment:   segment,
+			StartTime: now.UnixMilli(),
+		},
+		start: now,
+	}
+}
+
+// finish 结束计时，attempts为实际尝试次数，err为最终结果。步骤级的记录结束时把缓存的分段记录一起写入任务
+func (sp *timelineSpan) finish(attempts int, err error) {
+	now := time.Now()
+	entry := sp.entry
+	entry.EndTime = now.UnixMilli()
+	entry.DurationMs = now.Sub(sp.start).Milliseconds()
+	entry.Attempts = attempts
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if entry.Kind != types.TimelineKindStep {
+		appendPendingTimeline(sp.taskId, entry)
+		return
+	}
+	entries := append(takePendingTimeline(sp.taskId), entry)
+	updateTask(sp.taskId, func(task *types.SubtitleTask) {
+		task.Timeline = appendTimelineEntries(task.Timeline, entries)
+	})
+}
+
+func appendPendingTimeline(taskId string, entry types.TimelineEntry) {
+	pendingTimelines.Lock()
+	defer pendingTimelines.Unlock()
+	if len(pendingTimelines.m[taskId]) >= timelineMaxEntries {
+		return
+	}
+	pendingTimelines.m[taskId] = append(pendingTimelines.m[taskId], entry)
+}
+
+// takePendingTimeline 取出并清空任务缓存的分段记录，任务异常结束时也用于丢弃未写入的记录
+func takePendingTimeline(taskId string) []types.TimelineEntry {
+	pendingTimelines.Lock()
+	defer pendingTimelines.Unlock()
+	entries := pendingTimelines.m[taskId]
+	delete(pendingTimelines.m, taskId)
+	return entries
+}
+
+// appendTimelineEntries 按结束顺序追加，超出上限的记录丢弃
+func appendTimelineEntries(timeline, entries []types.TimelineEntry) []types.TimelineEntry {
+	if remain := timelineMaxEntries - len(timeline); remain < len(entries) {
+		entries = entries[:max(remain, 0)]
+	}
+	return append(timeline, entries...)
+}
+
+// trimInterruptedTimeline 去掉最后一个成功步骤之后的记录，即失败或中断的步骤及其分段记录
+func trimInterruptedTimeline(timeline []types.TimelineEntry) []types.TimelineEntry {
+	for i := len(timeline) - 1; i >= 0; i-- {
+		if timeline[i].Kind == types.TimelineKindStep && timeline[i].Error == "" {
+			return timeline[:i+1]
+		}
+	}
+	return nil
+}
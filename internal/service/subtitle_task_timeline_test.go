@@ -0,0 +1,75 @@
+package service
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"krillin-ai/internal/storage"
+	"krillin-ai/internal/types"
+	"krillin-ai/log"
+	"testing"
+)
+
+func Test_timelineSpanFinish(t *testing.T) {
+	log.Logger = zap.NewNop()
+	useMemoryTaskRepo(t)
+	if err := storage.SubtitleTaskRepo.Create(&types.SubtitleTask{TaskId: "task1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	step := startTimelineSpan("task1", types.TimelineKindStep, "audioToSubtitle", 0)
+	first := startTimelineSpan("task1", types.TimelineKindTranscribe, "audioToSrt", 1)
+	second := startTimelineSpan("task1", types.TimelineKindTranscribe, "audioToSrt", 2)
+	second.finish(1, nil)
+	first.finish(3, errors.New("timeout"))
+
+	// 分段记录在步骤结束前不写入任务
+	task, _ := storage.SubtitleTaskRepo.Get("task1")
+	if len(task.Timeline) != 0 {
+		t.Fatalf("timeline written before step finished: %+v", task.Timeline)
+	}
+	step.finish(1, nil)
+
+	task, _ = storage.SubtitleTaskRepo.Get("task1")
+	if len(task.Timeline) != 3 {
+		t.Fatalf("timeline = %+v, want 3 entries", task.Timeline)
+	}
+	// 按结束顺序排列，步骤记录在最后
+	if got := task.Timeline; got[0].Segment != 2 || got[1].Segment != 1 || got[1].Attempts != 3 || got[1].Error != "timeout" ||
+		got[2].Kind != types.TimelineKindStep || got[2].Name != "audioToSubtitle" {
+		t.Errorf("timeline = %+v", got)
+	}
+	if pending := takePendingTimeline("task1"); len(pending) != 0 {
+		t.Errorf("pending timeline = %+v, want empty", pending)
+	}
+}
+
+func Test_timelineMaxEntries(t *testing.T) {
+	log.Logger = zap.NewNop()
+	useMemoryTaskRepo(t)
+	if err := storage.SubtitleTaskRepo.Create(&types.SubtitleTask{
+		TaskId:   "task1",
+		Timeline: make([]types.TimelineEntry, timelineMaxEntries-1),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	step := startTimelineSpan("task1", types.TimelineKindStep, "srtFileToSpeech", 0)
+	for i := 1; i <= timelineMaxEntries+10; i++ {
+		startTimelineSpan("task1", types.TimelineKindTts, "srtFileToSpeech", i).finish(1, nil)
+	}
+	pendingTimelines.Lock()
+	pending := len(pendingTimelines.m["task1"])
+	pendingTimelines.Unlock()
+	if pending != timelineMaxEntries {
+		t.Errorf("pending timeline entries = %d, want %d", pending, timelineMaxEntries)
+	}
+	step.finish(1, nil)
+
+	task, _ := storage.SubtitleTaskRepo.Get("task1")
+	if len(task.Timeline) != timelineMaxEntries {
+		t.Fatalf("timeline entries = %d, want %d", len(task.Timeline), timelineMaxEntries)
+	}
+	if last := task.Timeline[len(task.Timeline)-1]; last.Kind != types.TimelineKindTts || last.Segment != 1 {
+		t.Errorf("last entry = %+v, want tts segment 1", last)
+	}
+}
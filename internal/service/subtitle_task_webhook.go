@@ -0,0 +1,170 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+	"krillin-ai/internal/dto"
+	"krillin-ai/internal/storage"
+	"krillin-ai/internal/types"
+	"krillin-ai/log"
+	"krillin-ai/pkg/util"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	webhookMaxAttempts     = 5
+	webhookRequestTimeout  = 10 * time.Second
+	webhookMaxDeliveryKept = 50 // 每个任务最多保留的投递记录数
+
+	WebhookEventHeader     = "X-Krillin-Event"
+	WebhookDeliveryHeader  = "X-Krillin-Delivery"
+	WebhookSignatureHeader = "X-Krillin-Signature"
+)
+
+var webhookHttpClient = &http.Client{Timeout: webhookRequestTimeout}
+
+// 第一次重试前的等待时间，之后每次翻倍
+var webhookInitialBackoff = 2 * time.Second
+
+func validateCallbackUrl(callbackUrl string) error {
+	u, err := url.Parse(callbackUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("回调地址不合法")
+	}
+	return nil
+}
+
+// 对请求体做HMAC-SHA256签名，接收方用相同密钥计算后比对即可验证来源
+func signWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// notifyTaskWebhook 任务成功或失败时异步回调，未配置回调地址时不做任何事
+func notifyTaskWebhook(taskId, event string) {
+	task, err := storage.SubtitleTaskRepo.Get(taskId)
+	if err != nil {
+		log.GetLogger().Error("notifyTaskWebhook get task err", zap.String("taskId", taskId), zap.Error(err))
+		return
+	}
+	if task.CallbackUrl == "" {
+		return
+	}
+	payload := dto.SubtitleTaskWebhookPayload{
+		Event:      event,
+		TaskId:     task.TaskId,
+		Status:     task.Status,
+		FailReason: task.FailReason,
+		Data:       buildSubtitleTaskResData(task),
+		Time:       time.Now().Unix(),
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.GetLogger().Error("notifyTaskWebhook marshal payload err", zap.String("taskId", taskId), zap.Error(err))
+		return
+	}
+	go deliverTaskWebhook(task.TaskId, task.CallbackUrl, task.CallbackSecret, event, body)
+}
+
+// 失败时按指数退避重试，每次尝试都记录到任务上
+func deliverTaskWebhook(taskId, callbackUrl, secret, event string, body []byte) {
+	deliveryId := util.GenerateRandStringWithUpperLowerNum(12)
+	backoff := webhookInitialBackoff
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		delivery := sendTaskWebhook(callbackUrl, secret, event, deliveryId, body)
+		delivery.Attempt = attempt
+		recordWebhookDelivery(taskId, delivery)
+		if delivery.Success {
+			log.GetLogger().Info("deliverTaskWebhook success", zap.String("taskId", taskId), zap.String("event", event), zap.Int("attempt", attempt))
+			return
+		}
+		log.GetLogger().Error("deliverTaskWebhook attempt failed", zap.String("taskId", taskId), zap.String("event", event), zap.Int("attempt", attempt),
+			zap.Int("status code", delivery.StatusCode), zap.String("err", delivery.Error))
+		if attempt < webhookMaxAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	log.GetLogger().Error("deliverTaskWebhook give up", zap.String("taskId", taskId), zap.String("event", event))
+}
+
+func sendTaskWebhook(callbackUrl, secret, event, deliveryId string, body []byte) types.WebhookDelivery {
+	delivery := types.WebhookDelivery{
+		DeliveryId: deliveryId,
+		Event:      event,
+		Time:       time.Now().Unix(),
+	}
+	start := time.Now()
+
+	req, err := http.NewRequest(http.MethodPost, callbackUrl, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = fmt.Sprintf("构造请求失败: %v", err)
+		return delivery
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, event)
+	req.Header.Set(WebhookDeliveryHeader, deliveryId)
+	if secret != "" {
+		req.Header.Set(WebhookSignatureHeader, signWebhookPayload(secret, body))
+	}
+	resp, err := webhookHttpClient.Do(req)
+	if err != nil {
+		delivery.Error = err.Error()
+		delivery.DurationMs = time.Since(start).Milliseconds()
+		return delivery
+	}
+	defer resp.Body.Close()
+	delivery.StatusCode = resp.StatusCode
+	delivery.Success = resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !delivery.Success {
+		delivery.Error = fmt.Sprintf("回调返回状态码%d", resp.StatusCode)
+	}
+	delivery.DurationMs = time.Since(start).Milliseconds()
+	return delivery
+}
+
+func recordWebhookDelivery(taskId string, delivery types.WebhookDelivery) {
+	updateTask(taskId, func(task *types.SubtitleTask) {
+		task.WebhookDeliveries = append(task.WebhookDeliveries, delivery)
+		if len(task.WebhookDeliveries) > webhookMaxDeliveryKept {
+			task.WebhookDeliveries = task.WebhookDeliveries[len(task.WebhookDeliveries)-webhookMaxDeliveryKept:]
+		}
+	})
+}
+
+func (s Service) GetSubtitleTaskWebhooks(req dto.GetVideoSubtitleTaskReq) (*dto.GetSubtitleTaskWebhooksResData, error) {
+	task, err := storage.SubtitleTaskRepo.Get(req.TaskId)
+	if err != nil {
+		if errors.Is(err, storage.ErrSubtitleTaskNotFound) {
+			return nil, errors.New("任务不存在")
+		}
+		log.GetLogger().Error("GetSubtitleTaskWebhooks get task err", zap.String("taskId", req.TaskId), zap.Error(err))
+		return nil, errors.New("查询任务失败")
+	}
+	return &dto.GetSubtitleTaskWebhooksResData{
+		TaskId:      task.TaskId,
+		CallbackUrl: task.CallbackUrl,
+		Deliveries: lo.Map(task.WebhookDeliveries, func(item types.WebhookDelivery, _ int) *dto.WebhookDelivery {
+			return &dto.WebhookDelivery{
+				DeliveryId: item.DeliveryId,
+				Event:      item.Event,
+				Attempt:    item.Attempt,
+				StatusCode: item.StatusCode,
+				Error:      item.Error,
+				Success:    item.Success,
+				DurationMs: item.DurationMs,
+				Time:       item.Time,
+			}
+		}),
+	}, nil
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"go.uber.org/zap"
+	"io"
+	"krillin-ai/internal/storage"
+	"krillin-ai/internal/types"
+	"krillin-ai/log"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useWebhookBackoff 测试期间缩短重试等待
+func useWebhookBackoff(t *testing.T) {
+	originBackoff := webhookInitialBackoff
+	webhookInitialBackoff = time.Millisecond
+	t.Cleanup(func() { webhookInitialBackoff = originBackoff })
+}
+
+func Test_deliverTaskWebhook(t *testing.T) {
+	log.Logger = zap.NewNop()
+	useMemoryTaskRepo(t)
+	useWebhookBackoff(t)
+	if err := storage.SubtitleTaskRepo.Create(&types.SubtitleTask{TaskId: "task1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"event":"task.success","task_id":"task1"}`)
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ := io.ReadAll(r.Body)
+		if string(got) != string(body) {
+			t.Errorf("body = %s, want %s", got, body)
+		}
+		if sign := r.Header.Get(WebhookSignatureHeader); sign != signWebhookPayload("secret", got) {
+			t.Errorf("signature = %q, want %q", sign, signWebhookPayload("secret", got))
+		}
+		if r.Header.Get(WebhookEventHeader) != "task.success" || r.Header.Get(WebhookDeliveryHeader) == "" {
+			t.Errorf("headers = %v", r.Header)
+		}
+		// 前两次返回5xx，第三次成功
+		if requests.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	deliverTaskWebhook("task1", server.URL, "secret", "task.success", body)
+
+	if got := requests.Load(); got != 3 {
+		t.Errorf("requests = %d, want 3", got)
+	}
+	task, _ := storage.SubtitleTaskRepo.Get("task1")
+	if len(task.WebhookDeliveries) != 3 {
+		t.Fatalf("deliveries = %d, want 3", len(task.WebhookDeliveries))
+	}
+	for i, delivery := range task.WebhookDeliveries {
+		wantSuccess := i == 2
+		if delivery.Attempt != i+1 || delivery.Success != wantSuccess || delivery.DeliveryId != task.WebhookDeliveries[0].DeliveryId {
+			t.Errorf("deliveries[%d] = %+v", i, delivery)
+		}
+	}
+	if task.WebhookDeliveries[0].StatusCode != http.StatusBadGateway || task.WebhookDeliveries[2].StatusCode != http.StatusOK {
+		t.Errorf("status codes = %d, %d", task.WebhookDeliveries[0].StatusCode, task.WebhookDeliveries[2].StatusCode)
+	}
+}
+
+func Test_deliverTaskWebhookGiveUp(t *testing.T) {
+	log.Logger = zap.NewNop()
+	useMemoryTaskRepo(t)
+	useWebhookBackoff(t)
+	if err := storage.SubtitleTaskRepo.Create(&types.SubtitleTask{TaskId: "task1"}); err != nil {
+		t.Fatal(err)
+	}
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(WebhookSignatureHeader) != "" {
+			t.Error("signature sent without secret")
+		}
+		requests.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	deliverTaskWebhook("task1", server.URL, "", "task.failed", []byte(`{}`))
+
+	if got := requests.Load(); got != webhookMaxAttempts {
+		t.Errorf("requests = %d, want %d", got, webhookMaxAttempts)
+	}
+	task, _ := storage.SubtitleTaskRepo.Get("task1")
+	if len(task.WebhookDeliveries) != webhookMaxAttempts || task.WebhookDeliveries[webhookMaxAttempts-1].Success {
+		t.Errorf("deliveries = %+v", task.WebhookDeliveries)
+	}
+}
+
+func Test_recordWebhookDelivery(t *testing.T) {
+	log.Logger = zap.NewNop()
+	useMemoryTaskRepo(t)
+	if err := storage.SubtitleTaskRepo.Create(&types.SubtitleTask{TaskId: "task1"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= webhookMaxDeliveryKept+10; i++ {
+		recordWebhookDelivery("task1", types.WebhookDelivery{Attempt: i})
+	}
+	task, _ := storage.SubtitleTaskRepo.Get("task1")
+	if len(task.WebhookDeliveries) != webhookMaxDeliveryKept {
+		t.Fatalf("deliveries = %d, want %d", len(task.WebhookDeliveries), webhookMaxDeliveryKept)
+	}
+	// 只保留最新的记录
+	if first, last := task.WebhookDeliveries[0].Attempt, task.WebhookDeliveries[webhookMaxDeliveryKept-1].Attempt; first != 11 || last != webhookMaxDeliveryKept+10 {
+		t.Errorf("kept attempts %d..%d, want 11..%d", first, last, webhookMaxDeliveryKept+10)
+	}
+}
@@ -0,0 +1,162 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"krillin-ai/internal/dto"
+	"krillin-ai/internal/storage"
+	"krillin-ai/internal/types"
+	"krillin-ai/log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	taskFileTypeSubtitle = "subtitle"
+	taskFileTypeSpeech   = "speech"
+	taskFileTypeVideo    = "video"
+	taskFileTypeImage    = "image"
+	taskFileTypeArchive  = "archive"
+	taskFileTypeText     = "text"
+)
+
+var errFileNotFound = errors.New("文件不存在")
+
+// taskFile 任务产生的可下载文件，只有这些文件可以通过下载接口获取
+type taskFile struct {
+	Id   string
+	Name string // 字幕名称或文件名
+	Type string
+	Path string // 相对工作目录的本地路径
+}
+
+// taskFileId 由文件路径得到的稳定id，下载时不需要暴露路径
+func taskFileId(localPath string) string {
+	sum := sha256.Sum256([]byte(filepath.ToSlash(filepath.Clean(localPath))))
+	return hex.EncodeToString(sum[:8])
+}
+
+func taskFileType(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".mp4", ".mov", ".mkv", ".webm":
+		return taskFileTypeVideo
+	case ".srt", ".vtt", ".ass":
+		return taskFileTypeSubtitle
+	case ".wav", ".mp3", ".m4a":
+		return taskFileTypeSpeech
+	case ".jpg", ".jpeg", ".png", ".webp":
+		return taskFileTypeImage
+	case ".zip":
+		return taskFileTypeArchive
+	}
+	return taskFileTypeText
+}
+
+// subtitleTaskFiles 列出任务的字幕、配音、批量任务压缩包和output目录下的文件
+func subtitleTaskFiles(task *types.SubtitleTask) []taskFile {
+	files := make([]taskFile, 0)
+	added := make(map[string]bool)
+	add := func(localPath, name, fileType string) {
+		localPath = filepath.Clean(localPath)
+		if added[localPath] {
+			return
+		}
+		added[localPath] = true
+		files = append(files, taskFile{Id: taskFileId(localPath), Name: name, Type: fileType, Path: localPath})
+	}
+
+	for _, info := range task.SubtitleInfos {
+		add(strings.TrimPrefix(info.DownloadUrl, "/api/file/"), info.Name, taskFileTypeSubtitle)
+	}
+	if task.SpeechDownloadUrl != "" {
+		add(strings.TrimPrefix(task.SpeechDownloadUrl, "/api/file/"), "配音", taskFileTypeSpeech)
+	}
+	if task.ArchiveDownloadUrl != "" {
+		archivePath := strings.TrimPrefix(task.ArchiveDownloadUrl, "/api/file/")
+		add(archivePath, filepath.Base(archivePath), taskFileTypeArchive)
+	}
+	// 合成的视频等文件在output目录下，没有单独的下载链接
+	outputDir := filepath.Join(taskWorkspaceRoot, task.TaskId, "output")
+	entries, err := os.ReadDir(outputDir)
+	if err != nil && !os.IsNotExist(err) {
+		log.GetLogger().Warn("subtitleTaskFiles read output dir err", zap.String("taskId", task.TaskId), zap.Error(err))
+	}
+	for _, entry := range entries {
+		// 跳过正在写入的临时文件
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+		add(filepath.Join(outputDir, entry.Name()), entry.Name(), taskFileType(entry.Name()))
+	}
+	return files
+}
+
+func buildTaskFileResData(task *types.SubtitleTask) []*dto.TaskFile {
+	files := make([]*dto.TaskFile, 0)
+	if task.Status != types.SubtitleTaskStatusSuccess {
+		return files
+	}
+	for _, file := range subtitleTaskFiles(task) {
+		files = append(files, &dto.TaskFile{
+			Id:          file.Id,
+			Name:        file.Name,
+			Type:        file.Type,
+			DownloadUrl: fmt.Sprintf("/api/capability/subtitleTask/file?task_id=%s&file_id=%s", url.QueryEscape(task.TaskId), file.Id),
+		})
+	}
+	return files
+}
+
+func isRegularFile(localPath string) bool {
+	info, err := os.Lstat(localPath)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// GetSubtitleTaskFile 按文件id查找任务产生的文件
+func (s Service) GetSubtitleTaskFile(req dto.GetSubtitleTaskFileReq) (string, error) {
+	task, err := storage.SubtitleTaskRepo.Get(req.TaskId)
+	if err != nil {
+		return "", errFileNotFound
+	}
+	for _, file := range subtitleTaskFiles(task) {
+		if file.Id == req.FileId && isRegularFile(file.Path) {
+			return file.Path, nil
+		}
+	}
+	return "", errFileNotFound
+}
+
+// ResolveDownloadPath 兼容/api/file/下的路径链接，只允许下载上传目录下的文件和任务产生的文件
+func (s Service) ResolveDownloadPath(requested string) (string, error) {
+	// 拒绝反斜杠、盘符等在其它系统上可能被当作路径的字符
+	if strings.ContainsAny(requested, "\\:\x00") {
+		return "", errFileNotFound
+	}
+	clean := strings.TrimPrefix(path.Clean("/"+requested), "/")
+	localPath := filepath.FromSlash(clean)
+	segments := strings.Split(clean, "/")
+	switch {
+	case len(segments) == 2 && segments[0] == filepath.Base(uploadRoot):
+		if isRegularFile(localPath) {
+			return localPath, nil
+		}
+	case len(segments) >= 3 && segments[0] == filepath.Base(taskWorkspaceRoot):
+		task, err := storage.SubtitleTaskRepo.Get(segments[1])
+		if err != nil {
+			return "", errFileNotFound
+		}
+		for _, file := range subtitleTaskFiles(task) {
+			if file.Path == localPath && isRegularFile(localPath) {
+				return localPath, nil
+			}
+		}
+	}
+	log.GetLogger().Warn("ResolveDownloadPath rejected", zap.String("path", requested))
+	return "", errFileNotFound
+}
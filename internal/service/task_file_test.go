@@ -0,0 +1,84 @@
+package service
+
+import (
+	"go.uber.org/zap"
+	"krillin-ai/log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_ResolveDownloadPath(t *testing.T) {
+	log.Logger = zap.NewNop()
+	for _, requested := range []string{
+		"/config/config.toml",
+		"/../config/config.toml",
+		"/uploads/../config/config.toml",
+		"/uploads/..\\config\\config.toml",
+		"/uploads/c:/windows/win.ini",
+		"/data/tasks/abc.json",
+		"/uploads",
+	} {
+		if localPath, err := (Service{}).ResolveDownloadPath(requested); err == nil {
+			t.Errorf("ResolveDownloadPath(%q) = %q, want err", requested, localPath)
+		}
+	}
+}
+
+func Test_sanitizeUploadFileName(t *testing.T) {
+	tests := map[string]string{
+		"lecture 01.MP4":         "_lecture_01.mp4",
+		"../../etc/passwd.mp4":   "_passwd.mp4",
+		"C:\\videos\\课程 第一讲.mov": "_课程_第一讲.mov",
+		"...mp3":                 "_upload.mp3",
+		"subs.SRT":               "_subs.srt",
+	}
+	for name, suffix := range tests {
+		got, err := sanitizeUploadFileName(name)
+		if err != nil {
+			t.Errorf("sanitizeUploadFileName(%q) err = %v", name, err)
+			continue
+		}
+		if len(got) != 8+len(suffix) || !strings.HasSuffix(got, suffix) {
+			t.Errorf("sanitizeUploadFileName(%q) = %q, want random prefix + %q", name, got, suffix)
+		}
+	}
+
+	for _, name := range []string{"config.toml", "index.html", "noext", "video.mp4.exe"} {
+		if _, err := sanitizeUploadFileName(name); err == nil {
+			t.Errorf("sanitizeUploadFileName(%q) want err", name)
+		}
+	}
+}
+
+func Test_resolveUploadedFile(t *testing.T) {
+	log.Logger = zap.NewNop()
+	chdirTemp(t)
+	if err := os.MkdirAll(filepath.Join(uploadRoot, "sub"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"uploads/abc_video.mp4", "uploads/sub/nested.srt", "secret.srt"} {
+		if err := os.WriteFile(name, []byte("1"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, link := range []string{"local:./uploads/abc_video.mp4", "local:uploads/abc_video.mp4"} {
+		if _, err := resolveUploadedFile(link); err != nil {
+			t.Errorf("resolveUploadedFile(%q) err = %v", link, err)
+		}
+	}
+	for _, link := range []string{
+		"./uploads/abc_video.mp4",
+		"local:secret.srt",
+		"local:./uploads/../secret.srt",
+		"local:./uploads/sub/nested.srt",
+		"local:./uploads/missing.mp4",
+		"local:./uploads",
+	} {
+		if localPath, err := resolveUploadedFile(link); err == nil {
+			t.Errorf("resolveUploadedFile(%q) = %q, want err", link, localPath)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"krillin-ai/internal/dto"
+	"krillin-ai/internal/storage"
+	"krillin-ai/internal/types"
+	"krillin-ai/log"
+	"krillin-ai/pkg/util"
+	"regexp"
+	"strings"
+)
+
+const (
+	// 相似度不低于该值的历史译文作为参考注入Prompt
+	translationMemoryMinSimilarity = 0.85
+	// 每次翻译最多注入的参考条数，避免Prompt过长
+	translationMemoryMaxReferences = 20
+)
+
+var sentenceSplitRegex = regexp.MustCompile(`[^.!?。！？\n]+[.!?。！？]*`)
+
+func translationMemoryEnabled(targetLanguage types.StandardLanguageName) bool {
+	return storage.TranslationMemoryStore != nil && targetLanguage != "" && targetLanguage != "none"
+}
+
+// translationMemoryReferences 从翻译记忆中找出与待翻译文本中句子相同或相近的记录，生成参考Prompt
+func translationMemoryReferences(targetLanguage types.StandardLanguageName, text string) string {
+	if !translationMemoryEnabled(targetLanguage) {
+		return ""
+	}
+	units := storage.TranslationMemoryStore.Similar(string(targetLanguage), sentenceSplitRegex.FindAllString(text, -1),
+		translationMemoryMinSimilarity, translationMemoryMaxReferences)
+	if len(units) == 0 {
+		return ""
+	}
+	var references strings.Builder
+	for _, unit := range units {
+		references.WriteString(fmt.Sprintf("原文：%s\n译文：%s\n", unit.Source, unit.Target))
+	}
+	return fmt.Sprintf(types.TranslationMemoryReferencePrompt, references.String())
+}
+
+// applyTranslationMemory 对大模型返回的拆分结果，原句在翻译记忆中有精确匹配的直接使用记忆中的译文，返回替换后的内容和替换条数
+func applyTranslationMemory(targetLanguage types.StandardLanguageName, splitContent string) (string, int) {
+	if !translationMemoryEnabled(targetLanguage) || splitContent == "" {
+		return splitContent, 0
+	}
+	lines := strings.Split(splitContent, "\n")
+	reused := 0
+	// 每块依次为编号、译文、原文
+	for i := 0; i+2 < len(lines); i++ {
+		if !util.IsNumber(strings.TrimSpace(lines[i])) {
+			continue
+		}
+		targetIndex := i + 1
+		target := util.TrimString(lines[targetIndex])
+		origin := util.TrimString(lines[i+2])
+		if target == "" || origin == "" {
+			continue
+		}
+		i += 2
+		translation, ok := storage.TranslationMemoryStore.Lookup(string(targetLanguage), origin)
+		if !ok || translation == target {
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(lines[targetIndex]), "[") {
+			lines[targetIndex] = "[" + translation + "]"
+		} else {
+			lines[targetIndex] = translation
+		}
+		reused++
+	}
+	return strings.Join(lines, "\n"), reused
+}
+
+// harvestTranslationMemory 任务成功后把各段拆分翻译的结果写入翻译记忆
+func harvestTranslationMemory(stepParam *types.SubtitleTaskStepParam) {
+	if !translationMemoryEnabled(stepParam.TargetLanguage) || stepParam.SubtitleResultType == types.SubtitleResultTypeOriginOnly {
+		return
+	}
+	units := make([]storage.TranslationUnit, 0)
+	for _, audioFile := range stepParam.SmallAudios {
+		if audioFile.SrtNoTsFile == "" {
+			continue
+		}
+		blocks, err := util.ParseSrtNoTsToSrtBlock(audioFile.SrtNoTsFile)
+		if err != nil {
+			log.GetLogger().Warn("harvestTranslationMemory ParseSrtNoTsToSrtBlock err", zap.String("taskId", stepParam.TaskId), zap.String("file", audioFile.SrtNoTsFile), zap.Error(err))
+			continue
+		}
+		for _, block := range blocks {
+			units = append(units, storage.TranslationUnit{
+				SourceLanguage: string(stepParam.OriginLanguage),
+				TargetLanguage: string(stepParam.TargetLanguage),
+				Source:         block.OriginLanguageSentence,
+				Target:         block.TargetLanguageSentence,
+			})
+		}
+	}
+	// 整个任务的记录收集后一次写入，存储文件只重写一次
+	added, err := storage.TranslationMemoryStore.Add(units)
+	if err != nil {
+		log.GetLogger().Error("harvestTranslationMemory add err", zap.String("taskId", stepParam.TaskId), zap.Error(err))
+		return
+	}
+	log.GetLogger().Info("翻译记忆已更新", zap.String("taskId", stepParam.TaskId), zap.Int("added", added))
+}
+
+func (s Service) ExportTranslationMemory(req dto.ExportTranslationMemoryReq) ([]byte, error) {
+	if storage.TranslationMemoryStore == nil {
+		return nil, errors.New("未开启翻译记忆")
+	}
+	var buf bytes.Buffer
+	if err := storage.EncodeTmx(&buf, storage.TranslationMemoryStore.List(req.TargetLang)); err != nil {
+		log.GetLogger().Error("ExportTranslationMemory EncodeTmx err", zap.Error(err))
+		return nil, errors.New("导出翻译记忆失败")
+	}
+	return buf.Bytes(), nil
+}
+
+func (s Service) ImportTranslationMemory(r io.Reader) (*dto.ImportTranslationMemoryResData, error) {
+	if storage.TranslationMemoryStore == nil {
+		return nil, errors.New("未开启翻译记忆")
+	}
+	units, err := storage.DecodeTmx(r)
+	if err != nil {
+		log.GetLogger().Error("ImportTranslationMemory DecodeTmx err", zap.Error(err))
+		return nil, errors.New("TMX文件格式错误")
+	}
+	added, err := storage.TranslationMemoryStore.Add(units)
+	if err != nil {
+		log.GetLogger().Error("ImportTranslationMemory add err", zap.Error(err))
+		return nil, errors.New("导入翻译记忆失败")
+	}
+	return &dto.ImportTranslationMemoryResData{
+		Total: len(units),
+		Added: added,
+	}, nil
+}
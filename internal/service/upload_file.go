@@ -0,0 +1,142 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"krillin-ai/config"
+	"krillin-ai/log"
+	"krillin-ai/pkg/util"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// 可以上传的字幕文件，用于导入已有字幕
+var subtitleUploadExts = map[string]bool{
+	".srt": true,
+	".vtt": true,
+}
+
+var (
+	ErrUploadTooLarge       = errors.New("文件大小超过上传上限")
+	errUploadTypeForbidden  = errors.New("不支持的文件类型")
+	errUploadedFileNotFound = errors.New("文件不存在，本地文件需要先上传，使用上传后得到的local:路径")
+)
+
+// MaxUploadBytes 单个上传文件的大小上限，0表示不限制
+func MaxUploadBytes() int64 {
+	return int64(config.Conf.Server.MaxUploadMb) << 20
+}
+
+// resolveUploadedFile 任务只能读取上传目录下的文件，返回local:链接对应的本地路径
+func resolveUploadedFile(link string) (string, error) {
+	if !strings.HasPrefix(link, "local:") {
+		return "", errUploadedFileNotFound
+	}
+	localPath := filepath.Clean(strings.TrimPrefix(link, "local:"))
+	if filepath.Dir(localPath) != filepath.Clean(uploadRoot) || !isRegularFile(localPath) {
+		log.GetLogger().Warn("resolveUploadedFile rejected", zap.String("link", link))
+		return "", errUploadedFileNotFound
+	}
+	return localPath, nil
+}
+
+// sanitizeUploadFileName 只保留文件名中的字母、数字和少量符号，检查扩展名后加上随机前缀，避免覆盖已有文件
+func sanitizeUploadFileName(name string) (string, error) {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	ext := strings.ToLower(filepath.Ext(name))
+	if !directMediaExts[ext] && !subtitleUploadExts[ext] {
+		return "", errUploadTypeForbidden
+	}
+	base := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, strings.TrimSuffix(name, filepath.Ext(name)))
+	if runes := []rune(base); len(runes) > 80 {
+		base = string(runes[:80])
+	}
+	if strings.Trim(base, "_") == "" {
+		base = "upload"
+	}
+	return util.GenerateRandStringWithUpperLowerNum(8) + "_" + base + ext, nil
+}
+
+// checkUploadContent 根据文件头判断内容类型，字幕文件只接受纯文本，其它只接受音视频文件
+func checkUploadContent(r io.Reader, ext string) error {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("checkUploadContent read err: %w", err)
+	}
+	contentType := http.DetectContentType(head[:n])
+	if subtitleUploadExts[strings.ToLower(ext)] {
+		if strings.HasPrefix(contentType, "text/plain") {
+			return nil
+		}
+		log.GetLogger().Warn("checkUploadContent forbidden content type", zap.String("content type", contentType))
+		return errUploadTypeForbidden
+	}
+	// mov、mkv等格式无法识别，按二进制文件处理
+	if strings.HasPrefix(contentType, "video/") || strings.HasPrefix(contentType, "audio/") ||
+		contentType == "application/ogg" || contentType == "application/octet-stream" {
+		return nil
+	}
+	log.GetLogger().Warn("checkUploadContent forbidden content type", zap.String("content type", contentType))
+	return errUploadTypeForbidden
+}
+
+// SaveUploadFile 校验大小、扩展名和文件内容后以随机文件名保存到上传目录，返回可作为任务url的local:路径
+func (s Service) SaveUploadFile(file *multipart.FileHeader) (string, error) {
+	if maxBytes := MaxUploadBytes(); maxBytes > 0 && file.Size > maxBytes {
+		return "", ErrUploadTooLarge
+	}
+	fileName, err := sanitizeUploadFileName(file.Filename)
+	if err != nil {
+		return "", err
+	}
+	src, err := file.Open()
+	if err != nil {
+		log.GetLogger().Error("SaveUploadFile open err", zap.String("file", file.Filename), zap.Error(err))
+		return "", errors.New("文件读取失败")
+	}
+	defer src.Close()
+	if err = checkUploadContent(src, filepath.Ext(fileName)); err != nil {
+		return "", err
+	}
+	if _, err = src.Seek(0, io.SeekStart); err != nil {
+		return "", errors.New("文件读取失败")
+	}
+
+	if err = os.MkdirAll(uploadRoot, os.ModePerm); err != nil {
+		log.GetLogger().Error("SaveUploadFile MkdirAll err", zap.Error(err))
+		return "", errors.New("文件保存失败")
+	}
+	savePath := uploadRoot + "/" + fileName
+	dst, err := os.OpenFile(savePath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		log.GetLogger().Error("SaveUploadFile create err", zap.String("path", savePath), zap.Error(err))
+		return "", errors.New("文件保存失败")
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(dst, hash), src)
+	closeErr := dst.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(savePath)
+		log.GetLogger().Error("SaveUploadFile copy err", zap.String("path", savePath), zap.Error(err))
+		return "", errors.New("文件保存失败")
+	}
+	rememberFileSha256(savePath, hex.EncodeToString(hash.Sum(nil)))
+	return "local:" + savePath, nil
+}
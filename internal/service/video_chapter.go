@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"krillin-ai/internal/storage"
+	"krillin-ai/internal/types"
+	"krillin-ai/log"
+	"krillin-ai/pkg/util"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 章节开始时标题的显示时长，单位：秒
+const chapterMarkerSeconds = 5
+
+// probeVideoChapters 用ffprobe读取本地文件中内嵌的章节
+func probeVideoChapters(ctx context.Context, videoPath string) ([]types.VideoChapter, error) {
+	cmd := exec.CommandContext(ctx, storage.FfprobePath, "-v", "quiet", "-print_format", "json", "-show_chapters", videoPath)
+	output, err := cmd.Output()
+	if err != nil {
+		recordProcessFailure(ctx, cmd)
+		return nil, fmt.Errorf("probeVideoChapters ffprobe err: %w", err)
+	}
+	var probe struct {
+		Chapters []struct {
+			StartTime string            `json:"start_time"`
+			EndTime   string            `json:"end_time"`
+			Tags      map[string]string `json:"tags"`
+		} `json:"chapters"`
+	}
+	if err = json.Unmarshal(output, &probe); err != nil {
+		return nil, fmt.Errorf("probeVideoChapters unmarshal err: %w", err)
+	}
+	chapters := make([]types.VideoChapter, 0, len(probe.Chapters))
+	for _, chapter := range probe.Chapters {
+		start, _ := strconv.ParseFloat(chapter.StartTime, 64)
+		end, _ := strconv.ParseFloat(chapter.EndTime, 64)
+		chapters = append(chapters, types.VideoChapter{
+			StartTime: start,
+			EndTime:   end,
+			Title:     strings.TrimSpace(chapter.Tags["title"]),
+		})
+	}
+	return chapters, nil
+}
+
+// chapterDisplayTitle 有译文时显示译文，没有标题时按序号命名
+func chapterDisplayTitle(chapter types.VideoChapter, num int) string {
+	if chapter.TranslatedTitle != "" {
+		return chapter.TranslatedTitle
+	}
+	if chapter.Title != "" {
+		return chapter.Title
+	}
+	return fmt.Sprintf("Chapter %d", num)
+}
+
+// loadTaskChapters 读取任务的章节，任务不存在或没有章节时返回空
+func loadTaskChapters(taskId string) []types.VideoChapter {
+	task, err := storage.SubtitleTaskRepo.Get(taskId)
+	if err != nil {
+		log.GetLogger().Warn("loadTaskChapters get task err", zap.String("taskId", taskId), zap.Error(err))
+		return nil
+	}
+	return task.Chapters
+}
+
+// generateChapters 翻译章节标题，并生成YouTube简介格式的章节列表和在章节开始处显示标题的字幕
+func (s Service) generateChapters(ctx context.Context, stepParam *types.SubtitleTaskStepParam) error {
+	chapters := loadTaskChapters(stepParam.TaskId)
+	if len(chapters) == 0 {
+		log.GetLogger().Info("generateChapters 视频没有章节，跳过", zap.String("taskId", stepParam.TaskId))
+		return nil
+	}
+	if stepParam.TargetLanguage != "" && stepParam.TargetLanguage != "none" {
+		// 章节标题较短，借用字幕的逐条翻译，翻译失败时使用原标题
+		blocks := make([]*util.SrtBlock, 0, len(chapters))
+		for i, chapter := range chapters {
+			if chapter.Title != "" {
+				blocks = append(blocks, &util.SrtBlock{Index: i + 1, OriginLanguageSentence: chapter.Title})
+			}
+		}
+		for i := 0; i < len(blocks); i += importSubtitleTranslateBatchSize {
+			batch := blocks[i:min(i+importSubtitleTranslateBatchSize, len(blocks))]
+			if err := s.translateSubtitleBatch(ctx, stepParam, "generateChapters", i/importSubtitleTranslateBatchSize+1, batch); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				log.GetLogger().Warn("generateChapters translateSubtitleBatch err", zap.String("taskId", stepParam.TaskId), zap.Error(err))
+			}
+		}
+		translatedTitles := make(map[int]string, len(blocks))
+		for _, block := range blocks {
+			if block.TargetLanguageSentence != "" {
+				translatedTitles[block.Index-1] = block.TargetLanguageSentence
+			}
+		}
+		updateTask(stepParam.TaskId, func(task *types.SubtitleTask) {
+			for i := range task.Chapters {
+				if title, ok := translatedTitles[i]; ok {
+					task.Chapters[i].TranslatedTitle = title
+				}
+			}
+		})
+		for i, title := range translatedTitles {
+			chapters[i].TranslatedTitle = title
+		}
+	}
+
+	outputDir := filepath.Join(stepParam.TaskBasePath, "output")
+	if err := os.WriteFile(filepath.Join(outputDir, types.SubtitleTaskChaptersTextFileName), []byte(buildChaptersText(chapters)), 0644); err != nil {
+		log.GetLogger().Error("generateChapters write chapters text err", zap.Any("stepParam", stepParam), zap.Error(err))
+		return fmt.Errorf("generateChapters write chapters text err: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(outputDir, types.SubtitleTaskChaptersSrtFileName), []byte(buildChaptersSrt(chapters)), 0644); err != nil {
+		log.GetLogger().Error("generateChapters write chapters srt err", zap.Any("stepParam", stepParam), zap.Error(err))
+		return fmt.Errorf("generateChapters write chapters srt err: %w", err)
+	}
+	log.GetLogger().Info("generateChapters end", zap.String("taskId", stepParam.TaskId), zap.Int("chapter num", len(chapters)))
+	return nil
+}
+
+// buildChaptersText 生成YouTube简介格式的章节列表，如 00:00 开场，超过一小时的视频使用 0:00:00
+func buildChaptersText(chapters []types.VideoChapter) string {
+	withHour := chapters[len(chapters)-1].StartTime >= 3600
+	var text strings.Builder
+	for i, chapter := range chapters {
+		seconds := int(chapter.StartTime)
+		// YouTube要求第一个章节从0秒开始
+		if i == 0 {
+			seconds = 0
+		}
+		if withHour {
+			text.WriteString(fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60))
+		} else {
+			text.WriteString(fmt.Sprintf("%02d:%02d", seconds/60, seconds%60))
+		}
+		text.WriteString(" " + chapterDisplayTitle(chapter, i+1) + "\n")
+	}
+	return text.String()
+}
+
+// chapterMarkerEnd 章节标题显示到开始后chapterMarkerSeconds秒，不超过章节结束
+func chapterMarkerEnd(chapter types.VideoChapter) float64 {
+	end := chapter.StartTime + chapterMarkerSeconds
+	if chapter.EndTime > chapter.StartTime {
+		end = math.Min(end, chapter.EndTime)
+	}
+	return end
+}
+
+// buildChaptersSrt 每个章节开始时显示一条章节标题
+func buildChaptersSrt(chapters []types.VideoChapter) string {
+	var text strings.Builder
+	for i, chapter := range chapters {
+		text.WriteString(fmt.Sprintf("%d\n%s --> %s\n%s\n\n", i+1, util.FormatTime(float32(chapter.StartTime)),
+			util.FormatTime(float32(chapterMarkerEnd(chapter))), chapterDisplayTitle(chapter, i+1)))
+	}
+	return text.String()
+}
+
+// buildChaptersAssEvents 合成视频时在顶部显示章节标题
+func buildChaptersAssEvents(chapters []types.VideoChapter) string {
+	var text strings.Builder
+	for i, chapter := range chapters {
+		start := time.Duration(chapter.StartTime * float64(time.Second))
+		end := time.Duration(chapterMarkerEnd(chapter) * float64(time.Second))
+		text.WriteString(fmt.Sprintf("Dialogue: 1,%s,%s,Chapter,,0,0,0,,{\\an8}%s\n", formatTimestamp(start), formatTimestamp(end),
+			strings.ReplaceAll(chapterDisplayTitle(chapter, i+1), "\n", " ")))
+	}
+	return text.String()
+}
+
+// writeChaptersFfmetadata 生成ffmpeg的元数据文件，用于把章节写入合成的视频
+func writeChaptersFfmetadata(chapters []types.VideoChapter, metadataPath string) error {
+	escaper := strings.NewReplacer("\\", "\\\\", "=", "\\=", ";", "\\;", "#", "\\#", "\n", "\\\n")
+	var text strings.Builder
+	text.WriteString(";FFMETADATA1\n")
+	for i, chapter := range chapters {
+		end := chapter.EndTime
+		if i+1 < len(chapters) && (end <= chapter.StartTime || end > chapters[i+1].StartTime) {
+			end = chapters[i+1].StartTime
+		}
+		if end <= chapter.StartTime {
+			end = chapter.StartTime + chapterMarkerSeconds
+		}
+		text.WriteString(fmt.Sprintf("[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+			int64(chapter.StartTime*1000), int64(end*1000), escaper.Replace(chapterDisplayTitle(chapter, i+1))))
+	}
+	return os.WriteFile(metadataPath, []byte(text.String()), 0644)
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"krillin-ai/internal/types"
+	"testing"
+)
+
+func Test_buildChaptersText(t *testing.T) {
+	tests := []struct {
+		name     string
+		chapters []types.VideoChapter
+		want     string
+	}{
+		{
+			name: "minutes",
+			chapters: []types.VideoChapter{
+				{StartTime: 0.4, EndTime: 65, Title: "Intro", TranslatedTitle: "开场"},
+				{StartTime: 65, EndTime: 600, Title: "Setup"},
+				{StartTime: 600, EndTime: 700},
+			},
+			want: "00:00 开场\n01:05 Setup\n10:00 Chapter 3\n",
+		},
+		{
+			name: "hours",
+			chapters: []types.VideoChapter{
+				{StartTime: 3, EndTime: 3725, Title: "Part 1"},
+				{StartTime: 3725, EndTime: 4000, Title: "Part 2"},
+			},
+			want: "0:00:00 Part 1\n1:02:05 Part 2\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildChaptersText(tt.chapters); got != tt.want {
+				t.Errorf("buildChaptersText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,5 +1,147 @@
 package storage
 
-import "krillin-ai/internal/types"
+import (
+	"errors"
+	"fmt"
+	"krillin-ai/config"
+	"krillin-ai/internal/types"
+	"path/filepath"
+	"sort"
+	"time"
+)
 
-var SubtitleTasks = make(map[string]*types.SubtitleTask)
+var ErrSubtitleTaskNotFound = errors.New("任务不存在")
+
+// SubtitleTaskRepository 字幕任务的存储接口，替换实现即可接入其它存储（如数据库）
+type SubtitleTaskRepository interface {
+	// Get 获取任务快照，返回的对象修改后不会影响存储中的数据
+	Get(taskId string) (*types.SubtitleTask, error)
+	Create(task *types.SubtitleTask) error
+	// Update 在锁内对任务执行修改并持久化
+	Update(taskId string, fn func(task *types.SubtitleTask)) error
+	Delete(taskId string) error
+	// List 按条件筛选任务，返回当前页数据和符合条件的总数
+	List(query SubtitleTaskQuery) ([]*types.SubtitleTask, int, error)
+}
+
+const (
+	SubtitleTaskOrderByCreateTime = "create_time"
+	SubtitleTaskOrderByUpdateTime = "update_time"
+)
+
+// SubtitleTaskQuery 任务列表查询条件，零值表示不限制
+type SubtitleTaskQuery struct {
+	Status    uint8
+	Language  string // 匹配源语言或目标语言
+	StartTime int64  // 创建时间下限，unix秒
+	EndTime   int64  // 创建时间上限，unix秒
+	CacheKey  string
+	OrderBy   string
+	Desc      bool
+	Offset    int
+	Limit     int
+}
+
+var SubtitleTaskRepo SubtitleTaskRepository
+
+// InitSubtitleTaskRepo 按配置初始化任务存储
+func InitSubtitleTaskRepo() error {
+	switch config.Conf.Storage.TaskStore {
+	case "memory":
+		SubtitleTaskRepo = NewMemorySubtitleTaskRepo()
+	case "file":
+		repo, err := NewFileSubtitleTaskRepo(filepath.Join(config.Conf.Storage.DataDir, "tasks"))
+		if err != nil {
+			return err
+		}
+		SubtitleTaskRepo = repo
+	default:
+		return fmt.Errorf("不支持的任务存储方式: %s", config.Conf.Storage.TaskStore)
+	}
+	return nil
+}
+
+// 与gorm的autoCreateTime/autoUpdateTime保持一致，使用unix秒
+func fillSubtitleTaskCreateTime(task *types.SubtitleTask) {
+	now := time.Now().Unix()
+	if task.CreateTime == 0 {
+		task.CreateTime = now
+	}
+	task.UpdateTime = now
+}
+
+// 复制任务，避免调用方拿到存储内部的指针
+func copySubtitleTask(task *types.SubtitleTask) *types.SubtitleTask {
+	cp := *task
+	if task.SubtitleInfos != nil {
+		cp.SubtitleInfos = append([]types.SubtitleInfo(nil), task.SubtitleInfos...)
+	}
+	if task.Timeline != nil {
+		cp.Timeline = append([]types.TimelineEntry(nil), task.Timeline...)
+	}
+	if task.WebhookDeliveries != nil {
+		cp.WebhookDeliveries = append([]types.WebhookDelivery(nil), task.WebhookDeliveries...)
+	}
+	if task.ChildTaskIds != nil {
+		cp.ChildTaskIds = append([]string(nil), task.ChildTaskIds...)
+	}
+	if task.Chapters != nil {
+		cp.Chapters = append([]types.VideoChapter(nil), task.Chapters...)
+	}
+	if task.LocalInputs != nil {
+		cp.LocalInputs = append([]string(nil), task.LocalInputs...)
+	}
+	return &cp
+}
+
+// 对内存中的任务做筛选、排序和分页，供基于内存索引的实现复用
+func querySubtitleTasks(tasks map[string]*types.SubtitleTask, query SubtitleTaskQuery) ([]*types.SubtitleTask, int) {
+	matched := make([]*types.SubtitleTask, 0)
+	for _, task := range tasks {
+		if query.Status != 0 && task.Status != query.Status {
+			continue
+		}
+		if query.Language != "" && task.OriginLanguage != query.Language && task.TargetLanguage != query.Language {
+			continue
+		}
+		if query.CacheKey != "" && task.CacheKey != query.CacheKey {
+			continue
+		}
+		if query.StartTime != 0 && task.CreateTime < query.StartTime {
+			continue
+		}
+		if query.EndTime != 0 && task.CreateTime > query.EndTime {
+			continue
+		}
+		matched = append(matched, task)
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := matched[i].CreateTime, matched[j].CreateTime
+		if query.OrderBy == SubtitleTaskOrderByUpdateTime {
+			a, b = matched[i].UpdateTime, matched[j].UpdateTime
+		}
+		if a == b {
+			// 时间相同时按id排序，保证分页稳定
+			return matched[i].TaskId < matched[j].TaskId
+		}
+		if query.Desc {
+			return a > b
+		}
+		return a < b
+	})
+
+	total := len(matched)
+	if query.Offset < 0 || query.Offset >= total {
+		return []*types.SubtitleTask{}, total
+	}
+	end := total
+	if query.Limit > 0 && query.Offset+query.Limit < total {
+		end = query.Offset + query.Limit
+	}
+	result := make([]*types.SubtitleTask, 0, end-query.Offset)
+	for _, task := range matched[query.Offset:end] {
+		result = append(result, copySubtitleTask(task))
+	}
+	return result, total
+}
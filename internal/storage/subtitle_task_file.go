@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"krillin-ai/internal/types"
+	"krillin-ai/log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileSubtitleTaskRepo 每个任务一个json文件，启动时全部加载到内存，写操作同步落盘
+type FileSubtitleTaskRepo struct {
+	dir   string
+	mu    sync.RWMutex
+	tasks map[string]*types.SubtitleTask
+}
+
+func NewFileSubtitleTaskRepo(dir string) (*FileSubtitleTaskRepo, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("NewFileSubtitleTaskRepo MkdirAll err: %w", err)
+	}
+	r := &FileSubtitleTaskRepo{
+		dir:   dir,
+		tasks: make(map[string]*types.SubtitleTask),
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// 加载已有任务，上次退出时仍在处理中的任务标记为中断
+func (r *FileSubtitleTaskRepo) load() error {
+	files, err := filepath.Glob(filepath.Join(r.dir, "*.json"))
+	if err != nil {
+		return fmt.Errorf("FileSubtitleTaskRepo load glob err: %w", err)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			log.GetLogger().Error("FileSubtitleTaskRepo load read file err", zap.String("file", file), zap.Error(err))
+			continue
+		}
+		var task types.SubtitleTask
+		if err = json.Unmarshal(data, &task); err != nil {
+			log.GetLogger().Error("FileSubtitleTaskRepo load unmarshal err", zap.String("file", file), zap.Error(err))
+			continue
+		}
+		if task.Status == types.SubtitleTaskStatusProcessing || task.Status == types.SubtitleTaskStatusQueued {
+			task.Status = types.SubtitleTaskStatusInterrupted
+			task.FailReason = "服务重启，任务被中断"
+			if task.BatchExpanding {
+				task.FailReason = "服务重启，批量任务的子任务未全部创建"
+			}
+			if err = r.persist(&task); err != nil {
+				log.GetLogger().Error("FileSubtitleTaskRepo load persist interrupted task err", zap.String("taskId", task.TaskId), zap.Error(err))
+			}
+		}
+		r.tasks[task.TaskId] = &task
+	}
+	log.GetLogger().Info("已加载历史任务", zap.Int("count", len(r.tasks)))
+	return nil
+}
+
+func (r *FileSubtitleTaskRepo) Get(taskId string) (*types.SubtitleTask, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	task, ok := r.tasks[taskId]
+	if !ok {
+		return nil, ErrSubtitleTaskNotFound
+	}
+	return copySubtitleTask(task), nil
+}
+
+func (r *FileSubtitleTaskRepo) Create(task *types.SubtitleTask) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cp := copySubtitleTask(task)
+	fillSubtitleTaskCreateTime(cp)
+	if err := r.persist(cp); err != nil {
+		return err
+	}
+	r.tasks[task.TaskId] = cp
+	return nil
+}
+
+func (r *FileSubtitleTaskRepo) Update(taskId string, fn func(task *types.SubtitleTask)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	task, ok := r.tasks[taskId]
+	if !ok {
+		return ErrSubtitleTaskNotFound
+	}
+	fn(task)
+	task.UpdateTime = time.Now().Unix()
+	return r.persist(task)
+}
+
+func (r *FileSubtitleTaskRepo) Delete(taskId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.tasks, taskId)
+	err := os.Remove(r.taskFilePath(taskId))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("FileSubtitleTaskRepo Delete err: %w", err)
+	}
+	return nil
+}
+
+func (r *FileSubtitleTaskRepo) taskFilePath(taskId string) string {
+	return filepath.Join(r.dir, taskId+".json")
+}
+
+// 先写临时文件再重命名，避免写到一半进程退出导致文件损坏
+func (r *FileSubtitleTaskRepo) persist(task *types.SubtitleTask) error {
+	if task.TaskId == "" || strings.ContainsAny(task.TaskId, `/\.`) {
+		return fmt.Errorf("FileSubtitleTaskRepo persist invalid task id: %q", task.TaskId)
+	}
+	data, err := json.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("FileSubtitleTaskRepo persist marshal err: %w", err)
+	}
+	target := r.taskFilePath(task.TaskId)
+	tmp := target + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("FileSubtitleTaskRepo persist write err: %w", err)
+	}
+	if err = os.Rename(tmp, target); err != nil {
+		return fmt.Errorf("FileSubtitleTaskRepo persist rename err: %w", err)
+	}
+	return nil
+}
+
+func (r *FileSubtitleTaskRepo) List(query SubtitleTaskQuery) ([]*types.SubtitleTask, int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tasks, total := querySubtitleTasks(r.tasks, query)
+	return tasks, total, nil
+}
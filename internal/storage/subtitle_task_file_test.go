@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"encoding/json"
+	"go.uber.org/zap"
+	"krillin-ai/internal/types"
+	"krillin-ai/log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileSubtitleTaskRepoReload(t *testing.T) {
+	log.Logger = zap.NewNop()
+	dir := t.TempDir()
+	for _, task := range []*types.SubtitleTask{
+		{TaskId: "processing", Status: types.SubtitleTaskStatusProcessing, ProcessPct: 40},
+		{TaskId: "queued", Status: types.SubtitleTaskStatusQueued},
+		{TaskId: "expanding", TaskType: types.SubtitleTaskTypeBatch, Status: types.SubtitleTaskStatusProcessing, BatchExpanding: true},
+		{TaskId: "success", Status: types.SubtitleTaskStatusSuccess, ProcessPct: 100},
+	} {
+		data, err := json.Marshal(task)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(filepath.Join(dir, task.TaskId+".json"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 重命名前进程退出留下的临时文件，内容可能不完整
+	if err := os.WriteFile(filepath.Join(dir, "success.json.tmp"), []byte(`{"task_id":"success","status":`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "crashed.json.tmp"), []byte(`{"task_id":"crashed"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := NewFileSubtitleTaskRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertReloaded := func(repo *FileSubtitleTaskRepo) {
+		t.Helper()
+		tests := []struct {
+			taskId     string
+			status     uint8
+			failReason string
+		}{
+			{"processing", types.SubtitleTaskStatusInterrupted, "服务重启，任务被中断"},
+			{"queued", types.SubtitleTaskStatusInterrupted, "服务重启，任务被中断"},
+			{"expanding", types.SubtitleTaskStatusInterrupted, "服务重启，批量任务的子任务未全部创建"},
+			{"success", types.SubtitleTaskStatusSuccess, ""},
+		}
+		for _, tt := range tests {
+			task, err := repo.Get(tt.taskId)
+			if err != nil {
+				t.Errorf("Get(%s) err = %v", tt.taskId, err)
+				continue
+			}
+			if task.Status != tt.status || task.FailReason != tt.failReason {
+				t.Errorf("Get(%s) = status %d, fail reason %q, want %d, %q", tt.taskId, task.Status, task.FailReason, tt.status, tt.failReason)
+			}
+		}
+		if _, err := repo.Get("crashed"); err != ErrSubtitleTaskNotFound {
+			t.Errorf("Get(crashed) err = %v, want ErrSubtitleTaskNotFound", err)
+		}
+		if _, total, _ := repo.List(SubtitleTaskQuery{}); total != len(tests) {
+			t.Errorf("List() total = %d, want %d", total, len(tests))
+		}
+	}
+	assertReloaded(repo)
+
+	// 中断状态已落盘，再次打开结果不变
+	repo, err = NewFileSubtitleTaskRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertReloaded(repo)
+}
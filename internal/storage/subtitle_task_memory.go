@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"krillin-ai/internal/types"
+	"sync"
+	"time"
+)
+
+// MemorySubtitleTaskRepo 纯内存存储，进程重启后任务丢失
+type MemorySubtitleTaskRepo struct {
+	mu    sync.RWMutex
+	tasks map[string]*types.SubtitleTask
+}
+
+func NewMemorySubtitleTaskRepo() *MemorySubtitleTaskRepo {
+	return &MemorySubtitleTaskRepo{
+		tasks: make(map[string]*types.SubtitleTask),
+	}
+}
+
+func (r *MemorySubtitleTaskRepo) Get(taskId string) (*types.SubtitleTask, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	task, ok := r.tasks[taskId]
+	if !ok {
+		return nil, ErrSubtitleTaskNotFound
+	}
+	return copySubtitleTask(task), nil
+}
+
+func (r *MemorySubtitleTaskRepo) Create(task *types.SubtitleTask) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cp := copySubtitleTask(task)
+	fillSubtitleTaskCreateTime(cp)
+	r.tasks[task.TaskId] = cp
+	return nil
+}
+
+func (r *MemorySubtitleTaskRepo) Update(taskId string, fn func(task *types.SubtitleTask)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	task, ok := r.tasks[taskId]
+	if !ok {
+		return ErrSubtitleTaskNotFound
+	}
+	fn(task)
+	task.UpdateTime = time.Now().Unix()
+	return nil
+}
+
+func (r *MemorySubtitleTaskRepo) Delete(taskId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.tasks, taskId)
+	return nil
+}
+
+func (r *MemorySubtitleTaskRepo) List(query SubtitleTaskQuery) ([]*types.SubtitleTask, int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tasks, total := querySubtitleTasks(r.tasks, query)
+	return tasks, total, nil
+}
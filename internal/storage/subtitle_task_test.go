@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"krillin-ai/internal/types"
+	"math"
+	"testing"
+)
+
+func TestQuerySubtitleTasksOffset(t *testing.T) {
+	tasks := map[string]*types.SubtitleTask{
+		"a": {TaskId: "a", CreateTime: 1},
+		"b": {TaskId: "b", CreateTime: 2},
+		"c": {TaskId: "c", CreateTime: 3},
+	}
+	tests := []struct {
+		offset int
+		limit  int
+		want   []string
+	}{
+		{0, 2, []string{"a", "b"}},
+		{2, 2, []string{"c"}},
+		{3, 2, nil},
+		{-20, 20, nil},
+		{math.MinInt, 20, nil},
+	}
+	for _, tt := range tests {
+		result, total := querySubtitleTasks(tasks, SubtitleTaskQuery{Offset: tt.offset, Limit: tt.limit})
+		if total != 3 || len(result) != len(tt.want) {
+			t.Errorf("querySubtitleTasks(offset %d) = %d tasks, total %d, want %v, total 3", tt.offset, len(result), total, tt.want)
+			continue
+		}
+		for i, task := range result {
+			if task.TaskId != tt.want[i] {
+				t.Errorf("querySubtitleTasks(offset %d)[%d] = %s, want %s", tt.offset, i, task.TaskId, tt.want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// TMX 1.4 中用到的部分，其余元素和属性在导入时忽略
+type tmxDocument struct {
+	XMLName xml.Name  `xml:"tmx"`
+	Version string    `xml:"version,attr"`
+	Header  tmxHeader `xml:"header"`
+	Body    tmxBody   `xml:"body"`
+}
+
+type tmxHeader struct {
+	CreationTool        string `xml:"creationtool,attr"`
+	CreationToolVersion string `xml:"creationtoolversion,attr"`
+	DataType            string `xml:"datatype,attr"`
+	SegType             string `xml:"segtype,attr"`
+	AdminLang           string `xml:"adminlang,attr"`
+	SrcLang             string `xml:"srclang,attr"`
+	OTmf                string `xml:"o-tmf,attr"`
+}
+
+type tmxBody struct {
+	Units []tmxUnit `xml:"tu"`
+}
+
+type tmxUnit struct {
+	SrcLang  string       `xml:"srclang,attr,omitempty"`
+	Variants []tmxVariant `xml:"tuv"`
+}
+
+type tmxVariant struct {
+	Lang string `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+	Seg  string `xml:"seg"`
+}
+
+// EncodeTmx 把翻译记忆导出为TMX 1.4，每条记录对应一个tu
+func EncodeTmx(w io.Writer, units []TranslationUnit) error {
+	doc := tmxDocument{
+		Version: "1.4",
+		Header: tmxHeader{
+			CreationTool:        "KrillinAI",
+			CreationToolVersion: "1.0",
+			DataType:            "plaintext",
+			SegType:             "sentence",
+			AdminLang:           "en",
+			SrcLang:             "*all*",
+			OTmf:                "KrillinAI",
+		},
+	}
+	for _, unit := range units {
+		doc.Body.Units = append(doc.Body.Units, tmxUnit{
+			SrcLang: unit.SourceLanguage,
+			Variants: []tmxVariant{
+				{Lang: unit.SourceLanguage, Seg: unit.Source},
+				{Lang: unit.TargetLanguage, Seg: unit.Target},
+			},
+		})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return fmt.Errorf("EncodeTmx write header err: %w", err)
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return fmt.Errorf("EncodeTmx encode err: %w", err)
+	}
+	return nil
+}
+
+// DecodeTmx 读取TMX文件，每个tu中原文语言以外的每个tuv生成一条记录
+// 原文语言取tu或header上的srclang，都未指定时取第一个tuv
+func DecodeTmx(r io.Reader) ([]TranslationUnit, error) {
+	var doc tmxDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("DecodeTmx decode err: %w", err)
+	}
+	units := make([]TranslationUnit, 0, len(doc.Body.Units))
+	for _, tu := range doc.Body.Units {
+		if len(tu.Variants) < 2 {
+			continue
+		}
+		srcLang := tu.SrcLang
+		if srcLang == "" {
+			srcLang = doc.Header.SrcLang
+		}
+		srcIndex := 0
+		for i, tuv := range tu.Variants {
+			if strings.EqualFold(tuv.Lang, srcLang) {
+				srcIndex = i
+				break
+			}
+		}
+		src := tu.Variants[srcIndex]
+		for i, tuv := range tu.Variants {
+			if i == srcIndex || tuv.Lang == "" {
+				continue
+			}
+			units = append(units, TranslationUnit{
+				SourceLanguage: src.Lang,
+				TargetLanguage: tuv.Lang,
+				Source:         strings.TrimSpace(src.Seg),
+				Target:         strings.TrimSpace(tuv.Seg),
+			})
+		}
+	}
+	return units, nil
+}
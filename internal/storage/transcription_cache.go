@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"krillin-ai/config"
+	"krillin-ai/internal/types"
+	"os"
+	"path/filepath"
+)
+
+// TranscriptionCache 按音频内容和转录参数缓存转录结果，每条结果一个json文件
+type TranscriptionCache struct {
+	dir string
+}
+
+// TranscriptionCacheStore 未开启缓存时为nil
+var TranscriptionCacheStore *TranscriptionCache
+
+func InitTranscriptionCache() error {
+	if !config.Conf.Storage.EnableTranscriptionCache {
+		return nil
+	}
+	dir := filepath.Join(config.Conf.Storage.DataDir, "transcription_cache")
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("InitTranscriptionCache mkdir err: %w", err)
+	}
+	TranscriptionCacheStore = &TranscriptionCache{dir: dir}
+	return nil
+}
+
+// TranscriptionCacheKey 由音频文件内容、转录服务、模型和语言计算缓存键
+func TranscriptionCacheKey(audioFile, provider, model, language string) (string, error) {
+	file, err := os.Open(audioFile)
+	if err != nil {
+		return "", fmt.Errorf("TranscriptionCacheKey open file err: %w", err)
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("TranscriptionCacheKey read file err: %w", err)
+	}
+	// 用不会出现在参数中的分隔符，避免不同参数拼接后相同
+	_, _ = fmt.Fprintf(hash, "\x00%s\x00%s\x00%s", provider, model, language)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (c *TranscriptionCache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// Get 读取缓存，不存在或损坏时返回false
+func (c *TranscriptionCache) Get(key string) (*types.TranscriptionData, bool) {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	var transcriptionData types.TranscriptionData
+	if err = json.Unmarshal(data, &transcriptionData); err != nil {
+		return nil, false
+	}
+	return &transcriptionData, true
+}
+
+func (c *TranscriptionCache) Put(key string, transcriptionData *types.TranscriptionData) error {
+	data, err := json.Marshal(transcriptionData)
+	if err != nil {
+		return fmt.Errorf("TranscriptionCache marshal err: %w", err)
+	}
+	// 同一个音频可能被多个任务同时转录，临时文件名不能只由缓存键决定
+	file, err := os.CreateTemp(c.dir, key+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("TranscriptionCache create temp file err: %w", err)
+	}
+	tmp := file.Name()
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("TranscriptionCache write file err: %w", err)
+	}
+	if err = file.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("TranscriptionCache close file err: %w", err)
+	}
+	if err = os.Rename(tmp, c.path(key)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("TranscriptionCache rename err: %w", err)
+	}
+	return nil
+}
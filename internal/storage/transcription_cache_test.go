@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"krillin-ai/internal/types"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestTranscriptionCacheConcurrentPut(t *testing.T) {
+	cache := &TranscriptionCache{dir: t.TempDir()}
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- cache.Put("key", &types.TranscriptionData{Language: "en", Text: "hello world"})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Put() err = %v", err)
+		}
+	}
+	data, ok := cache.Get("key")
+	if !ok || data.Text != "hello world" {
+		t.Errorf("Get() = %+v, %v, want hello world", data, ok)
+	}
+	// 临时文件都已重命名或清理
+	if tmps, _ := filepath.Glob(filepath.Join(cache.dir, "*.tmp")); len(tmps) != 0 {
+		t.Errorf("temp files left: %v", tmps)
+	}
+	if entries, _ := os.ReadDir(cache.dir); len(entries) != 1 {
+		t.Errorf("cache dir has %d entries, want 1", len(entries))
+	}
+}
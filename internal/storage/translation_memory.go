@@ -0,0 +1,281 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"krillin-ai/config"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// TranslationUnit 翻译记忆中的一条记录，同一目标语言下按规范化后的原文去重
+type TranslationUnit struct {
+	SourceLanguage string `json:"source_language"`
+	TargetLanguage string `json:"target_language"`
+	Source         string `json:"source"`
+	Target         string `json:"target"`
+	UpdateTime     int64  `json:"update_time"`
+}
+
+// TranslationMemory 持久化的翻译记忆，全部记录保存在一个json文件中
+type TranslationMemory struct {
+	mu    sync.RWMutex
+	path  string
+	units map[string]*indexedTranslationUnit
+	// 按目标语言分组，相似查询只需遍历同一目标语言的记录
+	byTarget map[string]map[string]*indexedTranslationUnit
+}
+
+// indexedTranslationUnit 记录及写入时预先计算的规范化原文和字符二元组，查询时不再重复计算
+type indexedTranslationUnit struct {
+	unit       TranslationUnit
+	normalized string
+	length     int
+	grams      map[string]int
+}
+
+// TranslationMemoryStore 未开启翻译记忆时为nil
+var TranslationMemoryStore *TranslationMemory
+
+func InitTranslationMemory() error {
+	if !config.Conf.Storage.EnableTranslationMemory {
+		return nil
+	}
+	if err := os.MkdirAll(config.Conf.Storage.DataDir, os.ModePerm); err != nil {
+		return fmt.Errorf("InitTranslationMemory mkdir err: %w", err)
+	}
+	tm, err := NewTranslationMemory(filepath.Join(config.Conf.Storage.DataDir, "translation_memory.json"))
+	if err != nil {
+		return err
+	}
+	TranslationMemoryStore = tm
+	return nil
+}
+
+// NewTranslationMemory path为空时只保存在内存中
+func NewTranslationMemory(path string) (*TranslationMemory, error) {
+	tm := &TranslationMemory{
+		path:     path,
+		units:    make(map[string]*indexedTranslationUnit),
+		byTarget: make(map[string]map[string]*indexedTranslationUnit),
+	}
+	if path == "" {
+		return tm, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return tm, nil
+		}
+		return nil, fmt.Errorf("NewTranslationMemory read file err: %w", err)
+	}
+	var units []TranslationUnit
+	if err = json.Unmarshal(data, &units); err != nil {
+		return nil, fmt.Errorf("NewTranslationMemory unmarshal err: %w", err)
+	}
+	for _, unit := range units {
+		tm.put(translationUnitKey(unit.TargetLanguage, unit.Source), unit)
+	}
+	return tm, nil
+}
+
+// NormalizeTranslationSource 忽略大小写、多余空白和首尾标点，使写法略有差异的同一句话能够匹配
+func NormalizeTranslationSource(s string) string {
+	s = strings.ToLower(strings.Join(strings.Fields(s), " "))
+	return strings.TrimFunc(s, func(r rune) bool {
+		return unicode.IsPunct(r) || unicode.IsSpace(r) || unicode.IsSymbol(r)
+	})
+}
+
+func translationUnitKey(targetLanguage, source string) string {
+	return targetLanguage + "\x00" + NormalizeTranslationSource(source)
+}
+
+// 调用方需持有锁
+func (tm *TranslationMemory) put(key string, unit TranslationUnit) {
+	normalized := NormalizeTranslationSource(unit.Source)
+	indexed := &indexedTranslationUnit{
+		unit:       unit,
+		normalized: normalized,
+		length:     len([]rune(normalized)),
+		grams:      runeBigrams(normalized),
+	}
+	tm.units[key] = indexed
+	if tm.byTarget[unit.TargetLanguage] == nil {
+		tm.byTarget[unit.TargetLanguage] = make(map[string]*indexedTranslationUnit)
+	}
+	tm.byTarget[unit.TargetLanguage][key] = indexed
+}
+
+// Add 新增或覆盖记录，整批只落盘一次，返回实际写入的条数
+func (tm *TranslationMemory) Add(units []TranslationUnit) (int, error) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	now := time.Now().Unix()
+	added := 0
+	for _, unit := range units {
+		unit.Source = strings.TrimSpace(unit.Source)
+		unit.Target = strings.TrimSpace(unit.Target)
+		if unit.TargetLanguage == "" || NormalizeTranslationSource(unit.Source) == "" || unit.Target == "" {
+			continue
+		}
+		key := translationUnitKey(unit.TargetLanguage, unit.Source)
+		if old, ok := tm.units[key]; ok && old.unit.Target == unit.Target && old.unit.Source == unit.Source {
+			continue
+		}
+		if unit.UpdateTime == 0 {
+			unit.UpdateTime = now
+		}
+		tm.put(key, unit)
+		added++
+	}
+	if added == 0 {
+		return 0, nil
+	}
+	return added, tm.save()
+}
+
+// 调用方需持有锁
+func (tm *TranslationMemory) save() error {
+	if tm.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(tm.sortedUnits(""))
+	if err != nil {
+		return fmt.Errorf("TranslationMemory marshal err: %w", err)
+	}
+	tmp := tm.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("TranslationMemory write file err: %w", err)
+	}
+	if err = os.Rename(tmp, tm.path); err != nil {
+		return fmt.Errorf("TranslationMemory rename err: %w", err)
+	}
+	return nil
+}
+
+// 调用方需持有锁，targetLanguage为空时返回全部记录
+func (tm *TranslationMemory) sortedUnits(targetLanguage string) []TranslationUnit {
+	indexed := tm.units
+	if targetLanguage != "" {
+		indexed = tm.byTarget[targetLanguage]
+	}
+	units := make([]TranslationUnit, 0, len(indexed))
+	for _, unit := range indexed {
+		units = append(units, unit.unit)
+	}
+	sort.Slice(units, func(i, j int) bool {
+		if units[i].TargetLanguage != units[j].TargetLanguage {
+			return units[i].TargetLanguage < units[j].TargetLanguage
+		}
+		return units[i].Source < units[j].Source
+	})
+	return units
+}
+
+// List 按目标语言列出记录，targetLanguage为空时返回全部记录
+func (tm *TranslationMemory) List(targetLanguage string) []TranslationUnit {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	return tm.sortedUnits(targetLanguage)
+}
+
+// Lookup 精确匹配（规范化后相同）原文
+func (tm *TranslationMemory) Lookup(targetLanguage, source string) (string, bool) {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	unit, ok := tm.units[translationUnitKey(targetLanguage, source)]
+	if !ok {
+		return "", false
+	}
+	return unit.unit.Target, true
+}
+
+// Similar 查找与sentences中各句相同或相近的记录，相似度不低于minScore，按相似度从高到低最多返回limit条
+func (tm *TranslationMemory) Similar(targetLanguage string, sentences []string, minScore float64, limit int) []TranslationUnit {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	type scored struct {
+		entry *indexedTranslationUnit
+		score float64
+	}
+	best := make(map[*indexedTranslationUnit]float64)
+	for _, sentence := range sentences {
+		normalized := NormalizeTranslationSource(sentence)
+		if normalized == "" {
+			continue
+		}
+		sentenceGrams := runeBigrams(normalized)
+		sentenceLen := len([]rune(normalized))
+		for _, unit := range tm.byTarget[targetLanguage] {
+			// 长度差距过大的不可能达到阈值，跳过以减少计算
+			if float64(min(unit.length, sentenceLen)) < minScore*float64(max(unit.length, sentenceLen)) {
+				continue
+			}
+			score := 1.0
+			if unit.normalized != normalized {
+				score = diceCoefficient(sentenceGrams, unit.grams)
+			}
+			if score >= minScore && score > best[unit] {
+				best[unit] = score
+			}
+		}
+	}
+
+	matches := make([]scored, 0, len(best))
+	for unit, score := range best {
+		matches = append(matches, scored{entry: unit, score: score})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].entry.unit.Source < matches[j].entry.unit.Source
+	})
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	units := make([]TranslationUnit, 0, len(matches))
+	for _, match := range matches {
+		units = append(units, match.entry.unit)
+	}
+	return units
+}
+
+// 按字符二元组计算相似度，对不以空格分词的语言同样适用
+func runeBigrams(s string) map[string]int {
+	runes := []rune(s)
+	grams := make(map[string]int)
+	if len(runes) == 1 {
+		grams[s]++
+		return grams
+	}
+	for i := 0; i+1 < len(runes); i++ {
+		grams[string(runes[i:i+2])]++
+	}
+	return grams
+}
+
+func diceCoefficient(a, b map[string]int) float64 {
+	total := 0
+	for _, n := range a {
+		total += n
+	}
+	for _, n := range b {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	common := 0
+	for gram, n := range a {
+		common += min(n, b[gram])
+	}
+	return 2 * float64(common) / float64(total)
+}
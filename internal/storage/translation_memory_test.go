@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTranslationMemoryLookupAndSimilar(t *testing.T) {
+	tm, err := NewTranslationMemory("")
+	if err != nil {
+		t.Fatalf("NewTranslationMemory() err = %v", err)
+	}
+	added, err := tm.Add([]TranslationUnit{
+		{SourceLanguage: "en", TargetLanguage: "zh_cn", Source: "Welcome back to the channel.", Target: "欢迎回到频道。"},
+		{SourceLanguage: "en", TargetLanguage: "zh_cn", Source: "This video is sponsored by our partner", Target: "本视频由我们的合作伙伴赞助"},
+		{SourceLanguage: "en", TargetLanguage: "ja", Source: "Welcome back to the channel.", Target: "チャンネルへようこそ。"},
+	})
+	if err != nil || added != 3 {
+		t.Fatalf("Add() = %d, %v, want 3, nil", added, err)
+	}
+
+	// 大小写、空白和首尾标点不同仍视为同一句
+	if got, ok := tm.Lookup("zh_cn", "  welcome back to the   channel! "); !ok || got != "欢迎回到频道。" {
+		t.Errorf("Lookup() = %q, %v, want 欢迎回到频道。, true", got, ok)
+	}
+	if _, ok := tm.Lookup("zh_cn", "Welcome to the show."); ok {
+		t.Errorf("Lookup() matched an unrelated sentence")
+	}
+
+	units := tm.Similar("zh_cn", []string{"This video is sponsored by our partners.", "Something else entirely."}, 0.85, 10)
+	if len(units) != 1 || units[0].Target != "本视频由我们的合作伙伴赞助" {
+		t.Errorf("Similar() = %+v, want the sponsor sentence only", units)
+	}
+}
+
+func TestTranslationMemoryIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "translation_memory.json")
+	tm, err := NewTranslationMemory(path)
+	if err != nil {
+		t.Fatalf("NewTranslationMemory() err = %v", err)
+	}
+	units := []TranslationUnit{
+		{SourceLanguage: "en", TargetLanguage: "zh_cn", Source: "Thanks for watching", Target: "感谢观看"},
+		{SourceLanguage: "en", TargetLanguage: "zh_cn", Source: "See you next time", Target: "下次见"},
+		{SourceLanguage: "en", TargetLanguage: "ja", Source: "Thanks for watching!", Target: "ご視聴ありがとうございました"},
+	}
+	// 整批写入，文件中包含全部记录
+	if added, err := tm.Add(units); err != nil || added != 3 {
+		t.Fatalf("Add() = %d, %v, want 3, nil", added, err)
+	}
+	if _, err = os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temp file left, stat err = %v", err)
+	}
+
+	reloaded, err := NewTranslationMemory(path)
+	if err != nil {
+		t.Fatalf("NewTranslationMemory() reload err = %v", err)
+	}
+	for _, store := range []*TranslationMemory{tm, reloaded} {
+		// 只匹配同一目标语言的记录
+		got := store.Similar("ja", []string{"thanks for watching."}, 0.85, 10)
+		if len(got) != 1 || got[0].Target != "ご視聴ありがとうございました" {
+			t.Errorf("Similar(ja) = %+v, want the ja unit only", got)
+		}
+		if got := store.List("zh_cn"); len(got) != 2 || got[0].Source != "See you next time" || got[1].Source != "Thanks for watching" {
+			t.Errorf("List(zh_cn) = %+v", got)
+		}
+		if got := store.List(""); len(got) != 3 {
+			t.Errorf("List() = %d units, want 3", len(got))
+		}
+	}
+
+	// 覆盖同一句时索引中的记录也被替换
+	if added, err := tm.Add([]TranslationUnit{{SourceLanguage: "en", TargetLanguage: "zh_cn", Source: "see you next time!", Target: "下次再见"}}); err != nil || added != 1 {
+		t.Fatalf("Add() overwrite = %d, %v, want 1, nil", added, err)
+	}
+	got := tm.Similar("zh_cn", []string{"See you next time"}, 0.85, 10)
+	if len(got) != 1 || got[0].Target != "下次再见" {
+		t.Errorf("Similar() after overwrite = %+v, want 下次再见", got)
+	}
+}
+
+func TestTmxRoundTrip(t *testing.T) {
+	units := []TranslationUnit{
+		{SourceLanguage: "en", TargetLanguage: "zh_cn", Source: "Tom & Jerry <3", Target: "猫和老鼠"},
+	}
+	var buf bytes.Buffer
+	if err := EncodeTmx(&buf, units); err != nil {
+		t.Fatalf("EncodeTmx() err = %v", err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(`xml:lang="zh_cn"`)) {
+		t.Errorf("EncodeTmx() output missing xml:lang attribute:\n%s", buf.String())
+	}
+
+	got, err := DecodeTmx(&buf)
+	if err != nil {
+		t.Fatalf("DecodeTmx() err = %v", err)
+	}
+	if len(got) != 1 || got[0] != units[0] {
+		t.Errorf("DecodeTmx() = %+v, want %+v", got, units)
+	}
+}
+
+func TestDecodeTmxMultipleTargets(t *testing.T) {
+	doc := `<?xml version="1.0" encoding="UTF-8"?>
+<tmx version="1.4">
+  <header srclang="en-US" datatype="plaintext" segtype="sentence" adminlang="en" creationtool="x" creationtoolversion="1" o-tmf="x"/>
+  <body>
+    <tu>
+      <tuv xml:lang="de-DE"><seg>Hallo</seg></tuv>
+      <tuv xml:lang="en-US"><seg>Hello</seg></tuv>
+      <tuv xml:lang="fr-FR"><seg>Bonjour</seg></tuv>
+    </tu>
+  </body>
+</tmx>`
+	got, err := DecodeTmx(bytes.NewBufferString(doc))
+	if err != nil {
+		t.Fatalf("DecodeTmx() err = %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("DecodeTmx() returned %d units, want 2", len(got))
+	}
+	for _, unit := range got {
+		if unit.SourceLanguage != "en-US" || unit.Source != "Hello" {
+			t.Errorf("DecodeTmx() unit = %+v, want source en-US Hello", unit)
+		}
+	}
+}
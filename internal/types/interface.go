@@ -1,9 +1,11 @@
 package types
 
+import "context"
+
 type ChatCompleter interface {
-	ChatCompletion(query string) (string, error)
+	ChatCompletion(ctx context.Context, query string) (string, error)
 }
 
 type Transcriber interface {
-	Transcription(audioFile, language, wordDir string) (*TranscriptionData, error)
+	Transcription(ctx context.Context, audioFile, language, wordDir string) (*TranscriptionData, error)
 }
@@ -81,9 +81,23 @@ var TranslateVideoTitleAndDescriptionPrompt = `你是一个专业的翻译专家
 %s
 `
 
+// 导入字幕时逐条翻译，字幕已经分好句并带有时间戳，只需保持条数和编号不变
+var TranslateSubtitleLinesPrompt = `你是一个专业的字幕翻译专家，请把下面每一行字幕翻译成%s，要求如下：
+ - 每行以[编号]开头，输出时保留相同的编号，每个编号输出一行译文，不要合并、拆分或遗漏任何一行
+ - 结合上下文理解句意，译文自然流畅，符合字幕的表达习惯
+ - 只输出译文，不要输出原文、解释或其它内容
+ 以下全部是需要翻译的字幕：
+`
+
+// 翻译记忆中相同或相近句子的历史译文，拼接在拆分翻译的Prompt之前
+var TranslationMemoryReferencePrompt = `以下是此前已确认的译文，仅作为参考，不需要输出。如果待翻译内容中出现相同或相近的句子，请沿用其中的译法和术语，保持译文一致：
+%s
+`
+
 type SmallAudio struct {
 	AudioFile         string
 	Num               int
+	StartOffset       float64 // 在原音频中的开始时间，单位：秒
 	TranscriptionData *TranscriptionData
 	SrtNoTsFile       string
 }
@@ -126,6 +140,24 @@ const (
 	SubtitleTaskStatusProcessing uint8 = iota + 1
 	SubtitleTaskStatusSuccess
 	SubtitleTaskStatusFailed
+	SubtitleTaskStatusInterrupted // 服务重启时仍在处理中的任务
+	SubtitleTaskStatusCancelled
+	SubtitleTaskStatusQueued // 排队等待执行
+)
+
+// 是否使用视频平台提供的字幕代替语音识别
+const (
+	CaptionPolicyNever  = "never"  // 始终语音识别
+	CaptionPolicyManual = "manual" // 只使用作者上传的字幕
+	CaptionPolicyAuto   = "auto"   // 优先使用作者上传的字幕，没有时使用平台自动生成的字幕
+)
+
+// 任务原文字幕的来源
+const (
+	TranscriptSourceAsr           = "asr"            // 语音识别
+	TranscriptSourceManualCaption = "manual_caption" // 平台上作者上传的字幕
+	TranscriptSourceAutoCaption   = "auto_caption"   // 平台自动生成的字幕
+	TranscriptSourceImported      = "imported"       // 用户导入的字幕
 )
 
 const (
@@ -133,6 +165,7 @@ const (
 	SubtitleTaskVideoFileName                           = "origin_video.mp4"
 	SubtitleTaskSplitAudioFileNamePrefix                = "split_audio"
 	SubtitleTaskSplitAudioFileNamePattern               = SubtitleTaskSplitAudioFileNamePrefix + "_%03d.mp3"
+	SubtitleTaskSplitAudioListFileName                  = SubtitleTaskSplitAudioFileNamePrefix + "_list.csv"
 	SubtitleTaskSplitAudioTxtFileNamePattern            = "split_audio_txt_%d.txt"
 	SubtitleTaskSplitAudioWordsFileNamePattern          = "split_audio_words_%d.txt"
 	SubtitleTaskSplitSrtNoTimestampFileNamePattern      = "srt_no_ts_%d.srt"
@@ -151,6 +184,12 @@ const (
 	SubtitleTaskTransferredVerticalVideoFileName        = "transferred_vertical_video.mp4"
 	SubtitleTaskHorizontalEmbedVideoFileName            = "horizontal_embed.mp4"
 	SubtitleTaskVerticalEmbedVideoFileName              = "vertical_embed.mp4"
+	SubtitleTaskPlatformCaptionDirName                  = "captions"
+	SubtitleTaskPlatformCaptionFileName                 = "platform_caption.srt"
+	SubtitleTaskCoverFileNamePrefix                     = "cover"
+	SubtitleTaskChaptersTextFileName                    = "chapters.txt"            // YouTube简介格式的章节列表
+	SubtitleTaskChaptersSrtFileName                     = "chapters.srt"            // 每个章节开始时显示章节标题
+	SubtitleTaskChaptersFfmetadataFileName              = "chapters_ffmetadata.txt" // 合成视频时写入的章节
 )
 
 const (
@@ -256,7 +295,11 @@ type SubtitleTaskStepParam struct {
 	EmbedSubtitleVideoType      string // 合成字幕嵌入的视频类型 none不嵌入 horizontal横屏 vertical竖屏
 	VerticalVideoMajorTitle     string // 合成竖屏视频的主标题
 	VerticalVideoMinorTitle     string
-	MaxWordOneLine              int // 字幕一行最多显示多少个字
+	MaxWordOneLine              int      // 字幕一行最多显示多少个字
+	StepNames                   []string // 任务要执行的步骤，为空时使用默认流程
+	SubtitleSourcePath          string   // 导入的源语言字幕文件，不为空时不再转录
+	CaptionPolicy               string   // 是否使用平台字幕，见CaptionPolicy*
+	TranscriptSource            string   // 原文字幕的来源，见TranscriptSource*
 }
 
 type SrtSentence struct {
@@ -281,26 +324,82 @@ type SubtitleInfo struct {
 }
 
 type SubtitleTask struct {
-	Id                    uint64         `json:"id" gorm:"column:id"`                                         // 自增id
-	TaskId                string         `json:"task_id" gorm:"column:task_id"`                               // 任务id
-	Title                 string         `json:"title" gorm:"column:title"`                                   // 标题
-	Description           string         `json:"description" gorm:"column:description"`                       // 描述
-	TranslatedTitle       string         `json:"translated_title" gorm:"column:translated_title"`             // 翻译后的标题
-	TranslatedDescription string         `json:"translated_description" gorm:"column:translated_description"` // 翻译后的描述
-	OriginLanguage        string         `json:"origin_language" gorm:"column:origin_language"`               // 视频原语言
-	TargetLanguage        string         `json:"target_language" gorm:"column:target_language"`               // 翻译任务的目标语言
-	VideoSrc              string         `json:"video_src" gorm:"column:video_src"`                           // 视频地址
-	Status                uint8          `json:"status" gorm:"column:status"`                                 // 1-处理中,2-成功,3-失败
-	LastSuccessStepNum    uint8          `json:"last_success_step_num" gorm:"column:last_success_step_num"`   // 最后成功的子任务序号，用于任务恢复
-	FailReason            string         `json:"fail_reason" gorm:"column:fail_reason"`                       // 失败原因
-	ProcessPct            uint8          `json:"process_percent" gorm:"column:process_percent"`               // 处理进度
-	Duration              uint32         `json:"duration" gorm:"column:duration"`                             // 视频时长
-	SrtNum                int            `json:"srt_num" gorm:"column:srt_num"`                               // 字幕数量
-	SubtitleInfos         []SubtitleInfo `gorm:"foreignKey:TaskId;references:TaskId"`
-	Cover                 string         `json:"cover" gorm:"column:cover"`                             // 封面
-	SpeechDownloadUrl     string         `json:"speech_download_url" gorm:"column:speech_download_url"` // 语音文件下载地址
-	CreateTime            int64          `json:"create_time" gorm:"column:create_time;autoCreateTime"`  // 创建时间
-	UpdateTime            int64          `json:"update_time" gorm:"column:update_time;autoUpdateTime"`  // 更新时间
+	Id                    uint64            `json:"id" gorm:"column:id"`                                         // 自增id
+	TaskId                string            `json:"task_id" gorm:"column:task_id"`                               // 任务id
+	Title                 string            `json:"title" gorm:"column:title"`                                   // 标题
+	Description           string            `json:"description" gorm:"column:description"`                       // 描述
+	TranslatedTitle       string            `json:"translated_title" gorm:"column:translated_title"`             // 翻译后的标题
+	TranslatedDescription string            `json:"translated_description" gorm:"column:translated_description"` // 翻译后的描述
+	OriginLanguage        string            `json:"origin_language" gorm:"column:origin_language"`               // 视频原语言
+	TargetLanguage        string            `json:"target_language" gorm:"column:target_language"`               // 翻译任务的目标语言
+	VideoSrc              string            `json:"video_src" gorm:"column:video_src"`                           // 视频地址
+	LocalInputs           []string          `json:"local_inputs" gorm:"column:local_inputs;serializer:json"`     // 任务引用的上传文件，如视频、导入的字幕、声音克隆源
+	Status                uint8             `json:"status" gorm:"column:status"`                                 // 1-处理中,2-成功,3-失败,4-中断,5-取消,6-排队中
+	LastSuccessStepNum    uint8             `json:"last_success_step_num" gorm:"column:last_success_step_num"`   // 最后成功的子任务序号，用于任务恢复
+	FailReason            string            `json:"fail_reason" gorm:"column:fail_reason"`                       // 失败原因
+	ProcessPct            uint8             `json:"process_percent" gorm:"column:process_percent"`               // 处理进度
+	Duration              uint32            `json:"duration" gorm:"column:duration"`                             // 视频时长
+	SrtNum                int               `json:"srt_num" gorm:"column:srt_num"`                               // 字幕数量
+	SubtitleInfos         []SubtitleInfo    `gorm:"foreignKey:TaskId;references:TaskId"`
+	Cover                 string            `json:"cover" gorm:"column:cover"`                                           // 封面
+	SpeechDownloadUrl     string            `json:"speech_download_url" gorm:"column:speech_download_url"`               // 语音文件下载地址
+	CallbackUrl           string            `json:"callback_url" gorm:"column:callback_url"`                             // 任务结束后的回调地址
+	CallbackSecret        string            `json:"callback_secret" gorm:"column:callback_secret"`                       // 回调签名密钥
+	WebhookDeliveries     []WebhookDelivery `json:"webhook_deliveries" gorm:"column:webhook_deliveries;serializer:json"` // 回调投递记录
+	Timeline              []TimelineEntry   `json:"timeline" gorm:"column:timeline;serializer:json"`                     // 各步骤及分段的耗时记录
+	CacheKey              string            `json:"cache_key" gorm:"column:cache_key"`                                   // 由视频来源和影响产物的参数计算，用于复用结果
+	CacheHitTaskId        string            `json:"cache_hit_task_id" gorm:"column:cache_hit_task_id"`                   // 复用了哪个任务的结果
+	TaskType              string            `json:"task_type" gorm:"column:task_type"`                                   // 空为单个视频任务，batch为批量任务
+	ParentTaskId          string            `json:"parent_task_id" gorm:"column:parent_task_id"`                         // 所属的批量任务
+	ChildTaskIds          []string          `json:"child_task_ids" gorm:"column:child_task_ids;serializer:json"`         // 批量任务展开后的子任务
+	BatchExpanding        bool              `json:"batch_expanding" gorm:"column:batch_expanding"`                       // 批量任务是否仍在展开，服务重启时仍为true说明子任务不完整
+	ArchiveDownloadUrl    string            `json:"archive_download_url" gorm:"column:archive_download_url"`             // 批量任务所有产物的压缩包下载地址
+	TranscriptSource      string            `json:"transcript_source" gorm:"column:transcript_source"`                   // 原文字幕来源：asr,manual_caption,auto_caption,imported
+	Uploader              string            `json:"uploader" gorm:"column:uploader"`                                     // 视频作者
+	UploadDate            string            `json:"upload_date" gorm:"column:upload_date"`                               // 视频发布日期，格式2006-01-02
+	Chapters              []VideoChapter    `json:"chapters" gorm:"column:chapters;serializer:json"`                     // 视频章节
+	CreateTime            int64             `json:"create_time" gorm:"column:create_time;autoCreateTime"`                // 创建时间
+	UpdateTime            int64             `json:"update_time" gorm:"column:update_time;autoUpdateTime"`                // 更新时间
+}
+
+const (
+	SubtitleTaskTypeBatch = "batch"
+)
+
+type VideoChapter struct {
+	StartTime       float64 `json:"start_time"` // 秒
+	EndTime         float64 `json:"end_time"`
+	Title           string  `json:"title"`
+	TranslatedTitle string  `json:"translated_title"`
+}
+
+type WebhookDelivery struct {
+	DeliveryId string `json:"delivery_id"` // 同一次投递的多次重试共用一个id
+	Event      string `json:"event"`
+	Attempt    int    `json:"attempt"`
+	StatusCode int    `json:"status_code"`
+	Error      string `json:"error"`
+	Success    bool   `json:"success"`
+	DurationMs int64  `json:"duration_ms"`
+	Time       int64  `json:"time"`
+}
+
+const (
+	TimelineKindStep       = "step"
+	TimelineKindTranscribe = "transcribe"
+	TimelineKindTranslate  = "translate"
+	TimelineKindTts        = "tts"
+)
+
+type TimelineEntry struct {
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`       // 步骤名
+	Segment    int    `json:"segment"`    // 音频分段序号或字幕序号，步骤级记录为0
+	StartTime  int64  `json:"start_time"` // unix毫秒
+	EndTime    int64  `json:"end_time"`
+	DurationMs int64  `json:"duration_ms"`
+	Attempts   int    `json:"attempts"`
+	Error      string `json:"error"`
 }
 
 type Word struct {
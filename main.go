@@ -7,6 +7,8 @@ import (
 	"krillin-ai/config"
 	"krillin-ai/internal/deps"
 	"krillin-ai/internal/router"
+	"krillin-ai/internal/service"
+	"krillin-ai/internal/storage"
 	"krillin-ai/log"
 )
 
@@ -25,12 +27,33 @@ func main() {
 		return
 	}
 
+	err = storage.InitSubtitleTaskRepo()
+	if err != nil {
+		log.GetLogger().Error("初始化任务存储失败", zap.Error(err))
+		return
+	}
+
+	err = storage.InitTranscriptionCache()
+	if err != nil {
+		log.GetLogger().Error("初始化转录缓存失败", zap.Error(err))
+		return
+	}
+
+	err = storage.InitTranslationMemory()
+	if err != nil {
+		log.GetLogger().Error("初始化翻译记忆失败", zap.Error(err))
+		return
+	}
+
 	err = deps.CheckDependency()
 	if err != nil {
 		log.GetLogger().Error("依赖环境准备失败", zap.Error(err))
 		return
 	}
 
+	service.StartStorageJanitor()
+	service.RestoreSubtitleBatchTasks()
+
 	gin.SetMode(gin.ReleaseMode)
 	app := App{
 		Engine: gin.Default(),
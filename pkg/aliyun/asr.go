@@ -1,6 +1,7 @@
 package aliyun
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/google/uuid"
@@ -34,16 +35,16 @@ const (
 
 var dialer = websocket.DefaultDialer
 
-func (c AsrClient) Transcription(audioFile, language, workDir string) (*types.TranscriptionData, error) {
+func (c AsrClient) Transcription(ctx context.Context, audioFile, language, workDir string) (*types.TranscriptionData, error) {
 	// 处理音频
-	processedAudioFile, err := processAudio(audioFile)
+	processedAudioFile, err := processAudio(ctx, audioFile)
 	if err != nil {
 		log.GetLogger().Error("处理音频失败", zap.Error(err), zap.String("audio file", audioFile))
 		return nil, err
 	}
 
 	// 连接WebSocket服务
-	conn, err := connectWebSocket(c.BailianApiKey)
+	conn, err := connectWebSocket(ctx, c.BailianApiKey)
 	if err != nil {
 		log.GetLogger().Error("连接WebSocket失败", zap.Error(err), zap.String("audio file", audioFile))
 		return nil, err
@@ -52,7 +53,7 @@ func (c AsrClient) Transcription(audioFile, language, workDir string) (*types.Tr
 
 	// 启动一个goroutine来接收结果
 	taskStarted := make(chan bool)
-	taskDone := make(chan bool)
+	taskDone := make(chan error, 1)
 
 	words := make([]types.Word, 0)
 	text := ""
@@ -68,7 +69,7 @@ func (c AsrClient) Transcription(audioFile, language, workDir string) (*types.Tr
 	waitForTaskStarted(taskStarted)
 
 	// 发送待识别音频文件流
-	if err := sendAudioData(conn, processedAudioFile); err != nil {
+	if err := sendAudioData(ctx, conn, processedAudioFile); err != nil {
 		log.GetLogger().Error("发送音频数据失败", zap.Error(err))
 	}
 
@@ -78,7 +79,17 @@ func (c AsrClient) Transcription(audioFile, language, workDir string) (*types.Tr
 	}
 
 	// 等待任务完成或失败
-	<-taskDone
+	select {
+	case err = <-taskDone:
+		if err != nil {
+			// 连接中断或任务失败时已收到的只是部分结果，返回错误由调用方重试
+			log.GetLogger().Error("识别任务失败", zap.Error(err), zap.String("audio file", audioFile))
+			return nil, err
+		}
+	case <-ctx.Done():
+		log.GetLogger().Info("识别任务被取消", zap.String("audio file", audioFile))
+		return nil, ctx.Err()
+	}
 
 	if len(words) == 0 {
 		log.GetLogger().Info("识别结果为空", zap.String("audio file", audioFile))
@@ -152,10 +163,10 @@ type Event struct {
 }
 
 // 把音频处理成单声道、16k采样率
-func processAudio(filePath string) (string, error) {
+func processAudio(ctx context.Context, filePath string) (string, error) {
 	dest := strings.ReplaceAll(filePath, filepath.Ext(filePath), "_mono_16K.mp3")
 	cmdArgs := []string{"-i", filePath, "-ac", "1", "-ar", "16000", "-b:a", "192k", dest}
-	cmd := exec.Command(storage.FfmpegPath, cmdArgs...)
+	cmd := exec.CommandContext(ctx, storage.FfmpegPath, cmdArgs...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		log.GetLogger().Error("处理音频失败", zap.Error(err), zap.String("audio file", filePath), zap.String("output", string(output)))
@@ -165,22 +176,24 @@ func processAudio(filePath string) (string, error) {
 }
 
 // 连接WebSocket服务
-func connectWebSocket(apiKey string) (*websocket.Conn, error) {
+func connectWebSocket(ctx context.Context, apiKey string) (*websocket.Conn, error) {
 	header := make(http.Header)
 	header.Add("X-DashScope-DataInspection", "enable")
 	header.Add("Authorization", fmt.Sprintf("bearer %s", apiKey))
-	conn, _, err := dialer.Dial(wsURL, header)
+	conn, _, err := dialer.DialContext(ctx, wsURL, header)
 	return conn, err
 }
 
-// 启动一个goroutine异步接收WebSocket消息
-func startResultReceiver(conn *websocket.Conn, words *[]types.Word, text *string, taskStarted chan<- bool, taskDone chan<- bool) {
+// 启动一个goroutine异步接收WebSocket消息，任务完成时向taskDone发送nil，失败或连接断开时发送错误
+func startResultReceiver(conn *websocket.Conn, words *[]types.Word, text *string, taskStarted chan<- bool, taskDone chan<- error) {
 	go func() {
 		for {
 			_, message, err := conn.ReadMessage()
 			if err != nil {
-				log.GetLogger().Error("解析服务器消息失败：", zap.Error(err))
-				continue
+				// 连接已断开（包括任务取消时主动关闭），不再继续读取
+				log.GetLogger().Error("读取服务器消息失败：", zap.Error(err))
+				taskDone <- fmt.Errorf("读取服务器消息失败: %w", err)
+				return
 			}
 			currentEvent := Event{}
 			err = json.Unmarshal(message, &currentEvent)
@@ -259,7 +272,7 @@ func waitForTaskStarted(taskStarted chan bool) {
 }
 
 // 发送音频数据
-func sendAudioData(conn *websocket.Conn, filePath string) error {
+func sendAudioData(ctx context.Context, conn *websocket.Conn, filePath string) error {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return err
@@ -268,6 +281,9 @@ func sendAudioData(conn *websocket.Conn, filePath string) error {
 
 	buf := make([]byte, 1024) // 100ms的音频大约1024字节
 	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		n, err := file.Read(buf)
 		if n == 0 {
 			break
@@ -311,7 +327,7 @@ func generateFinishTaskCmd(taskID string) (string, error) {
 }
 
 // 处理事件
-func handleEvent(conn *websocket.Conn, event *Event, taskStarted chan<- bool, taskDone chan<- bool) bool {
+func handleEvent(conn *websocket.Conn, event *Event, taskStarted chan<- bool, taskDone chan<- error) bool {
 	switch event.Header.Event {
 	case "task-started":
 		log.GetLogger().Info("收到task-started事件", zap.String("taskID", event.Header.TaskID))
@@ -320,12 +336,12 @@ func handleEvent(conn *websocket.Conn, event *Event, taskStarted chan<- bool, ta
 		log.GetLogger().Info("收到result-generated事件", zap.String("当前text", event.Payload.Output.Sentence.Text))
 	case "task-finished":
 		log.GetLogger().Info("收到task-finished事件，任务完成", zap.String("taskID", event.Header.TaskID))
-		taskDone <- true
+		taskDone <- nil
 		return true
 	case "task-failed":
 		log.GetLogger().Info("收到task-failed事件", zap.String("taskID", event.Header.TaskID))
 		handleTaskFailed(event, conn)
-		taskDone <- true
+		taskDone <- fmt.Errorf("识别任务失败: %s %s", event.Header.ErrorCode, event.Header.ErrorMessage)
 		return true
 	default:
 		log.GetLogger().Info("未知事件：", zap.String("event", event.Header.Event))
@@ -0,0 +1,72 @@
+package aliyun
+
+import (
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+	"krillin-ai/internal/types"
+	"krillin-ai/log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStartResultReceiver(t *testing.T) {
+	log.Logger = zap.NewNop()
+	tests := []struct {
+		name    string
+		events  []string
+		wantErr bool
+	}{
+		{
+			name:    "finished",
+			events:  []string{`{"header":{"event":"task-finished"}}`},
+			wantErr: false,
+		},
+		{
+			name:    "failed",
+			events:  []string{`{"header":{"event":"task-failed","error_code":"InvalidParameter"}}`},
+			wantErr: true,
+		},
+		{
+			name: "dropped after a partial result",
+			events: []string{`{"header":{"event":"result-generated"},"payload":{"output":{"sentence":{"begin_time":0,"end_time":1000,"text":"hello",` +
+				`"words":[{"begin_time":0,"end_time":1000,"text":"hello"}]}}}}`},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+				if err != nil {
+					return
+				}
+				for _, event := range tt.events {
+					_ = conn.WriteMessage(websocket.TextMessage, []byte(event))
+				}
+				conn.Close()
+			}))
+			defer server.Close()
+			conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			words := make([]types.Word, 0)
+			text := ""
+			taskDone := make(chan error, 1)
+			startResultReceiver(conn, &words, &text, make(chan bool, 1), taskDone)
+			select {
+			case err = <-taskDone:
+				if (err != nil) != tt.wantErr {
+					t.Errorf("taskDone = %v, wantErr %v", err, tt.wantErr)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("receiver did not finish")
+			}
+		})
+	}
+}
@@ -19,7 +19,7 @@ func NewChatClient(apiKey string) *ChatClient {
 	}
 }
 
-func (c ChatClient) ChatCompletion(query string) (string, error) {
+func (c ChatClient) ChatCompletion(ctx context.Context, query string) (string, error) {
 	req := goopenai.ChatCompletionRequest{
 		Model: "qwen-plus",
 		Messages: []goopenai.ChatCompletionMessage{
@@ -34,7 +34,7 @@ func (c ChatClient) ChatCompletion(query string) (string, error) {
 		},
 	}
 
-	resp, err := c.CreateChatCompletion(context.Background(), req)
+	resp, err := c.CreateChatCompletion(ctx, req)
 	if err != nil {
 		log.GetLogger().Error("aliyun openai create chat completion failed", zap.Error(err))
 		return "", err
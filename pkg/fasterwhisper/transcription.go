@@ -1,6 +1,7 @@
 package fasterwhisper
 
 import (
+	"context"
 	"encoding/json"
 	"go.uber.org/zap"
 	"krillin-ai/internal/storage"
@@ -12,7 +13,7 @@ import (
 	"strings"
 )
 
-func (c *FastwhisperProcessor) Transcription(audioFile, language, workDir string) (*types.TranscriptionData, error) {
+func (c *FastwhisperProcessor) Transcription(ctx context.Context, audioFile, language, workDir string) (*types.TranscriptionData, error) {
 	cmdArgs := []string{
 		"--model_dir", "./models/",
 		"--model", c.Model,
@@ -22,9 +23,12 @@ func (c *FastwhisperProcessor) Transcription(audioFile, language, workDir string
 		"--output_dir", workDir,
 		audioFile,
 	}
-	cmd := exec.Command(storage.FasterwhisperPath, cmdArgs...)
+	cmd := exec.CommandContext(ctx, storage.FasterwhisperPath, cmdArgs...)
 	log.GetLogger().Info("FastwhisperProcessor转录开始", zap.String("cmd", cmd.String()))
 	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 	if err != nil && !strings.Contains(string(output), "Subtitles are written to") {
 		log.GetLogger().Error("FastwhisperProcessor  cmd 执行失败", zap.String("output", string(output)), zap.Error(err))
 		return nil, err
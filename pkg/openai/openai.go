@@ -9,7 +9,7 @@ import (
 	"krillin-ai/log"
 )
 
-func (c *Client) ChatCompletion(query string) (string, error) {
+func (c *Client) ChatCompletion(ctx context.Context, query string) (string, error) {
 	req := openai.ChatCompletionRequest{
 		Model: openai.GPT4oMini20240718,
 		Messages: []openai.ChatCompletionMessage{
@@ -29,7 +29,7 @@ func (c *Client) ChatCompletion(query string) (string, error) {
 		req.Model = config.Conf.Openai.Model
 	}
 
-	stream, err := c.client.CreateChatCompletionStream(context.Background(), req)
+	stream, err := c.client.CreateChatCompletionStream(ctx, req)
 	if err != nil {
 		log.GetLogger().Error("openai create chat completion stream failed", zap.Error(err))
 		return "", err
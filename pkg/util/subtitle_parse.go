@@ -0,0 +1,170 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// 00:01:02,345 或 00:01:02.345，VTT中小时可以省略
+	subtitleTimePattern = regexp.MustCompile(`^(?:(\d+):)?(\d{1,2}):(\d{1,2})[,.](\d{1,3})$`)
+	// VTT中的<c>、<i>、<00:00:01.000>等标签以及SRT中的<font>等标签
+	subtitleTagPattern = regexp.MustCompile(`<[^>]*>`)
+)
+
+// ParseSubtitleFile 解析SRT或VTT字幕文件，每条字幕转为一个SrtBlock，原文放在OriginLanguageSentence
+func ParseSubtitleFile(subtitleFile string) ([]*SrtBlock, error) {
+	file, err := os.Open(subtitleFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseSubtitle(file)
+}
+
+// ParseSubtitle 解析SRT或VTT字幕内容，时间戳统一为SRT格式，编号从1开始重新生成，没有文字的字幕被忽略
+func ParseSubtitle(r io.Reader) ([]*SrtBlock, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	var (
+		blocks    []*SrtBlock
+		timestamp string
+		texts     []string
+		skipBlock bool // VTT的NOTE、STYLE、REGION块
+		first     = true
+	)
+	flush := func() {
+		text := strings.TrimSpace(strings.Join(texts, " "))
+		if timestamp != "" && text != "" {
+			blocks = append(blocks, &SrtBlock{
+				Index:                  len(blocks) + 1,
+				Timestamp:              timestamp,
+				OriginLanguageSentence: text,
+			})
+		}
+		timestamp = ""
+		texts = nil
+		skipBlock = false
+	}
+
+	for scanner.Scan() {
+		raw := scanner.Text()
+		line := strings.TrimSpace(raw)
+		if first {
+			line = strings.TrimPrefix(line, "\ufeff")
+			first = false
+			if strings.HasPrefix(line, "WEBVTT") {
+				skipBlock = true
+				continue
+			}
+		}
+		if line == "" {
+			// YouTube自动字幕在时间戳后有一行只有空格，不是字幕块的结尾
+			if raw != "" && timestamp != "" && len(texts) == 0 {
+				continue
+			}
+			flush()
+			continue
+		}
+		if skipBlock {
+			continue
+		}
+		if timestamp == "" {
+			if strings.Contains(line, "-->") {
+				start, end, err := parseSubtitleTimeRange(line)
+				if err != nil {
+					return nil, err
+				}
+				timestamp = fmt.Sprintf("%s --> %s", formatSubtitleTime(start), formatSubtitleTime(end))
+				continue
+			}
+			if line == "NOTE" || strings.HasPrefix(line, "NOTE ") || line == "STYLE" || line == "REGION" {
+				skipBlock = true
+			}
+			// 时间戳之前的SRT编号或VTT的cue标识
+			continue
+		}
+		line = strings.TrimSpace(subtitleTagPattern.ReplaceAllString(line, ""))
+		if line != "" {
+			texts = append(texts, decodeSubtitleEntities(line))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return blocks, nil
+}
+
+// parseSubtitleTimeRange 解析时间行，忽略VTT时间后面的位置等设置，返回毫秒
+func parseSubtitleTimeRange(line string) (int64, int64, error) {
+	parts := strings.SplitN(line, "-->", 2)
+	start, err := parseSubtitleTime(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, err
+	}
+	fields := strings.Fields(parts[1])
+	if len(fields) == 0 {
+		return 0, 0, fmt.Errorf("字幕时间格式错误：%s", line)
+	}
+	end, err := parseSubtitleTime(fields[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	if end < start {
+		return 0, 0, fmt.Errorf("字幕结束时间早于开始时间：%s", line)
+	}
+	return start, end, nil
+}
+
+func parseSubtitleTime(s string) (int64, error) {
+	matches := subtitleTimePattern.FindStringSubmatch(s)
+	if matches == nil {
+		return 0, fmt.Errorf("字幕时间格式错误：%s", s)
+	}
+	hours, _ := strconv.ParseInt(matches[1], 10, 64) // 省略小时时为0
+	minutes, _ := strconv.ParseInt(matches[2], 10, 64)
+	seconds, _ := strconv.ParseInt(matches[3], 10, 64)
+	// 毫秒不足三位时按小数处理，如 ,5 表示500毫秒
+	millis, _ := strconv.ParseInt((matches[4] + "00")[:3], 10, 64)
+	return ((hours*60+minutes)*60+seconds)*1000 + millis, nil
+}
+
+func formatSubtitleTime(ms int64) string {
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+func decodeSubtitleEntities(s string) string {
+	return strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&nbsp;", " ", "&quot;", "\"", "&#39;", "'").Replace(s)
+}
+
+// DedupeRollingSubtitle 去掉YouTube自动字幕滚动显示造成的重复：每条字幕会带上上一条的文字，
+// 两条之间还夹着只有上一条文字的极短字幕。去重后每条只保留新出现的文字，编号重新生成
+func DedupeRollingSubtitle(blocks []*SrtBlock) []*SrtBlock {
+	result := make([]*SrtBlock, 0, len(blocks))
+	last := ""
+	for _, block := range blocks {
+		text := block.OriginLanguageSentence
+		if last != "" {
+			if text == last {
+				continue
+			}
+			if strings.HasPrefix(text, last+" ") {
+				text = strings.TrimSpace(text[len(last):])
+			}
+		}
+		last = text
+		result = append(result, &SrtBlock{
+			Index:                  len(result) + 1,
+			Timestamp:              block.Timestamp,
+			OriginLanguageSentence: text,
+		})
+	}
+	return result
+}
@@ -0,0 +1,85 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSubtitle(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []SrtBlock
+	}{
+		{
+			name:    "srt",
+			content: "\ufeff1\r\n00:00:01,000 --> 00:00:02,500\r\nHello <i>world</i>\r\n\r\n2\r\n00:00:03,000 --> 00:00:04,000\r\nsecond line\r\ncontinued\r\n\r\n3\r\n00:00:05,000 --> 00:00:06,000\r\n\r\n",
+			want: []SrtBlock{
+				{Index: 1, Timestamp: "00:00:01,000 --> 00:00:02,500", OriginLanguageSentence: "Hello world"},
+				{Index: 2, Timestamp: "00:00:03,000 --> 00:00:04,000", OriginLanguageSentence: "second line continued"},
+			},
+		},
+		{
+			name: "vtt",
+			content: "WEBVTT\nKind: captions\nLanguage: en\n\nNOTE this is a comment\nspanning lines\n\nSTYLE\n::cue { color: red }\n\n" +
+				"intro\n00:01.5 --> 00:03.000 align:start position:0%\n<v Speaker>Tom &amp; Jerry</v>\n\n" +
+				"01:00:00.000 --> 01:00:02.250\n<00:00:00.500><c>timed</c> words\n",
+			want: []SrtBlock{
+				{Index: 1, Timestamp: "00:00:01,500 --> 00:00:03,000", OriginLanguageSentence: "Tom & Jerry"},
+				{Index: 2, Timestamp: "01:00:00,000 --> 01:00:02,250", OriginLanguageSentence: "timed words"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		blocks, err := ParseSubtitle(strings.NewReader(tt.content))
+		if err != nil {
+			t.Errorf("%s: ParseSubtitle err = %v", tt.name, err)
+			continue
+		}
+		if len(blocks) != len(tt.want) {
+			t.Errorf("%s: got %d blocks, want %d", tt.name, len(blocks), len(tt.want))
+			continue
+		}
+		for i, block := range blocks {
+			if *block != tt.want[i] {
+				t.Errorf("%s: block %d = %+v, want %+v", tt.name, i, *block, tt.want[i])
+			}
+		}
+	}
+
+	for _, content := range []string{
+		"1\n00:00:02,000 --> 00:00:01,000\ntext\n",
+		"1\n00:00:xx,000 --> 00:00:01,000\ntext\n",
+	} {
+		if _, err := ParseSubtitle(strings.NewReader(content)); err == nil {
+			t.Errorf("ParseSubtitle(%q) want err", content)
+		}
+	}
+}
+
+func TestDedupeRollingSubtitle(t *testing.T) {
+	content := "WEBVTT\n\n" +
+		"00:00:00.000 --> 00:00:02.500 align:start position:0%\n \nhello<00:00:00.500><c> world</c>\n\n" +
+		"00:00:02.500 --> 00:00:02.510\nhello world\n\n" +
+		"00:00:02.510 --> 00:00:05.000\nhello world\nthis<00:00:03.000><c> is</c><00:00:03.500><c> next</c>\n\n" +
+		"00:00:05.000 --> 00:00:05.010\nthis is next\n\n" +
+		"00:00:05.010 --> 00:00:07.000\nthis is next\nmore words\n"
+	blocks, err := ParseSubtitle(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("ParseSubtitle err = %v", err)
+	}
+	want := []SrtBlock{
+		{Index: 1, Timestamp: "00:00:00,000 --> 00:00:02,500", OriginLanguageSentence: "hello world"},
+		{Index: 2, Timestamp: "00:00:02,510 --> 00:00:05,000", OriginLanguageSentence: "this is next"},
+		{Index: 3, Timestamp: "00:00:05,010 --> 00:00:07,000", OriginLanguageSentence: "more words"},
+	}
+	got := DedupeRollingSubtitle(blocks)
+	if len(got) != len(want) {
+		t.Fatalf("got %d blocks, want %d", len(got), len(want))
+	}
+	for i, block := range got {
+		if *block != want[i] {
+			t.Errorf("block %d = %+v, want %+v", i, *block, want[i])
+		}
+	}
+}
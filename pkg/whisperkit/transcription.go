@@ -1,6 +1,7 @@
 package whisperkit
 
 import (
+	"context"
 	"encoding/json"
 	"krillin-ai/internal/storage"
 	"krillin-ai/internal/types"
@@ -13,7 +14,7 @@ import (
 	"go.uber.org/zap"
 )
 
-func (c *WhisperKitProcessor) Transcription(audioFile, language, workDir string) (*types.TranscriptionData, error) {
+func (c *WhisperKitProcessor) Transcription(ctx context.Context, audioFile, language, workDir string) (*types.TranscriptionData, error) {
 	cmdArgs := []string{
 		"transcribe",
 		"--model-path", "./models/whisperkit/openai_whisper-large-v2",
@@ -26,7 +27,7 @@ func (c *WhisperKitProcessor) Transcription(audioFile, language, workDir string)
 		"--skip-special-tokens",
 		"--audio-path", audioFile,
 	}
-	cmd := exec.Command(storage.WhisperKitPath, cmdArgs...)
+	cmd := exec.CommandContext(ctx, storage.WhisperKitPath, cmdArgs...)
 	log.GetLogger().Info("WhisperKitProcessor转录开始", zap.String("cmd", cmd.String()))
 	output, err := cmd.CombinedOutput()
 	if err != nil {